@@ -0,0 +1,616 @@
+// Package aferotest provides a conformance test suite for afero.Fs
+// implementations, so they can prove they behave like the os package.
+//
+//	func TestMyFs(t *testing.T) {
+//		aferotest.Run(t, func() afero.Fs { return NewMyFs() }, aferotest.Chown)
+//	}
+package aferotest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Capability is a feature of an Fs whose tests can be skipped.
+type Capability int
+
+const (
+	// Write is the ability to change the Fs at all. An Fs without it has
+	// to hold a few files already, which are read only.
+	Write Capability = iota
+	// Chmod is the ability to change the permission bits of files.
+	Chmod
+	// Chown is the ability to change the owner of files.
+	Chown
+	// Chtimes is the ability to change the modification time of files.
+	Chtimes
+	// Symlinks is the support of afero.Symlinker.
+	Symlinks
+)
+
+func (c Capability) String() string {
+	switch c {
+	case Write:
+		return "Write"
+	case Chmod:
+		return "Chmod"
+	case Chown:
+		return "Chown"
+	case Chtimes:
+		return "Chtimes"
+	case Symlinks:
+		return "Symlinks"
+	}
+	return "unknown"
+}
+
+type test struct {
+	name string
+	need []Capability
+	fn   func(t *testing.T, fs afero.Fs)
+}
+
+var tests = []test{
+	{"Create", []Capability{Write}, testCreate},
+	{"OpenNotExist", nil, testOpenNotExist},
+	{"OpenFileFlags", []Capability{Write}, testOpenFileFlags},
+	{"Mkdir", []Capability{Write}, testMkdir},
+	{"Remove", []Capability{Write}, testRemove},
+	{"Rename", []Capability{Write}, testRename},
+	{"Readdir", []Capability{Write}, testReaddir},
+	{"SeekRead", []Capability{Write}, testSeekRead},
+	{"WriteAtTruncate", []Capability{Write}, testWriteAtTruncate},
+	{"Chmod", []Capability{Write, Chmod}, testChmod},
+	{"Chown", []Capability{Write, Chown}, testChown},
+	{"Chtimes", []Capability{Write, Chtimes}, testChtimes},
+	{"Symlinks", []Capability{Write, Symlinks}, testSymlinks},
+	{"Walk", nil, testWalk},
+	{"ReaddirEOF", nil, testReaddirEOF},
+	{"SeekPastEOF", nil, testSeekPastEOF},
+}
+
+// readOnlyTests only run on filesystems lacking Write.
+var readOnlyTests = []test{
+	{"ReadOnly", nil, testReadOnly},
+}
+
+// Run runs the suite against the filesystems returned by newFs, which is
+// called once for every test. The tests of the capabilities listed in
+// missing are skipped.
+//
+// A writable Fs is expected to be empty, while an Fs missing Write has to
+// hold a few files and directories.
+func Run(t *testing.T, newFs func() afero.Fs, missing ...Capability) {
+	lacks := make(map[Capability]bool)
+	for _, c := range missing {
+		lacks[c] = true
+	}
+	all := append([]test{}, tests...)
+	if lacks[Write] {
+		all = append(all, readOnlyTests...)
+	}
+	for _, tt := range all {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range tt.need {
+				if lacks[c] {
+					t.Skipf("the Fs lacks %s", c)
+				}
+			}
+			fs := newFs()
+			if !lacks[Write] {
+				writeFixture(t, fs)
+			}
+			tt.fn(t, fs)
+		})
+	}
+}
+
+// writeFixture gives a writable Fs the content expected by the tests which
+// run on read only ones as well.
+func writeFixture(t *testing.T, fs afero.Fs) {
+	t.Helper()
+	if err := fs.MkdirAll("/fixture/sub", 0755); err != nil {
+		t.Fatalf("%s: MkdirAll: %v", fs.Name(), err)
+	}
+	for _, name := range []string{"/fixture/a.txt", "/fixture/sub/b.txt"} {
+		if err := afero.WriteFile(fs, name, []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("%s: WriteFile: %v", fs.Name(), err)
+		}
+	}
+}
+
+func writeFile(t *testing.T, fs afero.Fs, name, content string) {
+	t.Helper()
+	if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+		t.Fatalf("%s: WriteFile %s: %v", fs.Name(), name, err)
+	}
+}
+
+func readFile(t *testing.T, fs afero.Fs, name string) string {
+	t.Helper()
+	b, err := afero.ReadFile(fs, name)
+	if err != nil {
+		t.Fatalf("%s: ReadFile %s: %v", fs.Name(), name, err)
+	}
+	return string(b)
+}
+
+// firstFile returns the name and size of the first regular file found in
+// fs, skipping the test if there is none.
+func firstFile(t *testing.T, fs afero.Fs) (string, int64) {
+	t.Helper()
+	var name string
+	var size int64
+	afero.Walk(fs, "/", func(p string, info os.FileInfo, err error) error {
+		if err == nil && name == "" && info.Mode().IsRegular() {
+			name, size = p, info.Size()
+		}
+		return nil
+	})
+	if name == "" {
+		t.Skip("no file to read")
+	}
+	return name, size
+}
+
+// isEOF reports whether err tells a read started past the end of a file.
+// MemMapFs returns io.ErrUnexpectedEOF there, the os package io.EOF.
+func isEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+func testCreate(t *testing.T, fs afero.Fs) {
+	if fs.Name() == "" {
+		t.Error("Name is empty")
+	}
+
+	f, err := fs.Create("/file.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if path.Base(f.Name()) != "file.txt" {
+		t.Errorf("File.Name: got %q", f.Name())
+	}
+	if n, err := f.WriteString("hello"); err != nil || n != 5 {
+		t.Errorf("WriteString: %d, %v", n, err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Errorf("Sync: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+
+	fi, err := fs.Stat("/file.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if fi.Name() != "file.txt" || fi.Size() != 5 || fi.IsDir() {
+		t.Errorf("Stat: got name %q, size %d, dir %t", fi.Name(), fi.Size(), fi.IsDir())
+	}
+
+	// Create truncates existing files
+	f, err = fs.Create("/file.txt")
+	if err != nil {
+		t.Fatalf("Create existing: %v", err)
+	}
+	f.Write([]byte("hi"))
+	f.Close()
+	if got := readFile(t, fs, "/file.txt"); got != "hi" {
+		t.Errorf("Create should truncate, got %q", got)
+	}
+
+	// but not replace directories
+	if f, err := fs.Create("/fixture"); err == nil {
+		f.Close()
+		t.Errorf("Create of a directory should fail")
+	}
+	if got := readFile(t, fs, "/fixture/sub/b.txt"); got != "content of /fixture/sub/b.txt" {
+		t.Errorf("file below a directory created again: got %q", got)
+	}
+}
+
+func testOpenNotExist(t *testing.T, fs afero.Fs) {
+	if _, err := fs.Open("/does-not-exist"); !os.IsNotExist(err) {
+		t.Errorf("Open: expected a not exist error, got %v", err)
+	}
+	if _, err := fs.Stat("/does-not-exist"); !os.IsNotExist(err) {
+		t.Errorf("Stat: expected a not exist error, got %v", err)
+	}
+	if _, err := fs.OpenFile("/does-not-exist", os.O_RDONLY, 0); !os.IsNotExist(err) {
+		t.Errorf("OpenFile: expected a not exist error, got %v", err)
+	}
+}
+
+func testOpenFileFlags(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file.txt", "a")
+
+	if _, err := fs.OpenFile("/file.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Errorf("O_EXCL on an existing file: expected an exist error, got %v", err)
+	}
+	f, err := fs.OpenFile("/new.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Errorf("O_EXCL on a new file: %v", err)
+	} else {
+		f.Close()
+	}
+
+	f, err = fs.OpenFile("/file.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("O_APPEND: %v", err)
+	}
+	f.Write([]byte("b"))
+	f.Close()
+	if got := readFile(t, fs, "/file.txt"); got != "ab" {
+		t.Errorf("O_APPEND: got %q", got)
+	}
+
+	f, err = fs.OpenFile("/file.txt", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatalf("O_TRUNC: %v", err)
+	}
+	f.Write([]byte("c"))
+	f.Close()
+	if got := readFile(t, fs, "/file.txt"); got != "c" {
+		t.Errorf("O_TRUNC: got %q", got)
+	}
+}
+
+func testMkdir(t *testing.T, fs afero.Fs) {
+	if err := fs.Mkdir("/newdir", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if fi, err := fs.Stat("/newdir"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat of a new directory: %v, %v", fi, err)
+	}
+	if err := fs.Mkdir("/newdir", 0755); err == nil {
+		t.Error("Mkdir of an existing directory should fail")
+	}
+	if err := fs.MkdirAll("/newdir/a/b/c", 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := fs.MkdirAll("/newdir/a/b/c", 0755); err != nil {
+		t.Errorf("MkdirAll of an existing directory: %v", err)
+	}
+	if fi, err := fs.Stat("/newdir/a/b"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat of a directory made by MkdirAll: %v, %v", fi, err)
+	}
+}
+
+func testRemove(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file.txt", "x")
+	if err := fs.Remove("/file.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := fs.Stat("/file.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a removed file: %v", err)
+	}
+	if err := fs.Remove("/file.txt"); err == nil {
+		t.Error("Remove of a missing file should fail")
+	}
+
+	if err := fs.RemoveAll("/fixture"); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	for _, name := range []string{"/fixture", "/fixture/sub", "/fixture/sub/b.txt"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("Stat of %s after RemoveAll: %v", name, err)
+		}
+	}
+	if err := fs.RemoveAll("/does-not-exist"); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+}
+
+func testRename(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/old.txt", "content")
+	if err := fs.Rename("/old.txt", "/fixture/new.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := fs.Stat("/old.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat of the old name: %v", err)
+	}
+	if got := readFile(t, fs, "/fixture/new.txt"); got != "content" {
+		t.Errorf("renamed file: got %q", got)
+	}
+
+	// the directory of the new name must exist
+	if err := fs.Rename("/fixture/new.txt", "/no/such/new.txt"); !os.IsNotExist(err) {
+		t.Errorf("Rename into a missing directory: expected a not exist error, got %v", err)
+	}
+	if _, err := fs.Stat("/no"); !os.IsNotExist(err) {
+		t.Errorf("Rename created the missing directory: %v", err)
+	}
+	if got := readFile(t, fs, "/fixture/new.txt"); got != "content" {
+		t.Errorf("file after a failed rename: got %q", got)
+	}
+}
+
+func testReaddir(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/fixture/c.txt", "c")
+
+	f, err := fs.Open("/fixture")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		t.Errorf("File.Stat of a directory: %v, %v", fi, err)
+	}
+
+	var names []string
+	for {
+		fis, err := f.Readdir(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdir: %v", err)
+		}
+		if len(fis) == 0 || len(fis) > 2 {
+			t.Fatalf("Readdir(2) returned %d entries", len(fis))
+		}
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if len(names) > 3 {
+			t.Fatalf("Readdir returned more entries than there are: %v", names)
+		}
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "a.txt" || names[1] != "c.txt" || names[2] != "sub" {
+		t.Errorf("Readdir: got %v", names)
+	}
+
+	f2, err := fs.Open("/fixture")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f2.Close()
+	all, err := f2.Readdirnames(-1)
+	if err != nil || len(all) != 3 {
+		t.Errorf("Readdirnames(-1): %v, %v", all, err)
+	}
+}
+
+func testSeekRead(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file.txt", "0123456789")
+
+	f, err := fs.Open("/file.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 3)
+	if pos, err := f.Seek(2, io.SeekStart); err != nil || pos != 2 {
+		t.Errorf("Seek from the start: %d, %v", pos, err)
+	}
+	if n, err := io.ReadFull(f, buf); err != nil || string(buf[:n]) != "234" {
+		t.Errorf("Read: %q, %v", buf[:n], err)
+	}
+	if pos, err := f.Seek(1, io.SeekCurrent); err != nil || pos != 6 {
+		t.Errorf("Seek from the current offset: %d, %v", pos, err)
+	}
+	if pos, err := f.Seek(-2, io.SeekEnd); err != nil || pos != 8 {
+		t.Errorf("Seek from the end: %d, %v", pos, err)
+	}
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "89" {
+		t.Errorf("Read to the end: %q, %v", b, err)
+	}
+	if n, err := f.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end: %d, %v", n, err)
+	}
+
+	if n, err := f.ReadAt(buf, 8); n != 2 || err != io.EOF {
+		t.Errorf("ReadAt across the end: %d, %v", n, err)
+	}
+	if n, err := f.ReadAt(buf, 1); n != 3 || err != nil || string(buf) != "123" {
+		t.Errorf("ReadAt: %q, %v", buf[:n], err)
+	}
+}
+
+func testWriteAtTruncate(t *testing.T, fs afero.Fs) {
+	f, err := fs.OpenFile("/file.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	f.WriteString("0123456789")
+	if n, err := f.WriteAt([]byte("ab"), 4); err != nil || n != 2 {
+		t.Errorf("WriteAt: %d, %v", n, err)
+	}
+	if err := f.Truncate(8); err != nil {
+		t.Errorf("Truncate: %v", err)
+	}
+	if err := f.Truncate(10); err != nil {
+		t.Errorf("Truncate to grow: %v", err)
+	}
+	f.Close()
+
+	if got := readFile(t, fs, "/file.txt"); got != "0123ab67\x00\x00" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func testChmod(t *testing.T, fs afero.Fs) {
+	if err := fs.Chmod("/fixture/a.txt", 0600); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if fi, err := fs.Stat("/fixture/a.txt"); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Stat after Chmod: %v, %v", fi, err)
+	}
+	if err := fs.Chmod("/does-not-exist", 0600); !os.IsNotExist(err) {
+		t.Errorf("Chmod of a missing file: %v", err)
+	}
+}
+
+func testChown(t *testing.T, fs afero.Fs) {
+	if err := fs.Chown("/fixture/a.txt", os.Getuid(), os.Getgid()); err != nil {
+		t.Errorf("Chown: %v", err)
+	}
+	if err := fs.Chown("/does-not-exist", os.Getuid(), os.Getgid()); !os.IsNotExist(err) {
+		t.Errorf("Chown of a missing file: %v", err)
+	}
+}
+
+func testChtimes(t *testing.T, fs afero.Fs) {
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := fs.Chtimes("/fixture/a.txt", mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	if fi, err := fs.Stat("/fixture/a.txt"); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("Stat after Chtimes: %v, %v", fi, err)
+	}
+}
+
+func testSymlinks(t *testing.T, fs afero.Fs) {
+	linker, ok := fs.(afero.Symlinker)
+	if !ok {
+		t.Fatalf("%s does not implement afero.Symlinker", fs.Name())
+	}
+	if err := linker.SymlinkIfPossible("/fixture/a.txt", "/link"); err != nil {
+		t.Fatalf("SymlinkIfPossible: %v", err)
+	}
+	if target, err := linker.ReadlinkIfPossible("/link"); err != nil || target != "/fixture/a.txt" {
+		t.Errorf("ReadlinkIfPossible: %q, %v", target, err)
+	}
+	if fi, _, err := linker.LstatIfPossible("/link"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("LstatIfPossible: %v, %v", fi, err)
+	}
+	if got := readFile(t, fs, "/link"); got != "content of /fixture/a.txt" {
+		t.Errorf("reading through the link: %q", got)
+	}
+}
+
+func testReadOnly(t *testing.T, fs afero.Fs) {
+	name, _ := firstFile(t, fs)
+	if _, err := fs.Create("/new.txt"); err == nil {
+		t.Error("Create should fail")
+	}
+	if err := fs.Mkdir("/new", 0755); err == nil {
+		t.Error("Mkdir should fail")
+	}
+	if _, err := fs.OpenFile(name, os.O_WRONLY, 0); err == nil {
+		t.Errorf("opening %s for writing should fail", name)
+	}
+	if err := fs.Remove(name); err == nil {
+		t.Errorf("removing %s should fail", name)
+	}
+	if _, err := fs.Stat(name); err != nil {
+		t.Errorf("%s is gone: %v", name, err)
+	}
+}
+
+func testWalk(t *testing.T, fs afero.Fs) {
+	if fi, err := fs.Stat("/"); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat of the root: %v, %v", fi, err)
+	}
+	err := afero.Walk(fs, "/", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if lstater, ok := fs.(afero.Lstater); ok {
+			if lfi, _, err := lstater.LstatIfPossible(p); err != nil || lfi.IsDir() != info.IsDir() {
+				t.Errorf("LstatIfPossible %s: %v, %v", p, lfi, err)
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := fs.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() != info.Size() || fi.Name() != info.Name() {
+			t.Errorf("%s: File.Stat gives %s of %d bytes, Fs.Stat %s of %d bytes", p, fi.Name(), fi.Size(), info.Name(), info.Size())
+		}
+		var buf bytes.Buffer
+		if n, err := io.Copy(&buf, f); err != nil || n != info.Size() {
+			t.Errorf("%s: read %d of %d bytes: %v", p, n, info.Size(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Walk: %v", err)
+	}
+}
+
+func testReaddirEOF(t *testing.T, fs afero.Fs) {
+	f, err := fs.Open("/")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	count := 0
+	for {
+		fis, err := f.Readdir(1)
+		if err == io.EOF {
+			if len(fis) != 0 {
+				t.Errorf("Readdir returned entries along with io.EOF")
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdir: %v", err)
+		}
+		if len(fis) != 1 {
+			t.Fatalf("Readdir(1) returned %d entries", len(fis))
+		}
+		if count++; count > 10000 {
+			t.Fatal("Readdir(1) does not reach the end of the directory")
+		}
+	}
+	if count == 0 {
+		t.Error("the root is empty")
+	}
+	if fis, err := f.Readdir(1); err != io.EOF || len(fis) != 0 {
+		t.Errorf("Readdir after the end: %v, %v", fis, err)
+	}
+
+	f2, err := fs.Open("/")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f2.Close()
+	names, err := f2.Readdirnames(-1)
+	if err != nil || len(names) != count {
+		t.Errorf("Readdirnames(-1): %d names, %v; Readdir(1) found %d", len(names), err, count)
+	}
+}
+
+func testSeekPastEOF(t *testing.T, fs afero.Fs) {
+	name, size := firstFile(t, fs)
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	if pos, err := f.Seek(size+10, io.SeekStart); err != nil || pos != size+10 {
+		t.Errorf("Seek past the end: %d, %v", pos, err)
+	}
+	buf := make([]byte, 4)
+	if n, err := f.Read(buf); n != 0 || !isEOF(err) {
+		t.Errorf("Read past the end: %d, %v", n, err)
+	}
+	if n, err := f.ReadAt(buf, size+10); n != 0 || !isEOF(err) {
+		t.Errorf("ReadAt past the end: %d, %v", n, err)
+	}
+}
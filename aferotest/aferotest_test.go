@@ -0,0 +1,151 @@
+package aferotest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+// populated returns a MemMapFs holding a few files, for the read only
+// filesystems.
+func populated() afero.Fs {
+	fs := afero.NewMemMapFs()
+	fs.MkdirAll("/base/sub", 0755)
+	afero.WriteFile(fs, "/base.txt", []byte("base"), 0644)
+	afero.WriteFile(fs, "/base/sub/nested.txt", []byte("nested"), 0644)
+	return fs
+}
+
+func TestMemMapFs(t *testing.T) {
+	aferotest.Run(t, afero.NewMemMapFs)
+}
+
+func TestOsFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aferotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := 0
+	aferotest.Run(t, func() afero.Fs {
+		n++
+		base := dir + string(os.PathSeparator) + strconv.Itoa(n)
+		if err := os.Mkdir(base, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return afero.NewBasePathFs(afero.NewOsFs(), base)
+	})
+}
+
+func TestBasePathFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		fs := afero.NewMemMapFs()
+		fs.Mkdir("/base", 0755)
+		return afero.NewBasePathFs(fs, "/base")
+	})
+}
+
+func TestCopyOnWriteFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewCopyOnWriteFs(afero.NewMemMapFs(), afero.NewMemMapFs())
+	}, aferotest.Symlinks)
+}
+
+func TestCopyOnWriteFsWithWhiteouts(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewCopyOnWriteFsWithWhiteouts(populated(), afero.NewMemMapFs(), afero.PrefixWhiteouts{})
+	}, aferotest.Symlinks)
+}
+
+func TestOverlayFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewOverlayFs(afero.NewMemMapFs(), afero.NewMemMapFs())
+	}, aferotest.Symlinks)
+}
+
+func TestMountFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		fs := afero.NewMountFs()
+		fs.Mount("/", afero.NewMemMapFs())
+		fs.Mount("/mnt", afero.NewMemMapFs())
+		return fs
+	})
+}
+
+func TestCacheOnReadFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewCacheOnReadFs(afero.NewMemMapFs(), afero.NewMemMapFs(), 0)
+	}, aferotest.Symlinks)
+}
+
+func TestRegexpFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewRegexpFs(afero.NewMemMapFs(), regexp.MustCompile(`\.txt$`))
+	}, aferotest.Symlinks)
+}
+
+func TestIgnoreFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewIgnoreFs(afero.NewMemMapFs(), []string{"*.log", "build/"}, ".gitignore")
+	})
+}
+
+func TestPolicyFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		fs, err := afero.NewPolicyFs(afero.NewMemMapFs(), []afero.PolicyRule{
+			{Pattern: "/**/*.secret", Hidden: true},
+			{Pattern: "/", Access: afero.AccessAll},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+func TestAuditFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewAuditFs(afero.NewMemMapFs(), afero.NewJSONAuditSink(ioutil.Discard), nil)
+	})
+}
+
+func TestReadOnlyFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewReadOnlyFs(populated())
+	}, aferotest.Write)
+}
+
+func TestInstrumentedFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewInstrumentedFs(afero.NewMemMapFs(), &afero.InstrumentOptions{Metrics: afero.NewMemoryMetrics()})
+	})
+}
+
+func TestFaultFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		fs, err := afero.NewFaultFs(afero.NewMemMapFs(), []afero.FaultRule{
+			{Pattern: "/never/**", Err: syscall.EIO},
+		}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
+
+func TestCrashFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs { return afero.NewCrashFs(1) })
+}
+
+func TestRecordingFs(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewRecordingFs(afero.NewMemMapFs(), ioutil.Discard)
+	})
+}
@@ -0,0 +1,16 @@
+// +build go1.16
+
+package aferotest_test
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+func TestFromIOFS(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.FromIOFS{FS: afero.NewIOFS(populated())}
+	}, aferotest.Write)
+}
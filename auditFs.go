@@ -0,0 +1,467 @@
+package afero
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ Lstater   = (*AuditFs)(nil)
+	_ Symlinker = (*AuditFs)(nil)
+)
+
+// AuditRecord describes an operation made through an AuditFs, on the
+// filesystem or on one of its files. Op is the name of the method,
+// lowercased, or "fstat" for File.Stat; the fields which don't apply to
+// it are left zero.
+type AuditRecord struct {
+	Time    time.Time
+	Op      string
+	Path    string
+	NewPath string
+	Flag    int
+	Mode    os.FileMode
+	// Bytes is the number of bytes read or written, or the size a file was
+	// truncated to.
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+	Tags     map[string]string
+	// Caller is the file and line of the call, if asked for.
+	Caller string
+}
+
+// AuditSink receives the records of an AuditFs. Audit may be called from
+// several goroutines at the same time.
+type AuditSink interface {
+	Audit(AuditRecord)
+}
+
+// AuditSinkFunc adapts a function to AuditSink.
+type AuditSinkFunc func(AuditRecord)
+
+func (f AuditSinkFunc) Audit(r AuditRecord) {
+	f(r)
+}
+
+// AuditOptions tune an AuditFs. The zero value audits every operation.
+type AuditOptions struct {
+	// Tags are added to every record.
+	Tags map[string]string
+	// SampleRate audits this fraction of the operations, picked at random,
+	// if between 0 and 1. The operations on a file are audited if opening
+	// it was.
+	SampleRate float64
+	// Filter audits only the operations on the paths it accepts, if set.
+	Filter func(path string) bool
+	// Caller records where each operation was called from, outside of
+	// this package.
+	Caller bool
+}
+
+// The AuditFs passes a record of every operation made through it, and
+// through the files it opens, to a sink.
+type AuditFs struct {
+	source Fs
+	sink   AuditSink
+	opts   *AuditOptions
+	tags   map[string]string
+}
+
+// NewAuditFs returns an AuditFs recording the operations on source to
+// sink. A nil opts stands for the zero AuditOptions.
+func NewAuditFs(source Fs, sink AuditSink, opts *AuditOptions) *AuditFs {
+	if opts == nil {
+		opts = &AuditOptions{}
+	}
+	return &AuditFs{source: source, sink: sink, opts: opts, tags: opts.Tags}
+}
+
+// WithTags returns a view of a recording its operations with tags on top
+// of those of a, for instance to tell the part of a program making them.
+func (a *AuditFs) WithTags(tags map[string]string) *AuditFs {
+	merged := make(map[string]string, len(a.tags)+len(tags))
+	for k, v := range a.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	view := *a
+	view.tags = merged
+	return &view
+}
+
+// audited reports whether an operation on the paths is to be recorded.
+func (a *AuditFs) audited(paths ...string) bool {
+	if a.opts.Filter != nil {
+		accepted := false
+		for _, path := range paths {
+			accepted = accepted || a.opts.Filter(path)
+		}
+		if !accepted {
+			return false
+		}
+	}
+	rate := a.opts.SampleRate
+	return rate <= 0 || rate >= 1 || mathrand.Float64() < rate
+}
+
+// record completes r, for an operation which started at start, and passes
+// it to the sink.
+func (a *AuditFs) record(r AuditRecord, start time.Time) {
+	r.Time = start
+	r.Duration = time.Since(start)
+	r.Tags = a.tags
+	if a.opts.Caller {
+		r.Caller = auditCaller()
+	}
+	a.sink.Audit(r)
+}
+
+var auditPackage = reflect.TypeOf(AuditFs{}).PkgPath() + "."
+
+// auditCaller returns the position of the first call on the stack made
+// from outside of this package, tests aside.
+func auditCaller() string {
+	pc := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, auditPackage) || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+func (a *AuditFs) Name() string {
+	return "AuditFs"
+}
+
+func (a *AuditFs) Create(name string) (File, error) {
+	if !a.audited(name) {
+		return a.source.Create(name)
+	}
+	start := time.Now()
+	f, err := a.source.Create(name)
+	a.record(AuditRecord{Op: "create", Path: name, Err: err}, start)
+	return a.wrap(f, err)
+}
+
+func (a *AuditFs) Mkdir(name string, perm os.FileMode) error {
+	if !a.audited(name) {
+		return a.source.Mkdir(name, perm)
+	}
+	start := time.Now()
+	err := a.source.Mkdir(name, perm)
+	a.record(AuditRecord{Op: "mkdir", Path: name, Mode: perm, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) MkdirAll(path string, perm os.FileMode) error {
+	if !a.audited(path) {
+		return a.source.MkdirAll(path, perm)
+	}
+	start := time.Now()
+	err := a.source.MkdirAll(path, perm)
+	a.record(AuditRecord{Op: "mkdirall", Path: path, Mode: perm, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) Open(name string) (File, error) {
+	if !a.audited(name) {
+		return a.source.Open(name)
+	}
+	start := time.Now()
+	f, err := a.source.Open(name)
+	a.record(AuditRecord{Op: "open", Path: name, Err: err}, start)
+	return a.wrap(f, err)
+}
+
+func (a *AuditFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if !a.audited(name) {
+		return a.source.OpenFile(name, flag, perm)
+	}
+	start := time.Now()
+	f, err := a.source.OpenFile(name, flag, perm)
+	a.record(AuditRecord{Op: "openfile", Path: name, Flag: flag, Mode: perm, Err: err}, start)
+	return a.wrap(f, err)
+}
+
+// wrap returns f, opened with err, as a file recording its operations.
+func (a *AuditFs) wrap(f File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &auditFile{File: f, fs: a}, nil
+}
+
+func (a *AuditFs) Remove(name string) error {
+	if !a.audited(name) {
+		return a.source.Remove(name)
+	}
+	start := time.Now()
+	err := a.source.Remove(name)
+	a.record(AuditRecord{Op: "remove", Path: name, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) RemoveAll(path string) error {
+	if !a.audited(path) {
+		return a.source.RemoveAll(path)
+	}
+	start := time.Now()
+	err := a.source.RemoveAll(path)
+	a.record(AuditRecord{Op: "removeall", Path: path, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) Rename(oldname, newname string) error {
+	if !a.audited(oldname, newname) {
+		return a.source.Rename(oldname, newname)
+	}
+	start := time.Now()
+	err := a.source.Rename(oldname, newname)
+	a.record(AuditRecord{Op: "rename", Path: oldname, NewPath: newname, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) Stat(name string) (os.FileInfo, error) {
+	if !a.audited(name) {
+		return a.source.Stat(name)
+	}
+	start := time.Now()
+	fi, err := a.source.Stat(name)
+	a.record(AuditRecord{Op: "stat", Path: name, Err: err}, start)
+	return fi, err
+}
+
+func (a *AuditFs) Chmod(name string, mode os.FileMode) error {
+	if !a.audited(name) {
+		return a.source.Chmod(name, mode)
+	}
+	start := time.Now()
+	err := a.source.Chmod(name, mode)
+	a.record(AuditRecord{Op: "chmod", Path: name, Mode: mode, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) Chown(name string, uid, gid int) error {
+	if !a.audited(name) {
+		return a.source.Chown(name, uid, gid)
+	}
+	start := time.Now()
+	err := a.source.Chown(name, uid, gid)
+	a.record(AuditRecord{Op: "chown", Path: name, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) Chtimes(name string, atime, mtime time.Time) error {
+	if !a.audited(name) {
+		return a.source.Chtimes(name, atime, mtime)
+	}
+	start := time.Now()
+	err := a.source.Chtimes(name, atime, mtime)
+	a.record(AuditRecord{Op: "chtimes", Path: name, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	lstat := func() (os.FileInfo, bool, error) {
+		if lsf, ok := a.source.(Lstater); ok {
+			return lsf.LstatIfPossible(name)
+		}
+		fi, err := a.source.Stat(name)
+		return fi, false, err
+	}
+	if !a.audited(name) {
+		return lstat()
+	}
+	start := time.Now()
+	fi, ok, err := lstat()
+	a.record(AuditRecord{Op: "lstat", Path: name, Err: err}, start)
+	return fi, ok, err
+}
+
+func (a *AuditFs) SymlinkIfPossible(oldname, newname string) error {
+	symlink := func() error {
+		if linker, ok := a.source.(Linker); ok {
+			return linker.SymlinkIfPossible(oldname, newname)
+		}
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	if !a.audited(newname) {
+		return symlink()
+	}
+	start := time.Now()
+	err := symlink()
+	a.record(AuditRecord{Op: "symlink", Path: newname, NewPath: oldname, Err: err}, start)
+	return err
+}
+
+func (a *AuditFs) ReadlinkIfPossible(name string) (string, error) {
+	readlink := func() (string, error) {
+		if reader, ok := a.source.(LinkReader); ok {
+			return reader.ReadlinkIfPossible(name)
+		}
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+	}
+	if !a.audited(name) {
+		return readlink()
+	}
+	start := time.Now()
+	target, err := readlink()
+	a.record(AuditRecord{Op: "readlink", Path: name, Err: err}, start)
+	return target, err
+}
+
+// auditFile records the operations on a file opened through an AuditFs.
+type auditFile struct {
+	File
+	fs *AuditFs
+}
+
+func (f *auditFile) Close() error {
+	start := time.Now()
+	err := f.File.Close()
+	f.fs.record(AuditRecord{Op: "close", Path: f.Name(), Err: err}, start)
+	return err
+}
+
+func (f *auditFile) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Read(p)
+	f.fs.record(AuditRecord{Op: "read", Path: f.Name(), Bytes: int64(n), Err: err}, start)
+	return n, err
+}
+
+func (f *auditFile) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.ReadAt(p, off)
+	f.fs.record(AuditRecord{Op: "readat", Path: f.Name(), Bytes: int64(n), Err: err}, start)
+	return n, err
+}
+
+func (f *auditFile) Seek(offset int64, whence int) (int64, error) {
+	start := time.Now()
+	pos, err := f.File.Seek(offset, whence)
+	f.fs.record(AuditRecord{Op: "seek", Path: f.Name(), Err: err}, start)
+	return pos, err
+}
+
+func (f *auditFile) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Write(p)
+	f.fs.record(AuditRecord{Op: "write", Path: f.Name(), Bytes: int64(n), Err: err}, start)
+	return n, err
+}
+
+func (f *auditFile) WriteAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.WriteAt(p, off)
+	f.fs.record(AuditRecord{Op: "writeat", Path: f.Name(), Bytes: int64(n), Err: err}, start)
+	return n, err
+}
+
+func (f *auditFile) WriteString(s string) (int, error) {
+	start := time.Now()
+	n, err := f.File.WriteString(s)
+	f.fs.record(AuditRecord{Op: "writestring", Path: f.Name(), Bytes: int64(n), Err: err}, start)
+	return n, err
+}
+
+func (f *auditFile) Readdir(count int) ([]os.FileInfo, error) {
+	start := time.Now()
+	fis, err := f.File.Readdir(count)
+	f.fs.record(AuditRecord{Op: "readdir", Path: f.Name(), Err: err}, start)
+	return fis, err
+}
+
+func (f *auditFile) Readdirnames(n int) ([]string, error) {
+	start := time.Now()
+	names, err := f.File.Readdirnames(n)
+	f.fs.record(AuditRecord{Op: "readdirnames", Path: f.Name(), Err: err}, start)
+	return names, err
+}
+
+func (f *auditFile) Stat() (os.FileInfo, error) {
+	start := time.Now()
+	fi, err := f.File.Stat()
+	f.fs.record(AuditRecord{Op: "fstat", Path: f.Name(), Err: err}, start)
+	return fi, err
+}
+
+func (f *auditFile) Sync() error {
+	start := time.Now()
+	err := f.File.Sync()
+	f.fs.record(AuditRecord{Op: "sync", Path: f.Name(), Err: err}, start)
+	return err
+}
+
+func (f *auditFile) Truncate(size int64) error {
+	start := time.Now()
+	err := f.File.Truncate(size)
+	f.fs.record(AuditRecord{Op: "truncate", Path: f.Name(), Bytes: size, Err: err}, start)
+	return err
+}
+
+// jsonAuditSink writes records as lines of JSON.
+type jsonAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// jsonAuditRecord is how an AuditRecord is written by a JSON sink.
+type jsonAuditRecord struct {
+	Time     time.Time         `json:"time"`
+	Op       string            `json:"op"`
+	Path     string            `json:"path"`
+	NewPath  string            `json:"new_path,omitempty"`
+	Flag     int               `json:"flag,omitempty"`
+	Mode     string            `json:"mode,omitempty"`
+	Bytes    int64             `json:"bytes,omitempty"`
+	Duration int64             `json:"duration_ns"`
+	Err      string            `json:"error,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Caller   string            `json:"caller,omitempty"`
+}
+
+// NewJSONAuditSink returns a sink writing every record to w as a line of
+// JSON. Write errors are ignored.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{enc: json.NewEncoder(w)}
+}
+
+func (s *jsonAuditSink) Audit(r AuditRecord) {
+	jr := jsonAuditRecord{
+		Time:     r.Time,
+		Op:       r.Op,
+		Path:     r.Path,
+		NewPath:  r.NewPath,
+		Flag:     r.Flag,
+		Bytes:    r.Bytes,
+		Duration: int64(r.Duration),
+		Tags:     r.Tags,
+		Caller:   r.Caller,
+	}
+	if r.Mode != 0 {
+		jr.Mode = r.Mode.String()
+	}
+	if r.Err != nil {
+		jr.Err = r.Err.Error()
+	}
+	s.mu.Lock()
+	s.enc.Encode(jr)
+	s.mu.Unlock()
+}
@@ -0,0 +1,62 @@
+// +build go1.21
+
+package afero
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogAuditSink passes records to a slog.Handler.
+type slogAuditSink struct {
+	handler slog.Handler
+	level   slog.Level
+}
+
+// NewSlogAuditSink returns a sink passing every record to h as a log
+// record at level, or at slog.LevelError if the operation failed. The
+// message is the operation, and the fields of the record which are set are
+// attributes, the tags in a group.
+func NewSlogAuditSink(h slog.Handler, level slog.Level) AuditSink {
+	return &slogAuditSink{handler: h, level: level}
+}
+
+func (s *slogAuditSink) Audit(r AuditRecord) {
+	level := s.level
+	if r.Err != nil {
+		level = slog.LevelError
+	}
+	ctx := context.Background()
+	if !s.handler.Enabled(ctx, level) {
+		return
+	}
+	record := slog.NewRecord(r.Time, level, r.Op, 0)
+	record.AddAttrs(slog.String("path", r.Path))
+	if r.NewPath != "" {
+		record.AddAttrs(slog.String("new_path", r.NewPath))
+	}
+	if r.Flag != 0 {
+		record.AddAttrs(slog.Int("flag", r.Flag))
+	}
+	if r.Mode != 0 {
+		record.AddAttrs(slog.String("mode", r.Mode.String()))
+	}
+	if r.Bytes != 0 {
+		record.AddAttrs(slog.Int64("bytes", r.Bytes))
+	}
+	record.AddAttrs(slog.Duration("duration", r.Duration))
+	if r.Err != nil {
+		record.AddAttrs(slog.String("error", r.Err.Error()))
+	}
+	if len(r.Tags) > 0 {
+		tags := make([]interface{}, 0, len(r.Tags))
+		for k, v := range r.Tags {
+			tags = append(tags, slog.String(k, v))
+		}
+		record.AddAttrs(slog.Group("tags", tags...))
+	}
+	if r.Caller != "" {
+		record.AddAttrs(slog.String("caller", r.Caller))
+	}
+	s.handler.Handle(ctx, record)
+}
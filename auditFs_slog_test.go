@@ -0,0 +1,26 @@
+// +build go1.21
+
+package afero
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	fs := NewAuditFs(&MemMapFs{}, NewSlogAuditSink(handler, slog.LevelDebug), &AuditOptions{Tags: map[string]string{"app": "test"}})
+	fs.Mkdir("/dir", 0755)
+	fs.Remove("/missing")
+
+	out := buf.String()
+	if strings.Contains(out, "msg=mkdir") {
+		t.Errorf("got %q, expected debug records to be left out", out)
+	}
+	if !strings.Contains(out, "level=ERROR msg=remove path=/missing") || !strings.Contains(out, "tags.app=test") {
+		t.Errorf("got %q", out)
+	}
+}
@@ -0,0 +1,135 @@
+package afero
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// auditLog collects records.
+type auditLog struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (l *auditLog) Audit(r AuditRecord) {
+	l.mu.Lock()
+	l.records = append(l.records, r)
+	l.mu.Unlock()
+}
+
+func (l *auditLog) ops() []string {
+	var ops []string
+	for _, r := range l.records {
+		ops = append(ops, r.Op+" "+r.Path)
+	}
+	return ops
+}
+
+func TestAuditFs(t *testing.T) {
+	log := &auditLog{}
+	fs := NewAuditFs(&MemMapFs{}, log, &AuditOptions{Tags: map[string]string{"app": "test"}})
+	if err := WriteFile(fs, "/a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cleanup := fs.WithTags(map[string]string{"part": "cleanup"})
+	cleanup.Rename("/a.txt", "/b.txt")
+	cleanup.Remove("/a.txt")
+
+	expected := []string{"openfile /a.txt", "write /a.txt", "close /a.txt", "rename /a.txt", "remove /a.txt"}
+	if ops := log.ops(); !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("got %v, expected %v", ops, expected)
+	}
+	open, write, rename, remove := log.records[0], log.records[1], log.records[3], log.records[4]
+	if open.Flag != os.O_WRONLY|os.O_CREATE|os.O_TRUNC || open.Mode != 0644 {
+		t.Errorf("openfile: got flag %d and mode %v", open.Flag, open.Mode)
+	}
+	if write.Bytes != 5 {
+		t.Errorf("write: got %d bytes", write.Bytes)
+	}
+	if rename.NewPath != "/b.txt" || !reflect.DeepEqual(rename.Tags, map[string]string{"app": "test", "part": "cleanup"}) {
+		t.Errorf("rename: got %+v", rename)
+	}
+	if !os.IsNotExist(remove.Err) {
+		t.Errorf("remove: got error %v", remove.Err)
+	}
+	if !reflect.DeepEqual(log.records[0].Tags, map[string]string{"app": "test"}) {
+		t.Errorf("got tags %v, expected those of the options only", log.records[0].Tags)
+	}
+}
+
+func TestAuditFsOptions(t *testing.T) {
+	log := &auditLog{}
+	fs := NewAuditFs(&MemMapFs{}, log, &AuditOptions{
+		Filter: func(path string) bool { return strings.HasPrefix(path, "/audited") },
+		Caller: true,
+	})
+	fs.Mkdir("/audited", 0755)
+	fs.Mkdir("/quiet", 0755)
+	fs.Rename("/quiet", "/audited/moved")
+	if ops := log.ops(); !reflect.DeepEqual(ops, []string{"mkdir /audited", "rename /quiet"}) {
+		t.Errorf("got %v", ops)
+	}
+	if caller := log.records[0].Caller; !strings.Contains(caller, "auditFs_test.go:") {
+		t.Errorf("got caller %q", caller)
+	}
+
+	log.records = nil
+	fs = NewAuditFs(&MemMapFs{}, log, &AuditOptions{SampleRate: 1e-12})
+	for i := 0; i < 100; i++ {
+		fs.Stat("/")
+	}
+	if len(log.records) > 1 {
+		t.Errorf("got %d records for a tiny sample rate", len(log.records))
+	}
+}
+
+func TestAuditFsSymlinks(t *testing.T) {
+	log := &auditLog{}
+	fs := NewAuditFs(&MemMapFs{}, log, nil)
+	WriteFile(fs, "/target", nil, 0644)
+	if err := fs.SymlinkIfPossible("/target", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, lstat, err := fs.LstatIfPossible("/link"); err != nil || !lstat || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat: got %v, %v, %v", fi, lstat, err)
+	}
+	if target, err := fs.ReadlinkIfPossible("/link"); err != nil || target != "/target" {
+		t.Errorf("readlink: got %q, %v", target, err)
+	}
+
+	plain := NewAuditFs(NewReadOnlyFs(&MemMapFs{}), log, nil)
+	if err := plain.SymlinkIfPossible("/target", "/link"); err == nil {
+		t.Error("expected symlink to fail on a read only source")
+	}
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	fs := NewAuditFs(&MemMapFs{}, NewJSONAuditSink(&buf), &AuditOptions{Tags: map[string]string{"app": "test"}})
+	fs.Mkdir("/dir", 0750)
+	fs.Remove("/missing")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q", buf.String())
+	}
+	var mkdir, remove map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &mkdir); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(lines[1]), &remove)
+	if mkdir["op"] != "mkdir" || mkdir["path"] != "/dir" || mkdir["mode"] != "-rwxr-x---" || mkdir["error"] != nil {
+		t.Errorf("mkdir: got %s", lines[0])
+	}
+	if tags, _ := mkdir["tags"].(map[string]interface{}); tags["app"] != "test" {
+		t.Errorf("mkdir: got %s", lines[0])
+	}
+	if remove["op"] != "remove" || remove["error"] == nil {
+		t.Errorf("remove: got %s", lines[1])
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"time"
 )
 
-var _ Lstater = (*BasePathFs)(nil)
+var (
+	_ Lstater = (*BasePathFs)(nil)
+	_ Watcher = (*BasePathFs)(nil)
+)
 
 // The BasePathFs restricts all operations to a given path within an Fs.
 // The given file name to the operations on this Fs will be prepended with
@@ -19,8 +22,9 @@ var _ Lstater = (*BasePathFs)(nil)
 // Note that it does not clean the error messages on return, so you may
 // reveal the real path on errors.
 type BasePathFs struct {
-	source Fs
-	path   string
+	source   Fs
+	path     string
+	watching watchForwarder
 }
 
 type BasePathFile struct {
@@ -205,7 +209,74 @@ func (b *BasePathFs) ReadlinkIfPossible(name string) (string, error) {
 		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
 	}
 	if reader, ok := b.source.(LinkReader); ok {
-		return reader.ReadlinkIfPossible(name)
+		target, err := reader.ReadlinkIfPossible(name)
+		if err != nil {
+			return "", err
+		}
+		// absolute targets were made real by SymlinkIfPossible
+		if path, ok := b.virtualPath(target); ok {
+			return path, nil
+		}
+		return target, nil
 	}
 	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
 }
+
+// virtualPath is the reverse of RealPath. It reports false for paths outside
+// the base path.
+func (b *BasePathFs) virtualPath(path string) (string, bool) {
+	bpath := filepath.Clean(b.path)
+	if filepath.IsAbs(path) && !filepath.IsAbs(bpath) {
+		bpath = filepath.Join(FilePathSeparator, bpath)
+	}
+	return trimBasePath(bpath, path)
+}
+
+func trimBasePath(bpath, path string) (string, bool) {
+	if path == bpath {
+		return FilePathSeparator, true
+	}
+	if strings.HasPrefix(path, strings.TrimSuffix(bpath, FilePathSeparator)+FilePathSeparator) {
+		return filepath.Join(FilePathSeparator, strings.TrimPrefix(path, bpath)), true
+	}
+	return path, false
+}
+
+// Watch forwards the events of the source filesystem, with the base path
+// taken off their names.
+func (b *BasePathFs) Watch(name string, recursive bool) (<-chan Event, error) {
+	path, err := b.RealPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	watcher, ok := b.source.(Watcher)
+	if !ok {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: ErrNoWatch}
+	}
+	events, err := watcher.Watch(path, recursive)
+	if err != nil {
+		return nil, err
+	}
+	translate := b.virtualPath
+	if !filepath.IsAbs(b.path) {
+		// watchers of the os report absolute names, also for a relative
+		// base path, whichever Fs they are wrapped in
+		if bpath, err := filepath.Abs(b.path); err == nil {
+			translate = func(path string) (string, bool) {
+				if name, ok := trimBasePath(bpath, path); ok {
+					return name, true
+				}
+				return b.virtualPath(path)
+			}
+		}
+	}
+	return b.watching.forward(events, translate), nil
+}
+
+func (b *BasePathFs) Unwatch(events <-chan Event) error {
+	source, err := b.watching.remove(events)
+	if err != nil {
+		return err
+	}
+	return b.source.(Watcher).Unwatch(source)
+}
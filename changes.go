@@ -0,0 +1,299 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ChangeKind tells how a path differs between two filesystems.
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeModified
+	ChangeDeleted
+	// ChangeTypeChanged is a path which is a file on one side and a
+	// directory or symbolic link on the other, for instance.
+	ChangeTypeChanged
+	// ChangeModeChanged is a path whose permissions differ, but nothing
+	// else.
+	ChangeModeChanged
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeTypeChanged:
+		return "type changed"
+	case ChangeModeChanged:
+		return "mode changed"
+	}
+	return "unknown"
+}
+
+// Change is a path which differs between two filesystems.
+type Change struct {
+	Path string
+	Kind ChangeKind
+	// Diff is a unified diff of the contents, if asked for from Diff.
+	Diff string
+}
+
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+// Changes lists the paths which the layer adds to, modifies in or, using
+// Whiteouts, deletes from the base, sorted by path. Directories present in
+// both are only listed if they replace a deleted one or if their
+// permissions or modification times differ, and files copied up to the
+// layer only if their contents, permissions or modification times changed
+// since, as opening a file for writing copies it up whether it is written
+// or not.
+func (u *CopyOnWriteFs) Changes() ([]Change, error) {
+	changes, _, err := u.changes()
+	return changes, err
+}
+
+// changes is Changes, which also tells which of the changes are directories
+// merged with those of the base, rather than replacing them.
+func (u *CopyOnWriteFs) changes() ([]Change, map[string]bool, error) {
+	var changes []Change
+	merged := make(map[string]bool)
+	err := u.walkChanges(FilePathSeparator, func(c Change, _ os.FileInfo, isMerged bool) error {
+		changes = append(changes, c)
+		if isMerged {
+			merged[c.Path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, merged, nil
+}
+
+// walkChanges calls fn with every change below the directory dir of the
+// layer, the info of the layer for those not deleted, and whether the
+// change is to a directory merged with that of the base.
+func (u *CopyOnWriteFs) walkChanges(dir string, fn func(c Change, fi os.FileInfo, merged bool) error) error {
+	lofi, err := ReadDir(u.layer, dir)
+	if err != nil {
+		return err
+	}
+	var bofi []os.FileInfo
+	if isaDir, _ := u.isBaseDir(dir); isaDir {
+		if bofi, err = ReadDir(u.base, dir); err != nil {
+			return err
+		}
+	}
+
+	lvisible, bvisible := lofi, bofi
+	if u.whiteouts != nil {
+		lvisible, bvisible = u.whiteouts.Filter(lofi, bofi)
+	}
+	inBaseDir := make(map[string]bool)
+	for _, fi := range bofi {
+		inBaseDir[fi.Name()] = true
+	}
+	inLayer := make(map[string]bool)
+	for _, fi := range lvisible {
+		inLayer[fi.Name()] = true
+	}
+	inBase := make(map[string]os.FileInfo)
+	for _, fi := range bvisible {
+		inBase[fi.Name()] = fi
+	}
+	for _, fi := range bofi {
+		if _, ok := inBase[fi.Name()]; !ok && !inLayer[fi.Name()] {
+			if err := fn(Change{Path: filepath.Join(dir, fi.Name()), Kind: ChangeDeleted}, nil, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, fi := range lvisible {
+		name := filepath.Join(dir, fi.Name())
+		bfi, merged := inBase[fi.Name()]
+		merged = merged && fi.IsDir() && bfi.IsDir()
+		change := &Change{Path: name, Kind: ChangeAdded}
+		if bfi != nil {
+			if change, err = u.layerChange(name, bfi, fi); err != nil {
+				return err
+			}
+		} else if inBaseDir[fi.Name()] {
+			change.Kind = ChangeModified
+		}
+		if change != nil {
+			if err := fn(*change, fi, merged); err != nil {
+				return err
+			}
+		}
+		if fi.IsDir() {
+			if err := u.walkChanges(name, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// layerChange compares name, described by bfi in the base and by lfi in the
+// layer, by contents, permissions and modification time. It returns nil for
+// a file copied up and left as it was.
+func (u *CopyOnWriteFs) layerChange(name string, bfi, lfi os.FileInfo) (*Change, error) {
+	change, err := diffEntry(u.base, u.layer, name, bfi, lfi, &DiffOptions{Contents: true})
+	if change == nil && err == nil && !bfi.ModTime().Equal(lfi.ModTime()) {
+		change = &Change{Path: name, Kind: ChangeModified}
+	}
+	return change, err
+}
+
+// CommitError is returned by Commit when a change can't be applied to the
+// base.
+type CommitError struct {
+	// Applied are the changes made to the base before Change failed.
+	Applied []Change
+	Change  Change
+	Err     error
+}
+
+func (e *CommitError) Error() string {
+	return "commit " + e.Change.Path + ": " + e.Err.Error()
+}
+
+func (e *CommitError) Unwrap() error { return e.Err }
+
+// Commit applies the changes of the layer to the base, with the modes and
+// modification times they have in the layer, and empties the layer. The base
+// has to be writable, so it can't be wrapped in a ReadOnlyFs.
+//
+// Commit isn't atomic: the base has no way to stage changes. If one fails,
+// a *CommitError tells which were applied before it, and the layer is left
+// as it was, so that Commit can be called again once the cause is fixed.
+func (u *CopyOnWriteFs) Commit() error {
+	changes, merged, err := u.changes()
+	if err != nil {
+		return err
+	}
+
+	var applied, dirs []Change
+	for _, c := range changes {
+		if err := u.commitChange(c, merged[c.Path]); err != nil {
+			return &CommitError{Applied: applied, Change: c, Err: err}
+		}
+		applied = append(applied, c)
+		if c.Kind != ChangeDeleted {
+			if fi, _, err := u.layerLstat(c.Path); err == nil && fi.IsDir() {
+				dirs = append(dirs, c)
+			}
+		}
+	}
+
+	// the times of directories change while their content is written
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fi, err := u.layer.Stat(dirs[i].Path)
+		if err == nil {
+			err = u.base.Chtimes(dirs[i].Path, fi.ModTime(), fi.ModTime())
+		}
+		if err != nil {
+			return &CommitError{Applied: applied, Change: dirs[i], Err: err}
+		}
+	}
+	return u.Discard()
+}
+
+// commitChange applies c to the base. The directory of a merged change
+// keeps its content, which is left to the following changes.
+func (u *CopyOnWriteFs) commitChange(c Change, merged bool) error {
+	if c.Kind == ChangeDeleted {
+		return u.base.RemoveAll(c.Path)
+	}
+	fi, _, err := u.layerLstat(c.Path)
+	if err != nil {
+		return err
+	}
+	if merged {
+		return u.base.Chmod(c.Path, fi.Mode())
+	}
+	return u.commitFile(c.Path, fi)
+}
+
+// commitFile replaces name in the base by fi of the layer. The content of
+// directories is left to the following changes.
+func (u *CopyOnWriteFs) commitFile(name string, fi os.FileInfo) error {
+	// Whatever the base has at name goes. Directories merged with those of
+	// the base are left to commitChange.
+	if err := u.base.RemoveAll(name); err != nil {
+		return err
+	}
+	if err := u.base.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		rlayer, ok := u.layer.(LinkReader)
+		if !ok {
+			return &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+		}
+		target, err := rlayer.ReadlinkIfPossible(name)
+		if err != nil {
+			return err
+		}
+		sbase, ok := u.base.(Linker)
+		if !ok {
+			return &os.LinkError{Op: "symlink", Old: target, New: name, Err: ErrNoSymlink}
+		}
+		return sbase.SymlinkIfPossible(target, name)
+	case fi.IsDir():
+		if err := u.base.Mkdir(name, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		return u.base.Chmod(name, fi.Mode())
+	}
+
+	lfh, err := u.layer.Open(name)
+	if err != nil {
+		return err
+	}
+	defer lfh.Close()
+	bfh, err := u.base.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bfh, lfh); err != nil {
+		bfh.Close()
+		return err
+	}
+	if err := bfh.Close(); err != nil {
+		return err
+	}
+	if err := u.base.Chmod(name, fi.Mode()); err != nil {
+		return err
+	}
+	return u.base.Chtimes(name, fi.ModTime(), fi.ModTime())
+}
+
+// Discard drops everything from the layer, so the CopyOnWriteFs shows the
+// base again.
+func (u *CopyOnWriteFs) Discard() error {
+	entries, err := ReadDir(u.layer, FilePathSeparator)
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		if err := u.layer.RemoveAll(filepath.Join(FilePathSeparator, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
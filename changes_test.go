@@ -0,0 +1,248 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newChangesFixture(t *testing.T) (base Fs, ufs *CopyOnWriteFs) {
+	base = NewMemMapFs()
+	for _, name := range []string{"/dir/a.txt", "/dir/b.txt", "/old/c.txt", "/same/d.txt"} {
+		if err := WriteFile(base, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ufs = NewCopyOnWriteFsWithWhiteouts(base, NewMemMapFs(), PrefixWhiteouts{}).(*CopyOnWriteFs)
+
+	if err := WriteFile(ufs, "/dir/a.txt", []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Remove("/dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.RemoveAll("/old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.MkdirAll("/new", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(ufs, "/new/e.txt", []byte("new"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	return base, ufs
+}
+
+func TestCopyOnWriteChanges(t *testing.T) {
+	_, ufs := newChangesFixture(t)
+
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/dir/a.txt", Kind: ChangeModified},
+		{Path: "/dir/b.txt", Kind: ChangeDeleted},
+		{Path: "/new", Kind: ChangeAdded},
+		{Path: "/new/e.txt", Kind: ChangeAdded},
+		{Path: "/old", Kind: ChangeDeleted},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+}
+
+func TestCopyOnWriteChangesCopiedUp(t *testing.T) {
+	base, ufs := newChangesFixture(t)
+	// copied up as it is
+	if err := ufs.Chmod("/same/d.txt", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Chmod("/dir/a.txt", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(ufs, "/dir/a.txt", []byte("/dir/a.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := base.Stat("/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Chtimes("/dir/a.txt", fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Create("/old"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/dir/b.txt", Kind: ChangeDeleted},
+		{Path: "/new", Kind: ChangeAdded},
+		{Path: "/new/e.txt", Kind: ChangeAdded},
+		{Path: "/old", Kind: ChangeModified},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+
+	if err := ufs.Chmod("/same/d.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := (Change{Path: "/same/d.txt", Kind: ChangeModeChanged}); changes[len(changes)-1] != c {
+		t.Errorf("expected %v last, got %v", c, changes)
+	}
+}
+
+func TestCopyOnWriteCommitFailure(t *testing.T) {
+	mfs, ufs := newChangesFixture(t)
+	base, err := NewFaultFs(mfs, []FaultRule{{Pattern: "/new/e.txt", Err: syscall.ENOSPC}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ufs.base = base
+
+	err = ufs.Commit()
+	var cerr *CommitError
+	if !errors.As(err, &cerr) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected a CommitError for ENOSPC, got %v", err)
+	}
+	if cerr.Change.Path != "/new/e.txt" {
+		t.Errorf("failed at %v, expected /new/e.txt", cerr.Change)
+	}
+	applied := []Change{
+		{Path: "/dir/a.txt", Kind: ChangeModified},
+		{Path: "/dir/b.txt", Kind: ChangeDeleted},
+		{Path: "/new", Kind: ChangeAdded},
+	}
+	if !reflect.DeepEqual(cerr.Applied, applied) {
+		t.Errorf("applied %v, expected %v", cerr.Applied, applied)
+	}
+	if b, _ := ReadFile(mfs, "/dir/a.txt"); string(b) != "changed" {
+		t.Errorf("applied change missing from the base: %q", b)
+	}
+	if changes, err := ufs.Changes(); err != nil || len(changes) == 0 {
+		t.Errorf("the layer should be kept after a failure, got %v, %v", changes, err)
+	}
+}
+
+func TestCopyOnWriteCommit(t *testing.T) {
+	base, ufs := newChangesFixture(t)
+	mtime := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := ufs.Chtimes("/new/e.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ufs.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ReadFile(base, "/dir/a.txt"); err != nil || string(b) != "changed" {
+		t.Errorf("/dir/a.txt: %q, %v", b, err)
+	}
+	for _, name := range []string{"/dir/b.txt", "/old"} {
+		if _, err := base.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s should be deleted from the base, got %v", name, err)
+		}
+	}
+	fi, err := base.Stat("/new/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0640 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("/new/e.txt has mode %v and time %v", fi.Mode(), fi.ModTime())
+	}
+	if b, _ := ReadFile(base, "/same/d.txt"); string(b) != "/same/d.txt" {
+		t.Errorf("untouched file changed: %q", b)
+	}
+
+	if changes, err := ufs.Changes(); err != nil || len(changes) != 0 {
+		t.Errorf("the layer should be empty after a commit, got %v, %v", changes, err)
+	}
+}
+
+func TestCopyOnWriteCommitTimesAndModes(t *testing.T) {
+	base, ufs := newChangesFixture(t)
+	if err := ufs.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"/same/d.txt", "/old"} {
+		if err := ufs.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"/dir/a.txt", "/dir"} {
+		if err := ufs.Chmod(name, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/dir", Kind: ChangeModeChanged},
+		{Path: "/dir/a.txt", Kind: ChangeModeChanged},
+		{Path: "/old", Kind: ChangeModified},
+		{Path: "/same/d.txt", Kind: ChangeModified},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+
+	if err := ufs.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/same/d.txt", "/old"} {
+		if fi, err := base.Stat(name); err != nil || !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s: time %v, %v", name, fi.ModTime(), err)
+		}
+	}
+	for _, name := range []string{"/dir/a.txt", "/dir"} {
+		if fi, err := base.Stat(name); err != nil || fi.Mode().Perm() != 0700 {
+			t.Errorf("%s: mode %v, %v", name, fi.Mode(), err)
+		}
+	}
+	for _, name := range []string{"/dir/a.txt", "/dir/b.txt", "/old/c.txt", "/same/d.txt"} {
+		if b, err := ReadFile(base, name); err != nil || string(b) != name {
+			t.Errorf("%s: %q, %v", name, b, err)
+		}
+	}
+	if err := ufs.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := ufs.Stat("/same/d.txt"); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("/same/d.txt after discard: time %v, %v", fi.ModTime(), err)
+	}
+}
+
+func TestCopyOnWriteDiscard(t *testing.T) {
+	base, ufs := newChangesFixture(t)
+
+	if err := ufs.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/dir/a.txt", "/dir/b.txt", "/old/c.txt"} {
+		if b, err := ReadFile(ufs, name); err != nil || string(b) != name {
+			t.Errorf("%s: %q, %v", name, b, err)
+		}
+	}
+	if _, err := ufs.Stat("/new"); !os.IsNotExist(err) {
+		t.Errorf("discarded directory still visible: %v", err)
+	}
+	if _, err := base.Stat("/new"); !os.IsNotExist(err) {
+		t.Errorf("discard must not touch the base: %v", err)
+	}
+}
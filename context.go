@@ -0,0 +1,296 @@
+package afero
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+var (
+	_ Lstater    = (*contextFs)(nil)
+	_ Symlinker  = (*contextFs)(nil)
+	_ LinkReader = (*contextFs)(nil)
+	_ Lstater    = (*boundContextFs)(nil)
+	_ Symlinker  = (*boundContextFs)(nil)
+	_ LinkReader = (*boundContextFs)(nil)
+)
+
+// FsContext is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It mirrors every method of Fs, taking a context.Context as the first
+// argument, so that a single call can be cancelled or be given a deadline.
+// Filesystems backed by remote storage should honour the context natively.
+type FsContext interface {
+	// CreateContext creates a file in the filesystem, returning the file and
+	// an error, if any happens.
+	CreateContext(ctx context.Context, name string) (File, error)
+
+	// MkdirContext creates a directory in the filesystem, return an error if
+	// any happens.
+	MkdirContext(ctx context.Context, name string, perm os.FileMode) error
+
+	// MkdirAllContext creates a directory path and all parents that does not
+	// exist yet.
+	MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error
+
+	// OpenContext opens a file, returning it or an error, if any happens.
+	OpenContext(ctx context.Context, name string) (File, error)
+
+	// OpenFileContext opens a file using the given flags and the given mode.
+	OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error)
+
+	// RemoveContext removes a file identified by name, returning an error, if
+	// any happens.
+	RemoveContext(ctx context.Context, name string) error
+
+	// RemoveAllContext removes a directory path and any children it contains.
+	// It does not fail if the path does not exist (return nil).
+	RemoveAllContext(ctx context.Context, path string) error
+
+	// RenameContext renames a file.
+	RenameContext(ctx context.Context, oldname, newname string) error
+
+	// StatContext returns a FileInfo describing the named file, or an error,
+	// if any happens.
+	StatContext(ctx context.Context, name string) (os.FileInfo, error)
+
+	// The name of this FileSystem
+	Name() string
+
+	// ChmodContext changes the mode of the named file to mode.
+	ChmodContext(ctx context.Context, name string, mode os.FileMode) error
+
+	// ChownContext changes the uid and gid of the named file.
+	ChownContext(ctx context.Context, name string, uid, gid int) error
+
+	// ChtimesContext changes the access and modification times of the named
+	// file.
+	ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error
+}
+
+// WithContext binds ctx to every call made through the returned Fs. If fs
+// implements FsContext the calls go through it, otherwise ctx is checked for
+// cancellation before every call as with ToContextFs. The returned Fs reads,
+// makes and stats symbolic links if fs does.
+func WithContext(fs Fs, ctx context.Context) Fs {
+	return &boundContextFs{source: ToContextFs(fs), ctx: ctx}
+}
+
+// ToContextFs returns fs as an FsContext. If fs implements FsContext itself
+// it is returned as is, and an Fs returned by WithContext is unwrapped;
+// otherwise the context is checked for cancellation before every call is
+// forwarded to fs.
+func ToContextFs(fs Fs) FsContext {
+	if cfs, ok := fs.(FsContext); ok {
+		return cfs
+	}
+	if bfs, ok := fs.(*boundContextFs); ok {
+		return bfs.source
+	}
+	return &contextFs{source: fs}
+}
+
+// contextFs adapts a plain Fs to FsContext.
+type contextFs struct {
+	source Fs
+}
+
+func (c *contextFs) Name() string {
+	return c.source.Name()
+}
+
+func (c *contextFs) CreateContext(ctx context.Context, name string) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	return c.source.Create(name)
+}
+
+func (c *contextFs) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return c.source.Mkdir(name, perm)
+}
+
+func (c *contextFs) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	return c.source.MkdirAll(path, perm)
+}
+
+func (c *contextFs) OpenContext(ctx context.Context, name string) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return c.source.Open(name)
+}
+
+func (c *contextFs) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return c.source.OpenFile(name, flag, perm)
+}
+
+func (c *contextFs) RemoveContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return c.source.Remove(name)
+}
+
+func (c *contextFs) RemoveAllContext(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+	return c.source.RemoveAll(path)
+}
+
+func (c *contextFs) RenameContext(ctx context.Context, oldname, newname string) error {
+	if err := ctx.Err(); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return c.source.Rename(oldname, newname)
+}
+
+func (c *contextFs) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return c.source.Stat(name)
+}
+
+func (c *contextFs) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	return c.source.Chmod(name, mode)
+}
+
+func (c *contextFs) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	return c.source.Chown(name, uid, gid)
+}
+
+func (c *contextFs) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	return c.source.Chtimes(name, atime, mtime)
+}
+
+func (c *contextFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if lsf, ok := c.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := c.source.Stat(name)
+	return fi, false, err
+}
+
+func (c *contextFs) SymlinkIfPossible(oldname, newname string) error {
+	if linker, ok := c.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (c *contextFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := c.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// boundContextFs adapts an FsContext to Fs, using the same context for
+// every call.
+type boundContextFs struct {
+	source FsContext
+	ctx    context.Context
+}
+
+func (b *boundContextFs) Name() string {
+	return b.source.Name()
+}
+
+func (b *boundContextFs) Create(name string) (File, error) {
+	return b.source.CreateContext(b.ctx, name)
+}
+
+func (b *boundContextFs) Mkdir(name string, perm os.FileMode) error {
+	return b.source.MkdirContext(b.ctx, name, perm)
+}
+
+func (b *boundContextFs) MkdirAll(path string, perm os.FileMode) error {
+	return b.source.MkdirAllContext(b.ctx, path, perm)
+}
+
+func (b *boundContextFs) Open(name string) (File, error) {
+	return b.source.OpenContext(b.ctx, name)
+}
+
+func (b *boundContextFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return b.source.OpenFileContext(b.ctx, name, flag, perm)
+}
+
+func (b *boundContextFs) Remove(name string) error {
+	return b.source.RemoveContext(b.ctx, name)
+}
+
+func (b *boundContextFs) RemoveAll(path string) error {
+	return b.source.RemoveAllContext(b.ctx, path)
+}
+
+func (b *boundContextFs) Rename(oldname, newname string) error {
+	return b.source.RenameContext(b.ctx, oldname, newname)
+}
+
+func (b *boundContextFs) Stat(name string) (os.FileInfo, error) {
+	return b.source.StatContext(b.ctx, name)
+}
+
+func (b *boundContextFs) Chmod(name string, mode os.FileMode) error {
+	return b.source.ChmodContext(b.ctx, name, mode)
+}
+
+func (b *boundContextFs) Chown(name string, uid, gid int) error {
+	return b.source.ChownContext(b.ctx, name, uid, gid)
+}
+
+func (b *boundContextFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return b.source.ChtimesContext(b.ctx, name, atime, mtime)
+}
+
+func (b *boundContextFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if lsf, ok := b.source.(Lstater); ok {
+		if err := b.ctx.Err(); err != nil {
+			return nil, false, &os.PathError{Op: "lstat", Path: name, Err: err}
+		}
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := b.Stat(name)
+	return fi, false, err
+}
+
+func (b *boundContextFs) SymlinkIfPossible(oldname, newname string) error {
+	if linker, ok := b.source.(Linker); ok {
+		if err := b.ctx.Err(); err != nil {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+		}
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (b *boundContextFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := b.source.(LinkReader); ok {
+		if err := b.ctx.Err(); err != nil {
+			return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+		}
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
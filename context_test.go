@@ -0,0 +1,88 @@
+package afero
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestToContextFs(t *testing.T) {
+	fs := NewMemMapFs()
+	cfs := ToContextFs(fs)
+
+	ctx := context.Background()
+	f, err := cfs.CreateContext(ctx, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := cfs.StatContext(ctx, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := cfs.StatContext(cctx, "/a.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if err := cfs.RemoveContext(cctx, "/a.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if err := cfs.RenameContext(cctx, "/a.txt", "/b.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if _, err := fs.Stat("/a.txt"); err != nil {
+		t.Errorf("file must survive cancelled calls: %v", err)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	fs := NewMemMapFs()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := WithContext(fs, ctx)
+
+	if err := bound.MkdirAll("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.OpenFile("/dir/a.txt", os.O_CREATE|os.O_RDWR, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	if _, err := bound.Open("/dir/a.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	if c, ok := ToContextFs(bound).(*contextFs); !ok || c.source != fs {
+		t.Error("ToContextFs should unwrap an Fs returned by WithContext")
+	}
+}
+
+func TestWithContextSymlinks(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := WithContext(fs, ctx)
+
+	if err := bound.(Linker).SymlinkIfPossible("/a.txt", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := bound.(LinkReader).ReadlinkIfPossible("/link"); err != nil || target != "/a.txt" {
+		t.Errorf("readlink: %q, %v", target, err)
+	}
+	fi, lstatCalled, err := bound.(Lstater).LstatIfPossible("/link")
+	if err != nil || !lstatCalled || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat: %v, %t, %v", fi, lstatCalled, err)
+	}
+
+	cancel()
+	if _, _, err := bound.(Lstater).LstatIfPossible("/link"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
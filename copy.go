@@ -0,0 +1,484 @@
+package afero
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/afero/mem"
+)
+
+// OverwritePolicy decides what Copy does with files which exist already.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces existing files.
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteNever leaves existing files alone.
+	OverwriteNever
+	// OverwriteIfNewer replaces existing files older than their source.
+	OverwriteIfNewer
+	// OverwriteError fails the copy at the first existing file.
+	OverwriteError
+)
+
+// CopyOptions tune Copy, CopyDir and Move. The zero value copies everything,
+// one file at a time, replacing existing files, and leaves the modes,
+// times and owners of the copies to the destination filesystem.
+type CopyOptions struct {
+	// PreserveMode, PreserveTimes and PreserveOwner give the copies the
+	// permissions, modification times and uid and gid of their source.
+	PreserveMode  bool
+	PreserveTimes bool
+	PreserveOwner bool
+
+	// FollowSymlinks copies what symbolic links point to instead of the
+	// links, failing with ELOOP for a link to a directory being copied.
+	// Otherwise links are copied as links, which needs a source
+	// implementing LinkReader and a destination implementing Linker.
+	FollowSymlinks bool
+
+	Overwrite OverwritePolicy
+
+	// Include and Exclude hold patterns as understood by filepath.Match,
+	// matched against the path of every entry relative to the source. A
+	// pattern without a separator is matched against the base name as
+	// well. Excluded directories are skipped with everything below them.
+	// With Include set, only the files matching it are copied.
+	Include []string
+	Exclude []string
+
+	// Progress, if set, is called with the source path and the number of
+	// bytes copied for every entry. Calls are never concurrent.
+	Progress func(path string, n int64)
+
+	// Parallelism is the number of files copied at the same time.
+	Parallelism int
+}
+
+// Copy copies the file or directory src of srcFs to dst on dstFs. The
+// directories above dst are created as needed. A nil opts stands for the
+// zero CopyOptions.
+func Copy(srcFs Fs, src string, dstFs Fs, dst string, opts *CopyOptions) error {
+	_, err := copyTree(srcFs, src, dstFs, dst, opts, false)
+	return err
+}
+
+// CopyDir is like Copy, but fails unless src is a directory.
+func CopyDir(srcFs Fs, src string, dstFs Fs, dst string, opts *CopyOptions) error {
+	_, err := copyTree(srcFs, src, dstFs, dst, opts, true)
+	return err
+}
+
+// Move moves src of srcFs to dst on dstFs. On the same filesystem it is a
+// Rename, unless that fails across devices or existing files must not be
+// replaced. Otherwise src is copied and removed afterwards. Include and
+// Exclude are ignored. If files are left out because they exist already, the
+// source is kept and the error wraps ErrFileExists.
+func Move(srcFs Fs, src string, dstFs Fs, dst string, opts *CopyOptions) error {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	if sameFs(srcFs, dstFs) {
+		exists := false
+		if opts.Overwrite != OverwriteAlways {
+			_, err := lstatIfPossible(dstFs, dst)
+			exists = err == nil
+		}
+		if !exists {
+			err := srcFs.Rename(src, dst)
+			if err == nil || !errors.Is(err, syscall.EXDEV) {
+				return err
+			}
+		}
+	}
+
+	all := *opts
+	all.Include, all.Exclude = nil, nil
+	skipped, err := copyTree(srcFs, src, dstFs, dst, &all, false)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		return &os.PathError{Op: "move", Path: src, Err: ErrFileExists}
+	}
+	return srcFs.RemoveAll(src)
+}
+
+// sameFs reports whether a and b are the same filesystem, without
+// comparing values which can't be compared.
+func sameFs(a, b Fs) bool {
+	if _, ok := a.(*OsFs); ok {
+		_, ok = b.(*OsFs)
+		return ok
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// copier holds the state of a single Copy.
+type copier struct {
+	src, dst Fs
+	opts     *CopyOptions
+	jobs     chan copyJob
+	workers  sync.WaitGroup
+
+	mu      sync.Mutex // guards the fields below and calls to Progress
+	err     error
+	skipped bool
+	// dirs are the directories whose mode and times are set once all files
+	// are in place, as adding files changes their times.
+	dirs []copyJob
+
+	// copying holds the resolved paths of the source directories being
+	// copied when links are followed, so a loop is reported as soon as a
+	// link leads back to one of them.
+	copying map[string]bool
+}
+
+type copyJob struct {
+	src, dst string
+	info     os.FileInfo
+}
+
+// copyTree copies src to dst and reports whether anything was skipped for
+// existing already.
+func copyTree(srcFs Fs, src string, dstFs Fs, dst string, opts *CopyOptions, dirOnly bool) (bool, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	c := &copier{src: srcFs, dst: dstFs, opts: opts}
+	info, err := c.stat(src)
+	if err != nil {
+		return false, err
+	}
+	if dirOnly && !info.IsDir() {
+		return false, &os.PathError{Op: "copy", Path: src, Err: syscall.ENOTDIR}
+	}
+	if dir := filepath.Dir(dst); dir != dst {
+		if err := dstFs.MkdirAll(dir, 0777); err != nil {
+			return false, err
+		}
+	}
+
+	if opts.Parallelism > 1 {
+		c.jobs = make(chan copyJob)
+		for i := 0; i < opts.Parallelism; i++ {
+			c.workers.Add(1)
+			go func() {
+				defer c.workers.Done()
+				for job := range c.jobs {
+					c.fail(c.copyFile(job))
+				}
+			}()
+		}
+	}
+	c.fail(c.copy(copyJob{src: src, dst: dst, info: info}, ""))
+	if c.jobs != nil {
+		close(c.jobs)
+		c.workers.Wait()
+	}
+	if c.err != nil {
+		return c.skipped, c.err
+	}
+
+	// deepest first, so setting times doesn't change those of the parents
+	for i := len(c.dirs) - 1; i >= 0; i-- {
+		if err := c.setAttributes(c.dirs[i]); err != nil {
+			return c.skipped, err
+		}
+	}
+	return c.skipped, nil
+}
+
+// stat returns the info of the source path name, which is followed if it
+// is a symbolic link and links are to be followed.
+func (c *copier) stat(name string) (os.FileInfo, error) {
+	info, err := lstatIfPossible(c.src, name)
+	if err != nil || !c.opts.FollowSymlinks || info.Mode()&os.ModeSymlink == 0 {
+		return info, err
+	}
+	return c.src.Stat(name)
+}
+
+// realPath resolves the symbolic links in the source path name.
+func (c *copier) realPath(name string) (string, error) {
+	return evalSymlinks(name, true, func(p string) (string, bool, bool, error) {
+		fi, err := lstatIfPossible(c.src, p)
+		if os.IsNotExist(err) {
+			return "", false, false, nil
+		}
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return "", false, true, err
+		}
+		target, err := readlinkIfPossible(c.src, p)
+		return target, true, true, err
+	})
+}
+
+func (c *copier) fail(err error) {
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+}
+
+func (c *copier) failed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+func (c *copier) progress(name string, n int64) {
+	if c.opts.Progress == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts.Progress(name, n)
+}
+
+// copy copies job, which is rel below the source given to Copy.
+func (c *copier) copy(job copyJob, rel string) error {
+	if c.failed() {
+		return nil
+	}
+	if rel != "" && c.excluded(rel, job.info.IsDir()) {
+		return nil
+	}
+	switch mode := job.info.Mode(); {
+	case mode.IsDir():
+		return c.copyDir(job, rel)
+	case mode&os.ModeSymlink != 0:
+		return c.copySymlink(job)
+	case c.jobs != nil:
+		c.jobs <- job
+		return nil
+	default:
+		return c.copyFile(job)
+	}
+}
+
+// excluded reports whether the entry at rel is left out by the filters.
+func (c *copier) excluded(rel string, isDir bool) bool {
+	if matchAny(c.opts.Exclude, rel) {
+		return true
+	}
+	return !isDir && len(c.opts.Include) > 0 && !matchAny(c.opts.Include, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+		if !strings.ContainsRune(pattern, filepath.Separator) {
+			if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *copier) copyDir(job copyJob, rel string) error {
+	if c.opts.FollowSymlinks {
+		real, err := c.realPath(job.src)
+		if err != nil {
+			return err
+		}
+		if c.copying[real] {
+			return &os.PathError{Op: "copy", Path: job.src, Err: syscall.ELOOP}
+		}
+		if c.copying == nil {
+			c.copying = make(map[string]bool)
+		}
+		c.copying[real] = true
+		defer delete(c.copying, real)
+	}
+
+	existing, err := lstatIfPossible(c.dst, job.dst)
+	switch {
+	case err == nil && !existing.IsDir() && c.opts.Overwrite == OverwriteAlways:
+		if err := c.dst.RemoveAll(job.dst); err != nil {
+			return err
+		}
+		fallthrough
+	case os.IsNotExist(err):
+		// writable for now, to be able to add the entries
+		if err := c.dst.Mkdir(job.dst, job.info.Mode().Perm()|0700); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !existing.IsDir():
+		return &os.PathError{Op: "copy", Path: job.dst, Err: syscall.ENOTDIR}
+	}
+
+	f, err := c.src.Open(job.src)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		src := filepath.Join(job.src, name)
+		info, err := c.stat(src)
+		if err != nil {
+			return err
+		}
+		child := copyJob{src: src, dst: filepath.Join(job.dst, name), info: info}
+		if err := c.copy(child, filepath.Join(rel, name)); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.dirs = append(c.dirs, job)
+	c.mu.Unlock()
+	c.progress(job.src, 0)
+	return nil
+}
+
+// replace reports whether the entry at job.dst is to be replaced by job.src
+// according to the overwrite policy, and removes it if it isn't a regular
+// file, which would otherwise be written through.
+func (c *copier) replace(job copyJob) (bool, error) {
+	existing, err := lstatIfPossible(c.dst, job.dst)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	replace := true
+	switch c.opts.Overwrite {
+	case OverwriteNever:
+		replace = false
+	case OverwriteIfNewer:
+		replace = job.info.ModTime().After(existing.ModTime())
+	case OverwriteError:
+		return false, &os.PathError{Op: "copy", Path: job.dst, Err: ErrFileExists}
+	}
+	if !replace {
+		c.mu.Lock()
+		c.skipped = true
+		c.mu.Unlock()
+		return false, nil
+	}
+	if existing.IsDir() {
+		return false, &os.PathError{Op: "copy", Path: job.dst, Err: syscall.EISDIR}
+	}
+	if !existing.Mode().IsRegular() {
+		return true, c.dst.Remove(job.dst)
+	}
+	return true, nil
+}
+
+func (c *copier) copySymlink(job copyJob) error {
+	reader, ok := c.src.(LinkReader)
+	if !ok {
+		return &os.PathError{Op: "readlink", Path: job.src, Err: ErrNoReadlink}
+	}
+	linker, ok := c.dst.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: job.src, New: job.dst, Err: ErrNoSymlink}
+	}
+	target, err := reader.ReadlinkIfPossible(job.src)
+	if err != nil {
+		return err
+	}
+	if ok, err := c.replace(job); !ok || err != nil {
+		return err
+	}
+	if err := c.dst.Remove(job.dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := linker.SymlinkIfPossible(target, job.dst); err != nil {
+		return err
+	}
+	c.progress(job.src, 0)
+	return nil
+}
+
+func (c *copier) copyFile(job copyJob) error {
+	if ok, err := c.replace(job); !ok || err != nil {
+		return err
+	}
+	src, err := c.src.Open(job.src)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := c.dst.OpenFile(job.dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, job.info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(dst, src)
+	if err1 := dst.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.setAttributes(job); err != nil {
+		return err
+	}
+	c.progress(job.src, n)
+	return nil
+}
+
+// setAttributes gives job.dst the attributes of job.src which are to be
+// preserved.
+func (c *copier) setAttributes(job copyJob) error {
+	if c.opts.PreserveMode {
+		if err := c.dst.Chmod(job.dst, job.info.Mode()); err != nil {
+			return err
+		}
+	} else if job.info.IsDir() && job.info.Mode().Perm()&0700 != 0700 {
+		// undo the permissions added by copyDir
+		if err := c.dst.Chmod(job.dst, job.info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if c.opts.PreserveTimes {
+		mtime := job.info.ModTime()
+		if err := c.dst.Chtimes(job.dst, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	if c.opts.PreserveOwner {
+		if uid, gid, ok := fileOwner(job.info); ok {
+			if err := c.dst.Chown(job.dst, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fileOwner returns the uid and gid of the file described by info, if
+// known.
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	if fi, ok := info.(*mem.FileInfo); ok {
+		uid, gid = mem.GetOwner(fi.FileData)
+		return uid, gid, true
+	}
+	return sysOwner(info.Sys())
+}
@@ -17,21 +17,32 @@ var _ Lstater = (*CopyOnWriteFs)(nil)
 // includes also calls to e.g. Chtimes(), Chmod() and Chown()).
 //
 // Reading directories is currently only supported via Open(), not OpenFile().
+//
+// Without Whiteouts files present in the base layer can neither be removed
+// nor renamed. With Whiteouts the deletion of such files is recorded in the
+// layer, and renaming them copies them to the layer first.
 type CopyOnWriteFs struct {
-	base  Fs
-	layer Fs
+	base      Fs
+	layer     Fs
+	whiteouts Whiteouts
 }
 
 func NewCopyOnWriteFs(base Fs, layer Fs) Fs {
 	return &CopyOnWriteFs{base: base, layer: layer}
 }
 
+// NewCopyOnWriteFsWithWhiteouts returns a CopyOnWriteFs recording the
+// deletion of files of the base in the layer with w, e.g. PrefixWhiteouts.
+func NewCopyOnWriteFsWithWhiteouts(base Fs, layer Fs, w Whiteouts) Fs {
+	return &CopyOnWriteFs{base: base, layer: layer, whiteouts: w}
+}
+
 // Returns true if the file is not in the overlay
 func (u *CopyOnWriteFs) isBaseFile(name string) (bool, error) {
 	if _, err := u.layer.Stat(name); err == nil {
 		return false, nil
 	}
-	_, err := u.base.Stat(name)
+	_, err := u.baseStat(name)
 	if err != nil {
 		if oerr, ok := err.(*os.PathError); ok {
 			if oerr.Err == os.ErrNotExist || oerr.Err == syscall.ENOENT || oerr.Err == syscall.ENOTDIR {
@@ -46,10 +57,105 @@ func (u *CopyOnWriteFs) isBaseFile(name string) (bool, error) {
 }
 
 func (u *CopyOnWriteFs) copyToLayer(name string) error {
+	if isaDir, _ := u.isBaseDir(name); isaDir {
+		return copyDirsToLayer(u.base, u.layer, name)
+	}
 	return copyToLayer(u.base, u.layer, name)
 }
 
+// isHidden reports whether name of the base has been deleted from the union.
+func (u *CopyOnWriteFs) isHidden(name string) (bool, error) {
+	if u.whiteouts == nil {
+		return false, nil
+	}
+	return u.whiteouts.IsHidden(u.layer, name)
+}
+
+// baseStat stats name in the base, unless it has been deleted from the union.
+func (u *CopyOnWriteFs) baseStat(name string) (os.FileInfo, error) {
+	hidden, err := u.isHidden(name)
+	if err != nil {
+		return nil, err
+	}
+	if hidden {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return u.base.Stat(name)
+}
+
+// isBaseDir is like IsDir on the base, but treats deleted directories as not
+// existing.
+func (u *CopyOnWriteFs) isBaseDir(name string) (bool, error) {
+	fi, err := u.baseStat(name)
+	if err != nil {
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+// inBase reports whether name, not following a final symbolic link, exists
+// in the base and has not been deleted from the union.
+func (u *CopyOnWriteFs) inBase(name string) (bool, error) {
+	hidden, err := u.isHidden(name)
+	if err != nil || hidden {
+		return false, err
+	}
+	if lbase, ok := u.base.(Lstater); ok {
+		_, _, err = lbase.LstatIfPossible(name)
+	} else {
+		_, err = u.base.Stat(name)
+	}
+	if err != nil {
+		if u.isNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// merger returns the DirsMerger used for directories of the layer, which
+// leaves out whiteouts.
+func (u *CopyOnWriteFs) merger() DirsMerger {
+	if u.whiteouts == nil {
+		return nil
+	}
+	return func(lofi, bofi []os.FileInfo) ([]os.FileInfo, error) {
+		lofi, bofi = u.whiteouts.Filter(lofi, bofi)
+		return defaultUnionMergeDirsFn(lofi, bofi)
+	}
+}
+
+// realPath resolves the symbolic links in name as seen through the union, so
+// a link in either layer may point to a file living in the other one. The
+// last element of name is only followed if followLast is set.
+func (u *CopyOnWriteFs) realPath(name string, followLast bool) (string, error) {
+	_, ok1 := u.layer.(LinkReader)
+	_, ok2 := u.base.(LinkReader)
+	if !ok1 && !ok2 {
+		return name, nil
+	}
+	return evalSymlinks(name, followLast, func(p string) (string, bool, bool, error) {
+		fi, _, err := u.lstat(p)
+		if err != nil {
+			if u.isNotExist(err) {
+				return "", false, false, nil
+			}
+			return "", false, false, err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return "", false, true, nil
+		}
+		target, err := u.readlink(p)
+		return target, true, true, err
+	})
+}
+
 func (u *CopyOnWriteFs) Chtimes(name string, atime, mtime time.Time) error {
+	name, err := u.realPath(name, true)
+	if err != nil {
+		return err
+	}
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return err
@@ -63,6 +169,10 @@ func (u *CopyOnWriteFs) Chtimes(name string, atime, mtime time.Time) error {
 }
 
 func (u *CopyOnWriteFs) Chmod(name string, mode os.FileMode) error {
+	name, err := u.realPath(name, true)
+	if err != nil {
+		return err
+	}
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return err
@@ -76,6 +186,10 @@ func (u *CopyOnWriteFs) Chmod(name string, mode os.FileMode) error {
 }
 
 func (u *CopyOnWriteFs) Chown(name string, uid, gid int) error {
+	name, err := u.realPath(name, true)
+	if err != nil {
+		return err
+	}
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return err
@@ -89,11 +203,15 @@ func (u *CopyOnWriteFs) Chown(name string, uid, gid int) error {
 }
 
 func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
+	name, err := u.realPath(name, true)
+	if err != nil {
+		return nil, err
+	}
 	fi, err := u.layer.Stat(name)
 	if err != nil {
 		isNotExist := u.isNotExist(err)
 		if isNotExist {
-			return u.base.Stat(name)
+			return u.baseStat(name)
 		}
 		return nil, err
 	}
@@ -101,6 +219,14 @@ func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
 }
 
 func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	name, err := u.realPath(name, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return u.lstat(name)
+}
+
+func (u *CopyOnWriteFs) lstat(name string) (os.FileInfo, bool, error) {
 	llayer, ok1 := u.layer.(Lstater)
 	lbase, ok2 := u.base.(Lstater)
 
@@ -115,7 +241,11 @@ func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error)
 		}
 	}
 
-	if ok2 {
+	hidden, err := u.isHidden(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok2 && !hidden {
 		fi, b, err := lbase.LstatIfPossible(name)
 		if err == nil {
 			return fi, b, nil
@@ -125,25 +255,60 @@ func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error)
 		}
 	}
 
-	fi, err := u.Stat(name)
+	fi, err := u.layer.Stat(name)
+	if err != nil && u.isNotExist(err) {
+		fi, err = u.baseStat(name)
+	}
 
 	return fi, false, err
 }
 
 func (u *CopyOnWriteFs) SymlinkIfPossible(oldname, newname string) error {
-	if slayer, ok := u.layer.(Linker); ok {
-		return slayer.SymlinkIfPossible(oldname, newname)
+	slayer, ok := u.layer.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
 	}
 
-	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	newname, err := u.realPath(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if _, _, err := u.lstat(newname); err == nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
+	}
+	// the link may be created in a directory only present in the base
+	dir := filepath.Dir(newname)
+	if isaDir, _ := u.isBaseDir(dir); isaDir {
+		if err := copyDirsToLayer(u.base, u.layer, dir); err != nil {
+			return err
+		}
+	}
+	return slayer.SymlinkIfPossible(oldname, newname)
 }
 
 func (u *CopyOnWriteFs) ReadlinkIfPossible(name string) (string, error) {
+	name, err := u.realPath(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return u.readlink(name)
+}
+
+func (u *CopyOnWriteFs) readlink(name string) (string, error) {
 	if rlayer, ok := u.layer.(LinkReader); ok {
-		return rlayer.ReadlinkIfPossible(name)
+		target, err := rlayer.ReadlinkIfPossible(name)
+		if err == nil || !u.isNotExist(err) {
+			return target, err
+		}
 	}
 
 	if rbase, ok := u.base.(LinkReader); ok {
+		if hidden, err := u.isHidden(name); err != nil || hidden {
+			if err == nil {
+				err = os.ErrNotExist
+			}
+			return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+		}
 		return rbase.ReadlinkIfPossible(name)
 	}
 
@@ -160,8 +325,20 @@ func (u *CopyOnWriteFs) isNotExist(err error) bool {
 	return false
 }
 
-// Renaming files present only in the base layer is not permitted
+// Renaming files present only in the base layer is not permitted without
+// Whiteouts. With Whiteouts the file, or the whole directory, is copied to
+// the layer, renamed there and then hidden in the base.
 func (u *CopyOnWriteFs) Rename(oldname, newname string) error {
+	oldname, err := u.realPath(oldname, false)
+	if err != nil {
+		return err
+	}
+	if newname, err = u.realPath(newname, false); err != nil {
+		return err
+	}
+	if u.whiteouts != nil {
+		return u.renameWithWhiteouts(oldname, newname)
+	}
 	b, err := u.isBaseFile(oldname)
 	if err != nil {
 		return err
@@ -172,11 +349,154 @@ func (u *CopyOnWriteFs) Rename(oldname, newname string) error {
 	return u.layer.Rename(oldname, newname)
 }
 
-// Removing files present only in the base layer is not permitted. If
-// a file is present in the base layer and the overlay, only the overlay
-// will be removed.
+func (u *CopyOnWriteFs) renameWithWhiteouts(oldname, newname string) error {
+	if err := u.checkRenameTarget(oldname, newname); err != nil {
+		return err
+	}
+	inBase, err := u.inBase(oldname)
+	if err != nil {
+		return err
+	}
+	if inBase {
+		if err := u.copyUp(oldname); err != nil {
+			return err
+		}
+	}
+	newIsBaseDir, _ := u.isBaseDir(newname)
+	// the new name may be in a directory only present in the base
+	dir := filepath.Dir(newname)
+	if isaDir, _ := u.isBaseDir(dir); isaDir {
+		if err := copyDirsToLayer(u.base, u.layer, dir); err != nil {
+			return err
+		}
+	}
+	if err := u.layer.Rename(oldname, newname); err != nil {
+		return err
+	}
+	if newIsBaseDir {
+		if err := u.whiteouts.Opaque(u.layer, newname); err != nil {
+			return err
+		}
+	}
+	if inBase {
+		return u.whiteouts.Whiteout(u.layer, oldname)
+	}
+	return nil
+}
+
+// checkRenameTarget fails like os.Rename when newname exists in the union
+// and can't be replaced by oldname, before anything is changed in the layer.
+func (u *CopyOnWriteFs) checkRenameTarget(oldname, newname string) error {
+	if oldname == newname {
+		return nil
+	}
+	oldfi, _, err := u.lstat(oldname)
+	if err != nil {
+		return err
+	}
+	newfi, _, err := u.lstat(newname)
+	if err != nil {
+		if u.isNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	switch {
+	case oldfi.IsDir() && !newfi.IsDir():
+		return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTDIR}
+	case !oldfi.IsDir() && newfi.IsDir():
+		return &os.PathError{Op: "rename", Path: newname, Err: syscall.EISDIR}
+	case newfi.IsDir():
+		f, err := u.Open(newname)
+		if err != nil {
+			return err
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTEMPTY}
+		}
+	}
+	return nil
+}
+
+// copyUp copies name from the union to the layer, and for a directory
+// everything below it, leaving what is in the layer already untouched.
+func (u *CopyOnWriteFs) copyUp(name string) error {
+	fi, _, err := u.lstat(name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		if _, _, err := u.layerLstat(name); err == nil {
+			return nil
+		}
+		slayer, ok := u.layer.(Linker)
+		if !ok {
+			return &os.LinkError{Op: "symlink", Old: name, New: name, Err: ErrNoSymlink}
+		}
+		target, err := u.readlink(name)
+		if err != nil {
+			return err
+		}
+		if err := copyDirsToLayer(u.base, u.layer, filepath.Dir(name)); err != nil {
+			return err
+		}
+		return slayer.SymlinkIfPossible(target, name)
+	case fi.IsDir():
+		if err := copyDirsToLayer(u.base, u.layer, name); err != nil {
+			return err
+		}
+		f, err := u.Open(name)
+		if err != nil {
+			return err
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			if err := u.copyUp(filepath.Join(name, n)); err != nil {
+				return err
+			}
+		}
+		// the time of the directory changes while its content is copied
+		return u.layer.Chtimes(name, fi.ModTime(), fi.ModTime())
+	default:
+		b, err := u.isBaseFile(name)
+		if err != nil || !b {
+			return err
+		}
+		return u.copyToLayer(name)
+	}
+}
+
+func (u *CopyOnWriteFs) layerLstat(name string) (os.FileInfo, bool, error) {
+	if llayer, ok := u.layer.(Lstater); ok {
+		return llayer.LstatIfPossible(name)
+	}
+	fi, err := u.layer.Stat(name)
+	return fi, false, err
+}
+
+// Removing files present only in the base layer is not permitted without
+// Whiteouts. If a file is present in the base layer and the overlay, only
+// the overlay will be removed.
+// With Whiteouts the file is hidden in the base instead.
 func (u *CopyOnWriteFs) Remove(name string) error {
-	err := u.layer.Remove(name)
+	name, err := u.realPath(name, false)
+	if err != nil {
+		return err
+	}
+	if u.whiteouts != nil {
+		return u.removeWithWhiteouts(name)
+	}
+	err = u.layer.Remove(name)
 	switch err {
 	case syscall.ENOENT:
 		_, err = u.base.Stat(name)
@@ -189,8 +509,58 @@ func (u *CopyOnWriteFs) Remove(name string) error {
 	}
 }
 
+func (u *CopyOnWriteFs) removeWithWhiteouts(name string) error {
+	inBase, err := u.inBase(name)
+	if err != nil {
+		return err
+	}
+	if !inBase {
+		return u.layer.Remove(name)
+	}
+	fi, _, err := u.lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		f, err := u.Open(name)
+		if err != nil {
+			return err
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	// a directory may still hold whiteouts in the layer
+	if err := u.layer.RemoveAll(name); err != nil {
+		return err
+	}
+	return u.whiteouts.Whiteout(u.layer, name)
+}
+
 func (u *CopyOnWriteFs) RemoveAll(name string) error {
-	err := u.layer.RemoveAll(name)
+	name, err := u.realPath(name, false)
+	if err != nil {
+		return err
+	}
+	if u.whiteouts != nil {
+		inBase, err := u.inBase(name)
+		if err != nil {
+			return err
+		}
+		if err := u.layer.RemoveAll(name); err != nil {
+			return err
+		}
+		if inBase {
+			return u.whiteouts.Whiteout(u.layer, name)
+		}
+		return nil
+	}
+	err = u.layer.RemoveAll(name)
 	switch err {
 	case syscall.ENOENT:
 		_, err = u.base.Stat(name)
@@ -204,6 +574,10 @@ func (u *CopyOnWriteFs) RemoveAll(name string) error {
 }
 
 func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name, err := u.realPath(name, true)
+	if err != nil {
+		return nil, err
+	}
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return nil, err
@@ -218,12 +592,12 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 		}
 
 		dir := filepath.Dir(name)
-		isaDir, err := IsDir(u.base, dir)
+		isaDir, err := u.isBaseDir(dir)
 		if err != nil && !os.IsNotExist(err) {
 			return nil, err
 		}
 		if isaDir {
-			if err = u.layer.MkdirAll(dir, 0777); err != nil {
+			if err = copyDirsToLayer(u.base, u.layer, dir); err != nil {
 				return nil, err
 			}
 			return u.layer.OpenFile(name, flag, perm)
@@ -250,6 +624,11 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 //  layer: doesn't exist, exists as a file, and exists as a directory
 //  base:  doesn't exist, exists as a file, and exists as a directory
 func (u *CopyOnWriteFs) Open(name string) (File, error) {
+	name, err := u.realPath(name, true)
+	if err != nil {
+		return nil, err
+	}
+
 	// Since the overlay overrides the base we check that first
 	b, err := u.isBaseFile(name)
 	if err != nil {
@@ -270,6 +649,21 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 		return u.layer.Open(name)
 	}
 
+	// The layer directory may hold whiteouts, which must not be listed, and
+	// the base directory may have been deleted.
+	if u.whiteouts != nil {
+		if hidden, err := u.isHidden(name); err != nil || hidden {
+			if err != nil {
+				return nil, err
+			}
+			lfile, err := u.layer.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			return &UnionFile{Layer: lfile, Merger: u.merger()}, nil
+		}
+	}
+
 	// Overlay is a directory, base state now matters.
 	// Base state has 3 states to check but 2 outcomes:
 	// A. It's a file or non-readable in the base (return just the overlay)
@@ -278,6 +672,13 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 	// If base is file or nonreadable, return overlay
 	dir, err = IsDir(u.base, name)
 	if !dir || err != nil {
+		if u.whiteouts != nil {
+			lfile, err := u.layer.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			return &UnionFile{Layer: lfile, Merger: u.merger()}, nil
+		}
 		return u.layer.Open(name)
 	}
 
@@ -291,11 +692,18 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 		return nil, fmt.Errorf("BaseErr: %v\nOverlayErr: %v", bErr, lErr)
 	}
 
-	return &UnionFile{Base: bfile, Layer: lfile}, nil
+	return &UnionFile{Base: bfile, Layer: lfile, Merger: u.merger()}, nil
 }
 
 func (u *CopyOnWriteFs) Mkdir(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
+	name, err := u.realPath(name, false)
+	if err != nil {
+		return err
+	}
+	if _, err := u.layer.Stat(name); err == nil {
+		return ErrFileExists
+	}
+	dir, err := u.isBaseDir(name)
 	if err != nil {
 		return u.layer.MkdirAll(name, perm)
 	}
@@ -310,7 +718,11 @@ func (u *CopyOnWriteFs) Name() string {
 }
 
 func (u *CopyOnWriteFs) MkdirAll(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
+	name, err := u.realPath(name, true)
+	if err != nil {
+		return err
+	}
+	dir, err := u.isBaseDir(name)
 	if err != nil {
 		return u.layer.MkdirAll(name, perm)
 	}
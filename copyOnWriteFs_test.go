@@ -3,6 +3,9 @@ package afero
 import (
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -60,3 +63,196 @@ func TestCopyOnWriteFileInMemMapBase(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCopyOnWriteSymlinks(t *testing.T) {
+	base := NewMemMapFs()
+	if err := WriteFile(base, "/data/file.txt", []byte("base"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.(Linker).SymlinkIfPossible("file.txt", "/data/link.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.(Linker).SymlinkIfPossible("/data", "/datalink"); err != nil {
+		t.Fatal(err)
+	}
+
+	ufs := NewCopyOnWriteFs(base, NewMemMapFs())
+
+	if b, err := ReadFile(ufs, "/datalink/link.txt"); err != nil || string(b) != "base" {
+		t.Fatalf("read through base links: %q, %v", b, err)
+	}
+
+	// writing through a link copies up its target, not the link
+	if err := WriteFile(ufs, "/datalink/link.txt", []byte("layer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ReadFile(ufs, "/data/file.txt"); string(b) != "layer" {
+		t.Errorf("write through a link: got %q", b)
+	}
+	if b, _ := ReadFile(base, "/data/file.txt"); string(b) != "base" {
+		t.Errorf("base must not change, got %q", b)
+	}
+	fi, _, err := ufs.(Lstater).LstatIfPossible("/data/link.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("the link must still be a link, got mode %s", fi.Mode())
+	}
+
+	// a link created in the layer may point into the base
+	if err := WriteFile(base, "/other/b.txt", []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.(Linker).SymlinkIfPossible("../other/b.txt", "/data/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ReadFile(ufs, "/data/new.txt"); err != nil || string(b) != "other" {
+		t.Errorf("read through a layer link into the base: %q, %v", b, err)
+	}
+	if target, err := ufs.(LinkReader).ReadlinkIfPossible("/datalink/link.txt"); err != nil || target != "file.txt" {
+		t.Errorf("readlink of a base link: %q, %v", target, err)
+	}
+}
+
+func TestCopyOnWriteWhiteouts(t *testing.T) {
+	base := NewMemMapFs()
+	for _, name := range []string{"/dir/a.txt", "/dir/sub/b.txt", "/file.txt", "/keep/c.txt"} {
+		if err := WriteFile(base, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	layer := NewMemMapFs()
+	ufs := NewCopyOnWriteFsWithWhiteouts(NewReadOnlyFs(base), layer, PrefixWhiteouts{})
+
+	readdirnames := func(name string) []string {
+		t.Helper()
+		f, err := ufs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if err := ufs.Remove("/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/file.txt"); !os.IsNotExist(err) {
+		t.Errorf("removed base file still visible: %v", err)
+	}
+	if _, err := ufs.Open("/file.txt"); !os.IsNotExist(err) {
+		t.Errorf("removed base file can still be opened: %v", err)
+	}
+	if _, err := base.Stat("/file.txt"); err != nil {
+		t.Errorf("the base must not change: %v", err)
+	}
+
+	if err := ufs.Remove("/dir"); err == nil || err.(*os.PathError).Err != syscall.ENOTEMPTY {
+		t.Errorf("removing a non-empty base dir: expected ENOTEMPTY, got %v", err)
+	}
+	if err := ufs.Remove("/dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if names := readdirnames("/dir"); strings.Join(names, ",") != "sub" {
+		t.Errorf("/dir: got %v", names)
+	}
+
+	if err := ufs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/dir/sub/b.txt"); !os.IsNotExist(err) {
+		t.Errorf("file below a removed dir still visible: %v", err)
+	}
+	if names := readdirnames("/"); strings.Join(names, ",") != "keep" {
+		t.Errorf("/: got %v", names)
+	}
+
+	// a directory created again starts empty
+	if err := ufs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if names := readdirnames("/dir"); len(names) != 0 {
+		t.Errorf("recreated /dir shows base content: %v", names)
+	}
+
+	if err := ufs.Rename("/keep", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/keep"); !os.IsNotExist(err) {
+		t.Errorf("renamed base dir still visible: %v", err)
+	}
+	if b, err := ReadFile(ufs, "/moved/c.txt"); err != nil || string(b) != "/keep/c.txt" {
+		t.Errorf("/moved/c.txt: %q, %v", b, err)
+	}
+	if err := ufs.Rename("/moved/c.txt", "/dir/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if names := readdirnames("/moved"); len(names) != 0 {
+		t.Errorf("/moved: got %v", names)
+	}
+
+	if err := WriteFile(ufs, "/file.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ReadFile(ufs, "/file.txt"); err != nil || string(b) != "new" {
+		t.Errorf("recreated /file.txt: %q, %v", b, err)
+	}
+	if names := readdirnames("/"); strings.Join(names, ",") != "dir,file.txt,moved" {
+		t.Errorf("/: got %v", names)
+	}
+
+	for _, name := range []string{"/dir/a.txt", "/dir/sub/b.txt", "/file.txt", "/keep/c.txt"} {
+		if b, err := ReadFile(base, name); err != nil || string(b) != name {
+			t.Errorf("base %s changed: %q, %v", name, b, err)
+		}
+	}
+}
+
+func TestCopyOnWriteRenameOntoBaseDir(t *testing.T) {
+	base := NewMemMapFs()
+	for _, name := range []string{"/d/keep", "/e/x", "/f"} {
+		if err := WriteFile(base, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := base.Mkdir("/empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	ufs := NewCopyOnWriteFsWithWhiteouts(NewReadOnlyFs(base), NewMemMapFs(), PrefixWhiteouts{})
+
+	for _, tc := range []struct {
+		oldname, newname string
+		err              error
+	}{
+		{"/f", "/d", syscall.EISDIR},
+		{"/e", "/d", syscall.ENOTEMPTY},
+		{"/e", "/f", syscall.ENOTDIR},
+	} {
+		err := ufs.Rename(tc.oldname, tc.newname)
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != tc.err {
+			t.Errorf("Rename(%s, %s): expected %v, got %v", tc.oldname, tc.newname, tc.err, err)
+		}
+	}
+	for _, name := range []string{"/d/keep", "/e/x", "/f"} {
+		if b, err := ReadFile(ufs, name); err != nil || string(b) != name {
+			t.Errorf("%s after a failed rename: %q, %v", name, b, err)
+		}
+	}
+	if fi, err := ufs.Stat("/d"); err != nil || !fi.IsDir() {
+		t.Errorf("/d must still be a directory: %v, %v", fi, err)
+	}
+
+	// an empty directory may be replaced
+	if err := ufs.Rename("/e", "/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ReadFile(ufs, "/empty/x"); err != nil || string(b) != "/e/x" {
+		t.Errorf("/empty/x: %q, %v", b, err)
+	}
+}
@@ -0,0 +1,9 @@
+// +build windows plan9 wasip1
+
+package afero
+
+// sysOwner returns the owner recorded in the Sys of an os.FileInfo, which
+// holds none on this platform.
+func sysOwner(sys interface{}) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
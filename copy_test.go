@@ -0,0 +1,223 @@
+package afero
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func copyFixture(t *testing.T) *MemMapFs {
+	t.Helper()
+	fs := &MemMapFs{}
+	for name, content := range map[string]string{
+		"/src/a.txt":       "a",
+		"/src/b.log":       "b",
+		"/src/sub/c.txt":   "c",
+		"/src/skip/d.txt":  "d",
+		"/src/sub/e/f.txt": "f",
+	} {
+		if err := WriteFile(fs, name, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.SymlinkIfPossible("a.txt", "/src/link"); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func checkFile(t *testing.T, fs Fs, name, content string) {
+	t.Helper()
+	b, err := ReadFile(fs, name)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	if string(b) != content {
+		t.Errorf("%s: got %q, expected %q", name, b, content)
+	}
+}
+
+func checkMissing(t *testing.T, fs Fs, name string) {
+	t.Helper()
+	if _, err := fs.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("%s: expected not to exist, got %v", name, err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := copyFixture(t)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	src.Chtimes("/src/sub/c.txt", mtime, mtime)
+	src.Chmod("/src/sub", 0750)
+	src.Chown("/src/a.txt", 1000, 1001)
+
+	dst := &MemMapFs{}
+	opts := &CopyOptions{PreserveMode: true, PreserveTimes: true, PreserveOwner: true}
+	if err := Copy(src, "/src", dst, "/dst/copy", opts); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, dst, "/dst/copy/a.txt", "a")
+	checkFile(t, dst, "/dst/copy/sub/e/f.txt", "f")
+	if target, err := dst.ReadlinkIfPossible("/dst/copy/link"); err != nil || target != "a.txt" {
+		t.Errorf("link: got %q, %v", target, err)
+	}
+	if fi, err := dst.Stat("/dst/copy/sub"); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("sub: got %v, %v", fi.Mode(), err)
+	}
+	if fi, err := dst.Stat("/dst/copy/sub/c.txt"); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("c.txt: got %v, %v", fi.ModTime(), err)
+	}
+	fi, _ := dst.Stat("/dst/copy/a.txt")
+	if uid, gid, _ := fileOwner(fi); uid != 1000 || gid != 1001 {
+		t.Errorf("a.txt: got owner %d:%d", uid, gid)
+	}
+
+	// a single file
+	if err := Copy(src, "/src/b.log", dst, "/single/b.log", nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, dst, "/single/b.log", "b")
+	if err := CopyDir(src, "/src/b.log", dst, "/single/dir", nil); err == nil {
+		t.Error("expected CopyDir to fail for a file")
+	}
+
+	// following links
+	if err := Copy(src, "/src/link", dst, "/followed", &CopyOptions{FollowSymlinks: true}); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _, err := dst.LstatIfPossible("/followed"); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("followed link: got %v, %v", fi, err)
+	}
+}
+
+func TestCopySymlinkLoop(t *testing.T) {
+	src := copyFixture(t)
+	if err := src.SymlinkIfPossible("..", "/src/sub/up"); err != nil {
+		t.Fatal(err)
+	}
+	dst := &MemMapFs{}
+	opts := &CopyOptions{FollowSymlinks: true}
+	if err := Copy(src, "/src", dst, "/loop", opts); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("got %v, expected ELOOP", err)
+	}
+	// the loop is reported the first time it comes back to a directory
+	checkMissing(t, dst, "/loop/sub/up")
+
+	// the same directory reached twice without a loop is copied twice
+	src = copyFixture(t)
+	src.SymlinkIfPossible("/src/sub/e", "/src/e1")
+	src.SymlinkIfPossible("/src/sub/e", "/src/e2")
+	if err := Copy(src, "/src", dst, "/twice", opts); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, dst, "/twice/e1/f.txt", "f")
+	checkFile(t, dst, "/twice/e2/f.txt", "f")
+}
+
+func TestCopyOverwrite(t *testing.T) {
+	src := copyFixture(t)
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	src.Chtimes("/src/a.txt", old, old)
+	middle := old.AddDate(10, 0, 0)
+
+	for _, test := range []struct {
+		policy  OverwritePolicy
+		a, b    string
+		wantErr bool
+	}{
+		{OverwriteAlways, "a", "b", false},
+		{OverwriteNever, "old a", "old b", false},
+		{OverwriteIfNewer, "old a", "b", false},
+		{OverwriteError, "old a", "old b", true},
+	} {
+		dst := &MemMapFs{}
+		WriteFile(dst, "/a.txt", []byte("old a"), 0644)
+		WriteFile(dst, "/b.log", []byte("old b"), 0644)
+		dst.Chtimes("/a.txt", middle, middle)
+		dst.Chtimes("/b.log", middle, middle)
+		opts := &CopyOptions{Overwrite: test.policy, Include: []string{"*.txt", "*.log"}, Exclude: []string{"sub", "skip"}}
+		err := Copy(src, "/src", dst, "/", opts)
+		if (err != nil) != test.wantErr {
+			t.Errorf("policy %d: got error %v", test.policy, err)
+		}
+		checkFile(t, dst, "/a.txt", test.a)
+		checkFile(t, dst, "/b.log", test.b)
+	}
+}
+
+func TestCopyFilters(t *testing.T) {
+	src := copyFixture(t)
+	dst := &MemMapFs{}
+	opts := &CopyOptions{Include: []string{"*.txt"}, Exclude: []string{"skip", filepath.FromSlash("sub/e")}}
+	if err := Copy(src, "/src", dst, "/dst", opts); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, dst, "/dst/a.txt", "a")
+	checkFile(t, dst, "/dst/sub/c.txt", "c")
+	checkMissing(t, dst, "/dst/b.log")
+	checkMissing(t, dst, "/dst/link")
+	checkMissing(t, dst, "/dst/skip")
+	checkMissing(t, dst, "/dst/sub/e")
+}
+
+func TestCopyParallel(t *testing.T) {
+	src := &MemMapFs{}
+	var want []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("/src/dir%d/file%d", i%5, i)
+		WriteFile(src, name, []byte(name), 0644)
+		want = append(want, name)
+	}
+	var got []string
+	opts := &CopyOptions{
+		Parallelism: 8,
+		Progress: func(path string, n int64) {
+			if n > 0 {
+				got = append(got, path)
+			}
+		},
+	}
+	dst := &MemMapFs{}
+	if err := Copy(src, "/src", dst, "/dst", opts); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("progress: got %v, expected %v", got, want)
+	}
+	for _, name := range want {
+		checkFile(t, dst, "/dst"+name[len("/src"):], name)
+	}
+}
+
+func TestMove(t *testing.T) {
+	fs := copyFixture(t)
+	if err := Move(fs, "/src/sub", fs, "/moved", nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, fs, "/moved/c.txt", "c")
+	checkMissing(t, fs, "/src/sub")
+
+	other := &MemMapFs{}
+	if err := Move(fs, "/src", other, "/other", nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, other, "/other/a.txt", "a")
+	checkMissing(t, fs, "/src")
+
+	// the source stays if anything is left out
+	WriteFile(fs, "/x/keep", []byte("new"), 0644)
+	WriteFile(other, "/y/keep", []byte("old"), 0644)
+	if err := Move(fs, "/x", other, "/y", &CopyOptions{Overwrite: OverwriteNever}); !os.IsExist(err) {
+		t.Errorf("got %v, expected an error for an existing file", err)
+	}
+	checkFile(t, fs, "/x/keep", "new")
+	checkFile(t, other, "/y/keep", "old")
+}
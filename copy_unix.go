@@ -0,0 +1,13 @@
+// +build !windows,!plan9,!wasip1
+
+package afero
+
+import "syscall"
+
+// sysOwner returns the owner recorded in the Sys of an os.FileInfo.
+func sysOwner(sys interface{}) (uid, gid int, ok bool) {
+	if st, ok := sys.(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid), true
+	}
+	return 0, 0, false
+}
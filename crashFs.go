@@ -0,0 +1,329 @@
+package afero
+
+import (
+	"io/ioutil"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero/mem"
+)
+
+var (
+	_ Lstater   = (*CrashFs)(nil)
+	_ Symlinker = (*CrashFs)(nil)
+)
+
+// The CrashFs is a MemMapFs simulating power loss, to test that programs
+// make their changes durable. Like on most POSIX filesystems, the content
+// and metadata of a file are durable once it is synced, and the entries of
+// a directory, created, removed or renamed, once the directory itself is
+// synced, by opening it and calling Sync on it.
+//
+// Crash rolls the filesystem back to its durable state, where files whose
+// entries are durable but which were never synced are empty.
+type CrashFs struct {
+	live *MemMapFs
+
+	mu      sync.Mutex
+	durable map[*mem.FileData]*crashNode
+	rand    *mathrand.Rand
+}
+
+// crashNode is the durable state of a file or directory: a copy of a file
+// or symbolic link as last synced, or the entries of a directory.
+type crashNode struct {
+	file    *mem.FileData
+	mode    os.FileMode
+	modTime time.Time
+	entries map[string]*mem.FileData
+}
+
+// NewCrashFs returns an empty CrashFs, whose random choices come from seed.
+func NewCrashFs(seed int64) *CrashFs {
+	c := &CrashFs{live: &MemMapFs{}, rand: mathrand.New(mathrand.NewSource(seed))}
+	c.SyncAll()
+	return c
+}
+
+// SyncAll makes the whole filesystem durable, as after setting up a test.
+func (c *CrashFs) SyncAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncAll()
+}
+
+// syncAll is SyncAll.
+// The caller must hold c.mu.
+func (c *CrashFs) syncAll() {
+	c.durable = make(map[*mem.FileData]*crashNode)
+	var syncTree func(f *mem.FileData)
+	syncTree = func(f *mem.FileData) {
+		c.sync(f)
+		for _, child := range c.durable[f].entries {
+			syncTree(child)
+		}
+	}
+	syncTree(c.live.getRoot())
+}
+
+// sync makes the current state of f durable.
+// The caller must hold c.mu.
+func (c *CrashFs) sync(f *mem.FileData) {
+	info := mem.GetFileInfo(f)
+	if !info.IsDir() {
+		c.durable[f] = &crashNode{file: mem.Clone(f)}
+		return
+	}
+	node := &crashNode{mode: info.Mode(), modTime: info.ModTime(), entries: make(map[string]*mem.FileData)}
+	for name, child := range crashEntries(f) {
+		node.entries[name] = child
+	}
+	c.durable[f] = node
+}
+
+// crashEntries returns the entries of the directory f by name.
+func crashEntries(f *mem.FileData) map[string]*mem.FileData {
+	f.Lock()
+	children := mem.MemDirFiles(f)
+	f.Unlock()
+	entries := make(map[string]*mem.FileData, len(children))
+	for _, child := range children {
+		entries[filepath.Base(child.Name())] = child
+	}
+	return entries
+}
+
+// Crash rolls the filesystem back to its durable state, which everything
+// then is. Files opened before keep referring to their previous content.
+func (c *CrashFs) Crash() {
+	c.CrashPartially(0)
+}
+
+// CrashPartially is Crash, but keeps every change which wasn't made
+// durable with the probability p: each creation, removal or rename in a
+// directory independently, and the content of every file, possibly cut
+// short if it was appended to.
+func (c *CrashFs) CrashPartially(p float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	live := make(map[*mem.FileData]bool)
+	var walkLive func(f *mem.FileData)
+	walkLive = func(f *mem.FileData) {
+		live[f] = true
+		for _, child := range crashEntries(f) {
+			walkLive(child)
+		}
+	}
+	root := c.live.getRoot()
+	walkLive(root)
+
+	r := &crashRestorer{c: c, p: p, live: live, dst: &MemMapFs{}, visited: make(map[*mem.FileData]bool)}
+	r.dir(FilePathSeparator, root)
+	c.live.Restore(r.dst)
+	c.syncAll()
+}
+
+// crashRestorer rebuilds the durable state of a CrashFs in dst, keeping the
+// changes of live files and directories with the probability p.
+type crashRestorer struct {
+	c       *CrashFs
+	p       float64
+	live    map[*mem.FileData]bool
+	dst     *MemMapFs
+	visited map[*mem.FileData]bool
+}
+
+// keep reports whether a change which isn't durable is kept.
+func (r *crashRestorer) keep() bool {
+	return r.p > 0 && r.c.rand.Float64() < r.p
+}
+
+// dir restores the directory f at path, and what is below it.
+func (r *crashRestorer) dir(path string, f *mem.FileData) {
+	r.visited[f] = true
+	node := r.c.durable[f]
+	entries := make(map[string]*mem.FileData)
+	if node != nil {
+		for name, child := range node.entries {
+			entries[name] = child
+		}
+	}
+	if r.p > 0 && r.live[f] {
+		current := crashEntries(f)
+		for _, name := range crashNames(entries, current) {
+			if current[name] != entries[name] && r.keep() {
+				if current[name] == nil {
+					delete(entries, name)
+				} else {
+					entries[name] = current[name]
+				}
+			}
+		}
+	}
+
+	for _, name := range crashNames(entries) {
+		child := entries[name]
+		childPath := filepath.Join(path, name)
+		info := mem.GetFileInfo(child)
+		switch {
+		case info.IsDir():
+			if r.visited[child] {
+				continue
+			}
+			r.dst.Mkdir(childPath, info.Mode().Perm())
+			r.dir(childPath, child)
+		case info.Mode()&os.ModeSymlink != 0:
+			r.dst.SymlinkIfPossible(mem.ReadSymlink(child), childPath)
+		default:
+			r.file(childPath, child)
+		}
+	}
+
+	mode, modTime := mem.GetFileInfo(f).Mode(), mem.GetFileInfo(f).ModTime()
+	if node != nil {
+		mode, modTime = node.mode, node.modTime
+	}
+	r.dst.Chmod(path, mode)
+	r.dst.Chtimes(path, modTime, modTime)
+}
+
+// file restores the file f at path.
+func (r *crashRestorer) file(path string, f *mem.FileData) {
+	source := f
+	var data []byte
+	if node := r.c.durable[f]; node != nil {
+		source = node.file
+		data = crashContent(node.file)
+	}
+	if r.p > 0 && r.live[f] && r.keep() {
+		source = f
+		liveData := crashContent(f)
+		if len(liveData) > len(data) && string(liveData[:len(data)]) == string(data) {
+			liveData = liveData[:len(data)+r.c.rand.Intn(len(liveData)-len(data)+1)]
+		}
+		data = liveData
+	}
+	info := mem.GetFileInfo(source)
+	WriteFile(r.dst, path, data, info.Mode().Perm())
+	uid, gid := mem.GetOwner(source)
+	r.dst.Chown(path, uid, gid)
+	r.dst.Chmod(path, info.Mode())
+	r.dst.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// crashContent returns the content of the file f.
+func crashContent(f *mem.FileData) []byte {
+	data, _ := ioutil.ReadAll(mem.NewReadOnlyFileHandle(f))
+	return data
+}
+
+// crashNames returns the names of every entry of dirs, sorted.
+func crashNames(dirs ...map[string]*mem.FileData) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, entries := range dirs {
+		for name := range entries {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *CrashFs) Name() string {
+	return "CrashFs"
+}
+
+// wrap returns file as a file whose Sync makes it durable.
+func (c *CrashFs) wrap(file File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &crashFile{File: file, fs: c}, nil
+}
+
+func (c *CrashFs) Create(name string) (File, error) {
+	return c.wrap(c.live.Create(name))
+}
+
+func (c *CrashFs) Mkdir(name string, perm os.FileMode) error {
+	return c.live.Mkdir(name, perm)
+}
+
+func (c *CrashFs) MkdirAll(path string, perm os.FileMode) error {
+	return c.live.MkdirAll(path, perm)
+}
+
+func (c *CrashFs) Open(name string) (File, error) {
+	return c.wrap(c.live.Open(name))
+}
+
+func (c *CrashFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return c.wrap(c.live.OpenFile(name, flag, perm))
+}
+
+func (c *CrashFs) Remove(name string) error {
+	return c.live.Remove(name)
+}
+
+func (c *CrashFs) RemoveAll(path string) error {
+	return c.live.RemoveAll(path)
+}
+
+func (c *CrashFs) Rename(oldname, newname string) error {
+	return c.live.Rename(oldname, newname)
+}
+
+func (c *CrashFs) Stat(name string) (os.FileInfo, error) {
+	return c.live.Stat(name)
+}
+
+func (c *CrashFs) Chmod(name string, mode os.FileMode) error {
+	return c.live.Chmod(name, mode)
+}
+
+func (c *CrashFs) Chown(name string, uid, gid int) error {
+	return c.live.Chown(name, uid, gid)
+}
+
+func (c *CrashFs) Chtimes(name string, atime, mtime time.Time) error {
+	return c.live.Chtimes(name, atime, mtime)
+}
+
+func (c *CrashFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return c.live.LstatIfPossible(name)
+}
+
+func (c *CrashFs) SymlinkIfPossible(oldname, newname string) error {
+	return c.live.SymlinkIfPossible(oldname, newname)
+}
+
+func (c *CrashFs) ReadlinkIfPossible(name string) (string, error) {
+	return c.live.ReadlinkIfPossible(name)
+}
+
+// crashFile is a file of a CrashFs, made durable by Sync.
+type crashFile struct {
+	File
+	fs *CrashFs
+}
+
+func (f *crashFile) Sync() error {
+	if err := f.File.Sync(); err != nil {
+		return err
+	}
+	if file, ok := f.File.(*mem.File); ok {
+		f.fs.mu.Lock()
+		f.fs.sync(file.Data())
+		f.fs.mu.Unlock()
+	}
+	return nil
+}
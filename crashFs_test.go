@@ -0,0 +1,175 @@
+package afero
+
+import (
+	"os"
+	"testing"
+)
+
+// syncDir syncs the directory name of fs.
+func syncDir(t *testing.T, fs Fs, name string) {
+	t.Helper()
+	dir, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeSynced writes content to name in fs and syncs it, without syncing
+// its directory.
+func writeSynced(t *testing.T, fs Fs, name, content string) {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrashFsUnsynced(t *testing.T) {
+	fs := NewCrashFs(1)
+	if err := WriteFile(fs, "/kept", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs.SyncAll()
+
+	if err := WriteFile(fs, "/kept", []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/lost", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs.Crash()
+
+	checkFile(t, fs, "/kept", "old")
+	checkMissing(t, fs, "/lost")
+	checkMissing(t, fs, "/dir")
+}
+
+func TestCrashFsFileSyncWithoutDirSync(t *testing.T) {
+	fs := NewCrashFs(1)
+	writeSynced(t, fs, "/a", "synced")
+	fs.Crash()
+	checkMissing(t, fs, "/a")
+
+	if err := WriteFile(fs, "/b", []byte("not synced"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncDir(t, fs, "/")
+	fs.Crash()
+	checkFile(t, fs, "/b", "")
+}
+
+func TestCrashFsAtomicReplace(t *testing.T) {
+	fs := NewCrashFs(1)
+	writeSynced(t, fs, "/state", "v1")
+	syncDir(t, fs, "/")
+
+	// Renaming without syncing the directory may lose the rename, but
+	// never the content of either version.
+	writeSynced(t, fs, "/state.tmp", "v2")
+	if err := fs.Rename("/state.tmp", "/state"); err != nil {
+		t.Fatal(err)
+	}
+	fs.Crash()
+	checkFile(t, fs, "/state", "v1")
+
+	writeSynced(t, fs, "/state.tmp", "v2")
+	if err := fs.Rename("/state.tmp", "/state"); err != nil {
+		t.Fatal(err)
+	}
+	syncDir(t, fs, "/")
+	fs.Crash()
+	checkFile(t, fs, "/state", "v2")
+	checkMissing(t, fs, "/state.tmp")
+}
+
+func TestCrashFsRemove(t *testing.T) {
+	fs := NewCrashFs(1)
+	if err := fs.MkdirAll("/dir/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/dir/sub/f", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs.SyncAll()
+
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	fs.Crash()
+	checkFile(t, fs, "/dir/sub/f", "data")
+	fi, err := fs.Stat("/dir/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != os.ModeDir|0700 {
+		t.Errorf("mode = %v, want %v", fi.Mode(), os.ModeDir|0700)
+	}
+
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	syncDir(t, fs, "/")
+	fs.Crash()
+	checkMissing(t, fs, "/dir")
+}
+
+func TestCrashFsPartially(t *testing.T) {
+	crash := func(seed int64) []string {
+		fs := NewCrashFs(seed)
+		writeSynced(t, fs, "/log", "synced ")
+		syncDir(t, fs, "/")
+		f, err := fs.OpenFile("/log", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString("appended"); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		for _, name := range []string{"/a", "/b", "/c", "/d"} {
+			if err := WriteFile(fs, name, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fs.CrashPartially(0.5)
+
+		data, err := ReadFile(fs, "/log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) < len("synced ") || string(data) != "synced appended"[:len(data)] {
+			t.Fatalf("log = %q, want a prefix of the appended content", data)
+		}
+		names, err := readDirNames(fs, "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(names, string(data))
+	}
+
+	first := crash(7)
+	if again := crash(7); !equalNames(first, again) {
+		t.Errorf("the same seed crashed to %q, then %q", first, again)
+	}
+	var kept int
+	for seed := int64(0); seed < 20; seed++ {
+		kept += len(crash(seed)) - 2
+	}
+	if kept == 0 || kept == 20*4 {
+		t.Errorf("%d files kept out of %d", kept, 20*4)
+	}
+}
@@ -0,0 +1,340 @@
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// DiffOptions tune Diff. The zero value compares files by size and
+// modification time.
+type DiffOptions struct {
+	// Contents compares the contents of files of the same size, byte for
+	// byte, instead of their modification times.
+	Contents bool
+	// IgnoreTimes compares files by size alone.
+	IgnoreTimes bool
+	// IgnoreModes leaves out the paths whose permissions alone differ.
+	IgnoreModes bool
+	// Unified sets the Diff of the modified text files to a unified diff
+	// with Context lines of context, 3 if not set.
+	Unified bool
+	Context int
+}
+
+// Diff compares the trees below root in a and b, and returns the paths
+// which differ, sorted. Paths only in b are added, those only in a
+// deleted. A path whose contents and permissions both differ is modified.
+// A nil opts stands for the zero DiffOptions.
+func Diff(a, b Fs, root string, opts *DiffOptions) ([]Change, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	var changes []Change
+	err := Walk(a, root, func(path string, ainfo os.FileInfo, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		binfo, err := lstatIfPossible(b, path)
+		if missing(err) {
+			changes = append(changes, Change{Path: path, Kind: ChangeDeleted})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		change, err := diffEntry(a, b, path, ainfo, binfo, opts)
+		if change != nil {
+			changes = append(changes, *change)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = Walk(b, root, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if _, err := lstatIfPossible(a, path); missing(err) {
+			changes = append(changes, Change{Path: path, Kind: ChangeAdded})
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// missing reports whether err tells that a path doesn't exist, which
+// includes paths below something which isn't a directory.
+func missing(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// diffEntry compares path, which is described by ainfo in a and by binfo in
+// b. It returns nil if they don't differ.
+func diffEntry(a, b Fs, path string, ainfo, binfo os.FileInfo, opts *DiffOptions) (*Change, error) {
+	amode, bmode := ainfo.Mode(), binfo.Mode()
+	if amode&os.ModeType != bmode&os.ModeType {
+		return &Change{Path: path, Kind: ChangeTypeChanged}, nil
+	}
+
+	modified := false
+	switch {
+	case amode.IsDir():
+	case amode&os.ModeSymlink != 0:
+		atarget, err := readlinkIfPossible(a, path)
+		if err != nil {
+			return nil, err
+		}
+		btarget, err := readlinkIfPossible(b, path)
+		if err != nil {
+			return nil, err
+		}
+		modified = atarget != btarget
+	case ainfo.Size() != binfo.Size():
+		modified = true
+	case opts.Contents:
+		same, err := sameContents(a, b, path)
+		if err != nil {
+			return nil, err
+		}
+		modified = !same
+	default:
+		modified = !opts.IgnoreTimes && !ainfo.ModTime().Equal(binfo.ModTime())
+	}
+
+	switch {
+	case modified:
+		change := &Change{Path: path, Kind: ChangeModified}
+		if opts.Unified && amode.IsRegular() {
+			diff, err := unifiedFileDiff(a, b, path, opts.Context)
+			if err != nil {
+				return nil, err
+			}
+			change.Diff = diff
+		}
+		return change, nil
+	case !opts.IgnoreModes && amode.Perm() != bmode.Perm():
+		return &Change{Path: path, Kind: ChangeModeChanged}, nil
+	}
+	return nil, nil
+}
+
+// sameContents reports whether the file path has the same contents in a and
+// b. They are read side by side, up to the first difference.
+func sameContents(a, b Fs, path string) (bool, error) {
+	af, err := a.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer af.Close()
+	bf, err := b.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer bf.Close()
+
+	abuf, bbuf := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		an, aerr := io.ReadFull(af, abuf)
+		bn, berr := io.ReadFull(bf, bbuf)
+		aend := aerr == io.EOF || aerr == io.ErrUnexpectedEOF
+		bend := berr == io.EOF || berr == io.ErrUnexpectedEOF
+		if aerr != nil && !aend {
+			return false, aerr
+		}
+		if berr != nil && !bend {
+			return false, berr
+		}
+		if !bytes.Equal(abuf[:an], bbuf[:bn]) {
+			return false, nil
+		}
+		if aend || bend {
+			return aend == bend, nil
+		}
+	}
+}
+
+// unifiedFileDiff returns a unified diff of the file path from a to b, or
+// nothing if either isn't text.
+func unifiedFileDiff(a, b Fs, path string, context int) (string, error) {
+	adata, err := ReadFile(a, path)
+	if err != nil {
+		return "", err
+	}
+	bdata, err := ReadFile(b, path)
+	if err != nil {
+		return "", err
+	}
+	if !isText(adata) || !isText(bdata) {
+		return "", nil
+	}
+	if context <= 0 {
+		context = 3
+	}
+	name := filepath.ToSlash(filepath.Join(FilePathSeparator, path))
+	return unifiedDiff("a"+name, "b"+name, string(adata), string(bdata), context), nil
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// diffLine is a line of an edit script: kept if op is ' ', deleted if '-'
+// and inserted if '+'.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// maxDiffCells bounds the table diffLines builds. Beyond it, the lines
+// between the common start and end are all replaced.
+const maxDiffCells = 1 << 22
+
+// diffLines returns a shortest edit script turning x into y.
+func diffLines(x, y []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		prefix = append(prefix, diffLine{' ', x[0]})
+		x, y = x[1:], y[1:]
+	}
+	for len(x) > 0 && len(y) > 0 && x[len(x)-1] == y[len(y)-1] {
+		suffix = append([]diffLine{{' ', x[len(x)-1]}}, suffix...)
+		x, y = x[:len(x)-1], y[:len(y)-1]
+	}
+
+	script := prefix
+	n, m := len(x), len(y)
+	if n*m > maxDiffCells {
+		for _, line := range x {
+			script = append(script, diffLine{'-', line})
+		}
+		for _, line := range y {
+			script = append(script, diffLine{'+', line})
+		}
+		return append(script, suffix...)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && x[i] == y[j]:
+			script = append(script, diffLine{' ', x[i]})
+			i++
+			j++
+		case j == m || i < n && lcs[i+1][j] >= lcs[i][j+1]:
+			script = append(script, diffLine{'-', x[i]})
+			i++
+		default:
+			script = append(script, diffLine{'+', y[j]})
+			j++
+		}
+	}
+	return append(script, suffix...)
+}
+
+// splitLines splits s after every newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedDiff returns the differences between x and y in the unified
+// format, with context lines around every change.
+func unifiedDiff(from, to, x, y string, context int) string {
+	script := diffLines(splitLines(x), splitLines(y))
+
+	// the lines of x and y before every line of the script
+	xpos := make([]int, len(script)+1)
+	ypos := make([]int, len(script)+1)
+	for i, line := range script {
+		xpos[i+1], ypos[i+1] = xpos[i], ypos[i]
+		if line.op != '+' {
+			xpos[i+1]++
+		}
+		if line.op != '-' {
+			ypos[i+1]++
+		}
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", from, to)
+	for i := 0; i < len(script); {
+		if script[i].op == ' ' {
+			i++
+			continue
+		}
+		// changes separated by up to twice the context share a hunk
+		last := i
+		for j := i; j < len(script) && j-last <= 2*context+1; j++ {
+			if script[j].op != ' ' {
+				last = j
+			}
+		}
+		start, end := i-context, last+1+context
+		if start < 0 {
+			start = 0
+		}
+		if end > len(script) {
+			end = len(script)
+		}
+
+		xstart, xcount := xpos[start], xpos[end]-xpos[start]
+		ystart, ycount := ypos[start], ypos[end]-ypos[start]
+		if xcount > 0 {
+			xstart++
+		}
+		if ycount > 0 {
+			ystart++
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", xstart, xcount, ystart, ycount)
+		for _, line := range script[start:end] {
+			buf.WriteByte(line.op)
+			buf.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
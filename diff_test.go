@@ -0,0 +1,148 @@
+package afero
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func diffFixture(t *testing.T) (a, b *MemMapFs) {
+	t.Helper()
+	a, b = &MemMapFs{}, &MemMapFs{}
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, fs := range []*MemMapFs{a, b} {
+		for name, content := range map[string]string{
+			"/out/same.txt":   "same",
+			"/out/mode.txt":   "mode",
+			"/out/edit.txt":   "one\ntwo\nthree\n",
+			"/out/touch.txt":  "touch",
+			"/out/kind/x.txt": "x",
+		} {
+			WriteFile(fs, name, []byte(content), 0644)
+			fs.Chtimes(name, mtime, mtime)
+		}
+	}
+	WriteFile(a, "/out/gone.txt", []byte("gone"), 0644)
+	WriteFile(b, "/out/new/file.txt", []byte("new"), 0644)
+	b.Chmod("/out/mode.txt", 0600)
+	WriteFile(b, "/out/edit.txt", []byte("one\n2\nthree\n"), 0644)
+	b.Chtimes("/out/touch.txt", mtime.Add(time.Hour), mtime.Add(time.Hour))
+	b.RemoveAll("/out/kind")
+	WriteFile(b, "/out/kind", []byte("file"), 0644)
+	return a, b
+}
+
+func TestDiff(t *testing.T) {
+	a, b := diffFixture(t)
+	changes, err := Diff(a, b, "/out", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/out/edit.txt", Kind: ChangeModified},
+		{Path: "/out/gone.txt", Kind: ChangeDeleted},
+		{Path: "/out/kind", Kind: ChangeTypeChanged},
+		{Path: "/out/kind/x.txt", Kind: ChangeDeleted},
+		{Path: "/out/mode.txt", Kind: ChangeModeChanged},
+		{Path: "/out/new", Kind: ChangeAdded},
+		{Path: "/out/new/file.txt", Kind: ChangeAdded},
+		{Path: "/out/touch.txt", Kind: ChangeModified},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+
+	changes, err = Diff(a, b, "/out", &DiffOptions{Contents: true, IgnoreModes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range changes {
+		if c.Path == "/out/touch.txt" || c.Path == "/out/mode.txt" {
+			t.Errorf("unexpected change %v", c)
+		}
+	}
+
+	if changes, err := Diff(a, a, "/out", nil); err != nil || len(changes) != 0 {
+		t.Errorf("comparing with itself: got %v, %v", changes, err)
+	}
+}
+
+func TestDiffUnified(t *testing.T) {
+	a, b := diffFixture(t)
+	changes, err := Diff(a, b, "/out/edit.txt", &DiffOptions{Unified: true, Context: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected one change, got %v", changes)
+	}
+	expected := `--- a/out/edit.txt
++++ b/out/edit.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++2
+ three
+`
+	if changes[0].Diff != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, changes[0].Diff)
+	}
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	var x, y []string
+	for i := 0; i < 20; i++ {
+		line := string(rune('a'+i)) + "\n"
+		x = append(x, line)
+		if i != 2 && i != 15 {
+			y = append(y, line)
+		}
+	}
+	y = append(y, "end")
+	got := unifiedDiff("x", "y", strings.Join(x, ""), strings.Join(y, ""), 2)
+	expected := `--- x
++++ y
+@@ -1,5 +1,4 @@
+ a
+ b
+-c
+ d
+ e
+@@ -14,7 +13,7 @@
+ n
+ o
+-p
+ q
+ r
+ s
+ t
++end
+\ No newline at end of file
+`
+	if got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestSameContents(t *testing.T) {
+	a, b := &MemMapFs{}, &MemMapFs{}
+	big := strings.Repeat("x", 70000)
+	for name, contents := range map[string][2]string{
+		"/same":   {big, big},
+		"/last":   {big + "a", big + "b"},
+		"/longer": {big, big + "x"},
+		"/empty":  {"", ""},
+	} {
+		WriteFile(a, name, []byte(contents[0]), 0644)
+		WriteFile(b, name, []byte(contents[1]), 0644)
+	}
+	for name, expected := range map[string]bool{"/same": true, "/last": false, "/longer": false, "/empty": true} {
+		if same, err := sameContents(a, b, name); err != nil || same != expected {
+			t.Errorf("%s: got %v, %v, expected %v", name, same, err, expected)
+		}
+		if same, err := sameContents(b, a, name); err != nil || same != expected {
+			t.Errorf("%s reversed: got %v, %v, expected %v", name, same, err, expected)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package afero
+
+import (
+	"io"
+	mathrand "math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	_ Lstater   = (*FaultFs)(nil)
+	_ Symlinker = (*FaultFs)(nil)
+)
+
+// FaultRule injects a fault into the operations it matches.
+type FaultRule struct {
+	// Op is the name of the matched operation, as in an AuditRecord, or ""
+	// for all of them. "read" and "write" also match readat, writeat and
+	// writestring.
+	Op string
+	// Pattern matches the path, or the name of the file, in the syntax of
+	// GlobWithOptions, with wildcards matching hidden files as well. An
+	// empty Pattern matches every path.
+	Pattern string
+
+	// After is the number of matching calls let through before the rule
+	// fires.
+	After int
+	// Times is how many times the rule fires at most, if not 0.
+	Times int
+	// Probability, if between 0 and 1, is the chance the rule fires on a
+	// matching call.
+	Probability float64
+	// AfterBytes, if not 0, lets reads or writes through until that many
+	// bytes went through the matching files. The call crossing the limit
+	// only moves the bytes up to it.
+	AfterBytes int64
+
+	// Err is the error returned, in an *os.PathError unless it is one
+	// already, or an *os.LinkError. The operation isn't made, unless Limit
+	// or AfterBytes cuts it short: then Err is returned after the bytes
+	// moved.
+	Err error
+	// Limit, if not 0, is the number of bytes moved at most by a read or
+	// write. Short writes fail with io.ErrShortWrite if Err is nil.
+	Limit int
+	// Corrupt flips a byte of the data read or written.
+	Corrupt bool
+	// Delay is waited before the operation.
+	Delay time.Duration
+}
+
+// FailAfterBytes returns a rule making writes to the files matching pattern
+// fail with err once n bytes were written to them, such as syscall.ENOSPC.
+func FailAfterBytes(pattern string, n int64, err error) FaultRule {
+	return FaultRule{Op: "write", Pattern: pattern, AfterBytes: n, Err: err}
+}
+
+// FailOnCall returns a rule making the nth call of op on a path matching
+// pattern fail with err, counting from 1.
+func FailOnCall(op, pattern string, n int, err error) FaultRule {
+	return FaultRule{Op: op, Pattern: pattern, After: n - 1, Times: 1, Err: err}
+}
+
+// The FaultFs injects faults into the operations made on its source and on
+// the files it opens, to test how errors are handled. The first rule firing
+// for an operation decides its fault. Random choices come from the seed
+// given to NewFaultFs, so a run can be repeated.
+type FaultFs struct {
+	source Fs
+
+	mu    sync.Mutex
+	rules []*faultRule
+	rand  *mathrand.Rand
+}
+
+// faultRule is a FaultRule ready to match paths, with its counts.
+type faultRule struct {
+	FaultRule
+	patterns []globPattern
+	calls    int
+	fired    int
+	bytes    int64
+}
+
+// faultAction is what a firing rule does to an operation. limit is -1 if
+// the bytes moved aren't limited.
+type faultAction struct {
+	err     error
+	limit   int
+	corrupt bool
+}
+
+// NewFaultFs returns a FaultFs injecting the faults of rules into source,
+// making its random choices from seed. It fails with ErrBadPattern if a
+// pattern is malformed.
+func NewFaultFs(source Fs, rules []FaultRule, seed int64) (*FaultFs, error) {
+	f := &FaultFs{source: source, rand: mathrand.New(mathrand.NewSource(seed))}
+	for _, rule := range rules {
+		if err := f.Add(rule); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Add appends rule to the rules of f.
+func (f *FaultFs) Add(rule FaultRule) error {
+	compiled := &faultRule{FaultRule: rule}
+	if rule.Pattern != "" {
+		patterns, err := compilePathPattern(rule.Pattern)
+		if err != nil {
+			return err
+		}
+		compiled.patterns = patterns
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, compiled)
+	return nil
+}
+
+// Reset removes every rule of f.
+func (f *FaultFs) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = nil
+}
+
+// matches reports whether r applies to op on path.
+func (r *faultRule) matches(op, path string) bool {
+	switch {
+	case r.Op == "" || r.Op == op:
+	case r.Op == "read" && op == "readat":
+	case r.Op == "write" && (op == "writeat" || op == "writestring"):
+	default:
+		return false
+	}
+	return r.patterns == nil || globMatch(r.patterns, policyNames(path), ignoreGlobOptions)
+}
+
+// fire returns the action of the first rule firing for op on path, moving
+// size bytes or -1 if it isn't a read or write, after its delay. It returns
+// nil if no rule fires.
+func (f *FaultFs) fire(op, path string, size int) *faultAction {
+	f.mu.Lock()
+	var rule *faultRule
+	limit := -1
+	for _, r := range f.rules {
+		if !r.matches(op, path) {
+			continue
+		}
+		if r.AfterBytes != 0 {
+			if size < 0 || r.bytes+int64(size) <= r.AfterBytes {
+				continue
+			}
+		}
+		r.calls++
+		if r.calls <= r.After || r.Times != 0 && r.fired >= r.Times {
+			continue
+		}
+		if r.Probability > 0 && r.Probability < 1 && f.rand.Float64() >= r.Probability {
+			continue
+		}
+		r.fired++
+		rule = r
+		if r.AfterBytes != 0 {
+			limit = int(r.AfterBytes - r.bytes)
+			if limit < 0 {
+				limit = 0
+			}
+		}
+		if r.Limit != 0 && size >= 0 && (limit < 0 || r.Limit < limit) {
+			limit = r.Limit
+		}
+		break
+	}
+	f.mu.Unlock()
+	if rule == nil {
+		return nil
+	}
+	if rule.Delay > 0 {
+		time.Sleep(rule.Delay)
+	}
+	return &faultAction{err: rule.Err, limit: limit, corrupt: rule.Corrupt && size >= 0}
+}
+
+// moved counts n bytes moved by op on path for the rules limiting bytes.
+func (f *FaultFs) moved(op, path string, n int) {
+	if n <= 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.rules {
+		if r.AfterBytes != 0 && r.matches(op, path) {
+			r.bytes += int64(n)
+		}
+	}
+}
+
+// corrupt flips a random byte of p.
+func (f *FaultFs) corrupt(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	f.mu.Lock()
+	i := f.rand.Intn(len(p))
+	f.mu.Unlock()
+	p[i] ^= 0xff
+}
+
+// inject returns the error injected into op on path, if any.
+func (f *FaultFs) inject(op, path string) error {
+	if a := f.fire(op, path, -1); a != nil && a.err != nil {
+		return faultPathError(op, path, a.err)
+	}
+	return nil
+}
+
+// faultPathError returns err in an *os.PathError unless it already is one,
+// or an *os.LinkError.
+func faultPathError(op, path string, err error) error {
+	switch err.(type) {
+	case *os.PathError, *os.LinkError:
+		return err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// faultLinkError is faultPathError for operations on two paths.
+func faultLinkError(op, oldname, newname string, err error) error {
+	switch err.(type) {
+	case *os.PathError, *os.LinkError:
+		return err
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+}
+
+func (f *FaultFs) wrap(file File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f}, nil
+}
+
+func (f *FaultFs) Name() string {
+	return "FaultFs"
+}
+
+func (f *FaultFs) Create(name string) (File, error) {
+	if err := f.inject("create", name); err != nil {
+		return nil, err
+	}
+	return f.wrap(f.source.Create(name))
+}
+
+func (f *FaultFs) Mkdir(name string, perm os.FileMode) error {
+	if err := f.inject("mkdir", name); err != nil {
+		return err
+	}
+	return f.source.Mkdir(name, perm)
+}
+
+func (f *FaultFs) MkdirAll(path string, perm os.FileMode) error {
+	if err := f.inject("mkdirall", path); err != nil {
+		return err
+	}
+	return f.source.MkdirAll(path, perm)
+}
+
+func (f *FaultFs) Open(name string) (File, error) {
+	if err := f.inject("open", name); err != nil {
+		return nil, err
+	}
+	return f.wrap(f.source.Open(name))
+}
+
+func (f *FaultFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := f.inject("openfile", name); err != nil {
+		return nil, err
+	}
+	return f.wrap(f.source.OpenFile(name, flag, perm))
+}
+
+func (f *FaultFs) Remove(name string) error {
+	if err := f.inject("remove", name); err != nil {
+		return err
+	}
+	return f.source.Remove(name)
+}
+
+func (f *FaultFs) RemoveAll(path string) error {
+	if err := f.inject("removeall", path); err != nil {
+		return err
+	}
+	return f.source.RemoveAll(path)
+}
+
+func (f *FaultFs) Rename(oldname, newname string) error {
+	if a := f.fire("rename", oldname, -1); a != nil && a.err != nil {
+		return faultLinkError("rename", oldname, newname, a.err)
+	}
+	return f.source.Rename(oldname, newname)
+}
+
+func (f *FaultFs) Stat(name string) (os.FileInfo, error) {
+	if err := f.inject("stat", name); err != nil {
+		return nil, err
+	}
+	return f.source.Stat(name)
+}
+
+func (f *FaultFs) Chmod(name string, mode os.FileMode) error {
+	if err := f.inject("chmod", name); err != nil {
+		return err
+	}
+	return f.source.Chmod(name, mode)
+}
+
+func (f *FaultFs) Chown(name string, uid, gid int) error {
+	if err := f.inject("chown", name); err != nil {
+		return err
+	}
+	return f.source.Chown(name, uid, gid)
+}
+
+func (f *FaultFs) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.inject("chtimes", name); err != nil {
+		return err
+	}
+	return f.source.Chtimes(name, atime, mtime)
+}
+
+func (f *FaultFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if err := f.inject("lstat", name); err != nil {
+		return nil, false, err
+	}
+	if lsf, ok := f.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := f.source.Stat(name)
+	return fi, false, err
+}
+
+func (f *FaultFs) SymlinkIfPossible(oldname, newname string) error {
+	if a := f.fire("symlink", newname, -1); a != nil && a.err != nil {
+		return faultLinkError("symlink", oldname, newname, a.err)
+	}
+	if linker, ok := f.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (f *FaultFs) ReadlinkIfPossible(name string) (string, error) {
+	if err := f.inject("readlink", name); err != nil {
+		return "", err
+	}
+	if reader, ok := f.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// faultFile injects the faults of its FaultFs into the operations on a
+// file.
+type faultFile struct {
+	File
+	fs *FaultFs
+}
+
+// transfer reads, if read is set, or writes p through do, applying the
+// faults of op.
+func (f *faultFile) transfer(op string, p []byte, read bool, do func([]byte) (int, error)) (int, error) {
+	name := f.Name()
+	a := f.fs.fire(op, name, len(p))
+	if a == nil {
+		n, err := do(p)
+		f.fs.moved(op, name, n)
+		return n, err
+	}
+	if a.err != nil && a.limit < 0 {
+		return 0, faultPathError(op, name, a.err)
+	}
+	short := a.limit >= 0 && a.limit < len(p)
+	if short {
+		p = p[:a.limit]
+	}
+	if a.corrupt && !read {
+		p = append([]byte(nil), p...)
+		f.fs.corrupt(p)
+	}
+	n, err := do(p)
+	f.fs.moved(op, name, n)
+	if a.corrupt && read {
+		f.fs.corrupt(p[:n])
+	}
+	switch {
+	case err != nil:
+		return n, err
+	case a.err != nil:
+		return n, faultPathError(op, name, a.err)
+	case short && !read:
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (f *faultFile) Close() error {
+	if a := f.fs.fire("close", f.Name(), -1); a != nil && a.err != nil {
+		f.File.Close()
+		return faultPathError("close", f.Name(), a.err)
+	}
+	return f.File.Close()
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	return f.transfer("read", p, true, f.File.Read)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.transfer("readat", p, true, func(p []byte) (int, error) {
+		return f.File.ReadAt(p, off)
+	})
+}
+
+func (f *faultFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.fs.inject("seek", f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	return f.transfer("write", p, false, f.File.Write)
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	return f.transfer("writeat", p, false, func(p []byte) (int, error) {
+		return f.File.WriteAt(p, off)
+	})
+}
+
+func (f *faultFile) WriteString(s string) (int, error) {
+	return f.transfer("writestring", []byte(s), false, func(p []byte) (int, error) {
+		return f.File.WriteString(string(p))
+	})
+}
+
+func (f *faultFile) Readdir(count int) ([]os.FileInfo, error) {
+	if err := f.fs.inject("readdir", f.Name()); err != nil {
+		return nil, err
+	}
+	return f.File.Readdir(count)
+}
+
+func (f *faultFile) Readdirnames(n int) ([]string, error) {
+	if err := f.fs.inject("readdirnames", f.Name()); err != nil {
+		return nil, err
+	}
+	return f.File.Readdirnames(n)
+}
+
+func (f *faultFile) Stat() (os.FileInfo, error) {
+	if err := f.fs.inject("fstat", f.Name()); err != nil {
+		return nil, err
+	}
+	return f.File.Stat()
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.inject("sync", f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if err := f.fs.inject("truncate", f.Name()); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
@@ -0,0 +1,192 @@
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFaultFsErrors(t *testing.T) {
+	fs, err := NewFaultFs(NewMemMapFs(), []FaultRule{
+		{Op: "mkdir", Pattern: "/ro/**", Err: syscall.EROFS},
+		{Op: "sync", Err: syscall.EIO},
+		{Op: "rename", Err: syscall.EXDEV},
+		FailOnCall("stat", "/a", 2, syscall.EACCES),
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Mkdir("/ro/dir", 0755); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Mkdir: %v", err)
+	}
+	if err := fs.Mkdir("/rw", 0755); err != nil {
+		t.Errorf("Mkdir: %v", err)
+	}
+
+	f, err := fs.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Sync()
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.EIO || perr.Path != "/a" {
+		t.Errorf("Sync: %v", err)
+	}
+	f.Close()
+
+	if err := fs.Rename("/a", "/b"); !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("Rename: %v", err)
+	} else if _, ok := err.(*os.LinkError); !ok {
+		t.Errorf("Rename: %T, want *os.LinkError", err)
+	}
+
+	for i, want := range []error{nil, syscall.EACCES, nil} {
+		if _, err := fs.Stat("/a"); !errors.Is(err, want) && err != want {
+			t.Errorf("Stat call %d: %v, want %v", i+1, err, want)
+		}
+	}
+
+	fs.Reset()
+	if err := fs.Mkdir("/ro/dir", 0755); err != nil && !os.IsNotExist(err) {
+		t.Errorf("Mkdir after Reset: %v", err)
+	}
+}
+
+func TestFaultFsFailAfterBytes(t *testing.T) {
+	fs, err := NewFaultFs(NewMemMapFs(), []FaultRule{FailAfterBytes("/*.log", 10, syscall.ENOSPC)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/out.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write([]byte("123456")); n != 6 || err != nil {
+		t.Fatalf("first Write: %d, %v", n, err)
+	}
+	if n, err := f.WriteString("789012"); n != 4 || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("second Write: %d, %v", n, err)
+	}
+	if n, err := f.Write([]byte("x")); n != 0 || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("third Write: %d, %v", n, err)
+	}
+	f.Close()
+	checkFile(t, fs, "/out.log", "1234567890")
+
+	if err := WriteFile(fs, "/other.txt", []byte("not limited at all"), 0644); err != nil {
+		t.Errorf("WriteFile: %v", err)
+	}
+}
+
+func TestFaultFsShortAndCorrupt(t *testing.T) {
+	fs, err := NewFaultFs(NewMemMapFs(), []FaultRule{
+		{Op: "read", Pattern: "/short", Limit: 2},
+		{Op: "write", Pattern: "/short", Limit: 3},
+		{Op: "read", Pattern: "/corrupt", Corrupt: true},
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write([]byte("abcdef")); n != 3 || err != io.ErrShortWrite {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	buf := make([]byte, 10)
+	if n, err := f.ReadAt(buf, 0); n != 2 || err != nil || string(buf[:n]) != "ab" {
+		t.Fatalf("ReadAt: %d, %v, %q", n, err, buf[:n])
+	}
+	f.Close()
+
+	data := []byte("some data to corrupt")
+	if err := WriteFile(fs, "/corrupt", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadFile(fs, "/corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(data) || bytes.Equal(read, data) {
+		t.Errorf("ReadFile = %q, want a corrupted %q", read, data)
+	}
+}
+
+func TestFaultFsClose(t *testing.T) {
+	fs, err := NewFaultFs(NewMemMapFs(), []FaultRule{{Op: "close", Err: syscall.EIO}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); !errors.Is(err, syscall.EIO) {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("Write after a failed Close succeeded: the file must be closed anyway")
+	}
+}
+
+func TestFaultFsProbability(t *testing.T) {
+	failures := func(seed int64) []bool {
+		fs, err := NewFaultFs(NewMemMapFs(), []FaultRule{{Op: "stat", Probability: 0.5, Err: syscall.EIO}}, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var failed []bool
+		for i := 0; i < 50; i++ {
+			_, err := fs.Stat("/")
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+
+	first, again := failures(42), failures(42)
+	var count int
+	for i := range first {
+		if first[i] != again[i] {
+			t.Fatal("the same seed gave different faults")
+		}
+		if first[i] {
+			count++
+		}
+	}
+	if count == 0 || count == len(first) {
+		t.Errorf("%d failures out of %d", count, len(first))
+	}
+}
+
+func TestFaultFsDelay(t *testing.T) {
+	fs, err := NewFaultFs(NewMemMapFs(), []FaultRule{{Op: "readdir", Delay: 20 * time.Millisecond, Times: 1}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	start := time.Now()
+	if _, err := f.Readdir(-1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Readdir took %v, want a delay", elapsed)
+	}
+}
+
+func TestFaultFsBadPattern(t *testing.T) {
+	if _, err := NewFaultFs(NewMemMapFs(), []FaultRule{{Pattern: "/[a"}}, 1); err != filepath.ErrBadPattern {
+		t.Errorf("NewFaultFs: %v, want ErrBadPattern", err)
+	}
+}
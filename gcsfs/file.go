@@ -40,6 +40,11 @@ type GcsFile struct {
 	closed    bool
 	ReadDirIt stiface.ObjectIterator
 	resource  *gcsFileResource
+
+	// dirEntries holds the directory entries from the first Readdir call on,
+	// dirOffset the number of them already returned
+	dirEntries []os.FileInfo
+	dirOffset  int
 }
 
 func NewGcsFile(
@@ -143,6 +148,9 @@ func (o *GcsFile) ReadAt(p []byte, off int64) (n int, err error) {
 
 	read, err := o.resource.ReadAt(p, off)
 	o.fhOffset += int64(read)
+	if err == nil && read < len(p) {
+		err = io.EOF
+	}
 	return read, err
 }
 
@@ -250,20 +258,31 @@ func (o *GcsFile) readdirImpl(count int) ([]*FileInfo, error) {
 }
 
 func (o *GcsFile) Readdir(count int) ([]os.FileInfo, error) {
-	fi, err := o.readdirImpl(count)
-	if len(fi) > 0 {
+	if o.dirEntries == nil {
+		fi, err := o.readdirImpl(-1)
+		if err != nil {
+			return nil, err
+		}
 		sort.Sort(ByName(fi))
+
+		o.dirEntries = make([]os.FileInfo, 0, len(fi))
+		for _, f := range fi {
+			o.dirEntries = append(o.dirEntries, f)
+		}
 	}
 
+	fi := o.dirEntries[o.dirOffset:]
 	if count > 0 {
-		fi = fi[:count]
+		if len(fi) == 0 {
+			return nil, io.EOF
+		}
+		if len(fi) > count {
+			fi = fi[:count]
+		}
 	}
+	o.dirOffset += len(fi)
 
-	var res []os.FileInfo
-	for _, f := range fi {
-		res = append(res, f)
-	}
-	return res, err
+	return append([]os.FileInfo(nil), fi...), nil
 }
 
 func (o *GcsFile) Readdirnames(n int) ([]string, error) {
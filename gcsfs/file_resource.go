@@ -17,7 +17,6 @@
 package gcsfs
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -251,8 +250,8 @@ func (o *gcsFileResource) Truncate(wantedSize int64) error {
 	}
 
 	for written < wantedSize {
-		//Bulk up padding writes
-		paddingBytes := bytes.Repeat([]byte(" "), min(maxWriteSize, int(wantedSize-written)))
+		//Bulk up padding writes, zeroed like os.File.Truncate does
+		paddingBytes := make([]byte, min(maxWriteSize, int(wantedSize-written)))
 
 		n := 0
 		if n, err = w.Write(paddingBytes); err != nil {
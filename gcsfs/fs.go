@@ -22,9 +22,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
 )
 
@@ -39,8 +41,13 @@ type Fs struct {
 	client    stiface.Client
 	separator string
 
-	buckets       map[string]stiface.BucketHandle
+	buckets map[string]stiface.BucketHandle
+	// rawGcsObjects holds the files opened with ctx, whose resources are
+	// shared by later opens, guarded by rawGcsMu. Files opened with another
+	// context aren't kept, as their resources are bound to it.
+	rawGcsMu      *sync.Mutex
 	rawGcsObjects map[string]*GcsFile
+	contextual    bool
 
 	autoRemoveEmptyFolders bool //trigger for creating "virtual folders" (not required by GCSs)
 }
@@ -54,6 +61,7 @@ func NewGcsFsWithSeparator(ctx context.Context, client stiface.Client, folderSep
 		ctx:           ctx,
 		client:        client,
 		separator:     folderSep,
+		rawGcsMu:      &sync.Mutex{},
 		rawGcsObjects: make(map[string]*GcsFile),
 
 		autoRemoveEmptyFolders: true,
@@ -152,7 +160,11 @@ func (fs *Fs) Create(name string) (*GcsFile, error) {
 	}
 	file := NewGcsFile(fs.ctx, fs, obj, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0, name)
 
-	fs.rawGcsObjects[name] = file
+	if !fs.contextual {
+		fs.rawGcsMu.Lock()
+		fs.rawGcsObjects[name] = file
+		fs.rawGcsMu.Unlock()
+	}
 	return file, nil
 }
 
@@ -171,6 +183,11 @@ func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
 		return ErrEmptyObjectName
 	}
 
+	// writing the object would silently replace an existing folder
+	if _, err := fs.Stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
 	obj, err := fs.getObj(name)
 	if err != nil {
 		return err
@@ -209,7 +226,7 @@ func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
 			continue
 		}
 
-		if err := fs.Mkdir(root, perm); err != nil {
+		if err := fs.Mkdir(root, perm); err != nil && !os.IsExist(err) {
 			return err
 		}
 	}
@@ -228,8 +245,17 @@ func (fs *Fs) OpenFile(name string, flag int, fileMode os.FileMode) (*GcsFile, e
 	if err = validateName(name); err != nil {
 		return nil, err
 	}
+	if err = fs.ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	f, found := fs.rawGcsObjects[name]
+	var f *GcsFile
+	var found bool
+	if !fs.contextual {
+		fs.rawGcsMu.Lock()
+		f, found = fs.rawGcsObjects[name]
+		fs.rawGcsMu.Unlock()
+	}
 	if found {
 		file = NewGcsFileFromOldFH(flag, fileMode, f.resource)
 	} else {
@@ -250,7 +276,7 @@ func (fs *Fs) OpenFile(name string, flag int, fileMode os.FileMode) (*GcsFile, e
 
 	if flag&os.O_TRUNC != 0 {
 		err = file.resource.obj.Delete(fs.ctx)
-		if err != nil {
+		if err != nil && err != storage.ErrObjectNotExist {
 			return nil, err
 		}
 		return fs.Create(name)
@@ -266,6 +292,9 @@ func (fs *Fs) OpenFile(name string, flag int, fileMode os.FileMode) (*GcsFile, e
 	if flag&os.O_CREATE != 0 {
 		_, err = file.Stat()
 		if err == nil { // the file actually exists
+			if flag&os.O_EXCL != 0 {
+				return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+			}
 			return nil, syscall.EPERM
 		}
 
@@ -291,7 +320,7 @@ func (fs *Fs) Remove(name string) error {
 	if err != nil {
 		return err
 	}
-	delete(fs.rawGcsObjects, name)
+	fs.forget(name)
 
 	if info.IsDir() {
 		// it's a folder, we ha to check its contents - it cannot be removed, if not empty
@@ -366,6 +395,16 @@ func (fs *Fs) Rename(oldName, newName string) error {
 	if err := validateName(newName); err != nil {
 		return err
 	}
+	// writing the object would make up the folders of the new name, which
+	// have to exist already
+	if i := strings.LastIndex(newName, fs.separator); i > 0 {
+		if _, err := fs.Stat(newName[:i+len(fs.separator)]); err != nil {
+			if err == ErrFileNotFound {
+				return &os.PathError{Op: "rename", Path: newName, Err: err}
+			}
+			return err
+		}
+	}
 
 	src, err := fs.getObj(oldName)
 	if err != nil {
@@ -379,10 +418,17 @@ func (fs *Fs) Rename(oldName, newName string) error {
 	if _, err = dst.CopierFrom(src).Run(fs.ctx); err != nil {
 		return err
 	}
-	delete(fs.rawGcsObjects, oldName)
+	fs.forget(oldName)
 	return src.Delete(fs.ctx)
 }
 
+// forget drops the shared resource of the file name.
+func (fs *Fs) forget(name string) {
+	fs.rawGcsMu.Lock()
+	delete(fs.rawGcsObjects, name)
+	fs.rawGcsMu.Unlock()
+}
+
 func (fs *Fs) Stat(name string) (os.FileInfo, error) {
 	name = fs.ensureNoLeadingSeparator(fs.normSeparators(ensureNoPrefix(name)))
 	if err := validateName(name); err != nil {
@@ -403,3 +449,68 @@ func (fs *Fs) Chtimes(_ string, _, _ time.Time) error {
 func (fs *Fs) Chown(_ string, _, _ int) error {
 	return errors.New("method Chown is not implemented for GCS")
 }
+
+// withContext returns a shallow copy of fs, sharing the client, which
+// performs every GCS call with ctx. The files it opens get resources of
+// their own, bound to ctx, rather than sharing those of fs, so that ctx
+// ending doesn't break later opens of the same files.
+func (fs *Fs) withContext(ctx context.Context) *Fs {
+	c := *fs
+	c.ctx = ctx
+	c.contextual = true
+	return &c
+}
+
+// CreateContext is like Create, but uses ctx for the GCS calls made by the
+// returned file as well.
+func (fs *Fs) CreateContext(ctx context.Context, name string) (*GcsFile, error) {
+	return fs.withContext(ctx).Create(name)
+}
+
+func (fs *Fs) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.withContext(ctx).Mkdir(name, perm)
+}
+
+func (fs *Fs) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	return fs.withContext(ctx).MkdirAll(path, perm)
+}
+
+// OpenContext is like Open, but uses ctx for the GCS calls made by the
+// returned file as well.
+func (fs *Fs) OpenContext(ctx context.Context, name string) (*GcsFile, error) {
+	return fs.withContext(ctx).Open(name)
+}
+
+// OpenFileContext is like OpenFile, but uses ctx for the GCS calls made by
+// the returned file as well.
+func (fs *Fs) OpenFileContext(ctx context.Context, name string, flag int, fileMode os.FileMode) (*GcsFile, error) {
+	return fs.withContext(ctx).OpenFile(name, flag, fileMode)
+}
+
+func (fs *Fs) RemoveContext(ctx context.Context, name string) error {
+	return fs.withContext(ctx).Remove(name)
+}
+
+func (fs *Fs) RemoveAllContext(ctx context.Context, path string) error {
+	return fs.withContext(ctx).RemoveAll(path)
+}
+
+func (fs *Fs) RenameContext(ctx context.Context, oldName, newName string) error {
+	return fs.withContext(ctx).Rename(oldName, newName)
+}
+
+func (fs *Fs) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.withContext(ctx).Stat(name)
+}
+
+func (fs *Fs) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	return fs.withContext(ctx).Chmod(name, mode)
+}
+
+func (fs *Fs) ChtimesContext(ctx context.Context, name string, atime, mtime time.Time) error {
+	return fs.withContext(ctx).Chtimes(name, atime, mtime)
+}
+
+func (fs *Fs) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	return fs.withContext(ctx).Chown(name, uid, gid)
+}
@@ -28,6 +28,8 @@ import (
 	"google.golang.org/api/option"
 )
 
+var _ afero.FsContext = (*GcsFs)(nil)
+
 type GcsFs struct {
 	source *Fs
 }
@@ -112,3 +114,42 @@ func (fs *GcsFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 func (fs *GcsFs) Chown(name string, uid, gid int) error {
 	return fs.source.Chown(name, uid, gid)
 }
+
+// Context-aware variants, cancelling the underlying GCS requests with ctx.
+
+func (fs *GcsFs) CreateContext(ctx context.Context, name string) (afero.File, error) {
+	return fs.source.CreateContext(ctx, name)
+}
+func (fs *GcsFs) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.source.MkdirContext(ctx, name, perm)
+}
+func (fs *GcsFs) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	return fs.source.MkdirAllContext(ctx, path, perm)
+}
+func (fs *GcsFs) OpenContext(ctx context.Context, name string) (afero.File, error) {
+	return fs.source.OpenContext(ctx, name)
+}
+func (fs *GcsFs) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (afero.File, error) {
+	return fs.source.OpenFileContext(ctx, name, flag, perm)
+}
+func (fs *GcsFs) RemoveContext(ctx context.Context, name string) error {
+	return fs.source.RemoveContext(ctx, name)
+}
+func (fs *GcsFs) RemoveAllContext(ctx context.Context, path string) error {
+	return fs.source.RemoveAllContext(ctx, path)
+}
+func (fs *GcsFs) RenameContext(ctx context.Context, oldname, newname string) error {
+	return fs.source.RenameContext(ctx, oldname, newname)
+}
+func (fs *GcsFs) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.source.StatContext(ctx, name)
+}
+func (fs *GcsFs) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	return fs.source.ChmodContext(ctx, name, mode)
+}
+func (fs *GcsFs) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	return fs.source.ChtimesContext(ctx, name, atime, mtime)
+}
+func (fs *GcsFs) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	return fs.source.ChownContext(ctx, name, uid, gid)
+}
@@ -12,6 +12,7 @@
 package gcsfs
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"os"
@@ -49,7 +50,10 @@ type bucketMock struct {
 	fs afero.Fs
 }
 
-func (m *bucketMock) Attrs(context.Context) (*storage.BucketAttrs, error) {
+func (m *bucketMock) Attrs(ctx context.Context) (*storage.BucketAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return &storage.BucketAttrs{}, nil
 }
 
@@ -72,6 +76,10 @@ func (o *objectMock) NewWriter(_ context.Context) stiface.Writer {
 	return &writerMock{name: o.name, fs: o.fs}
 }
 
+func (o *objectMock) NewReader(ctx context.Context) (stiface.Reader, error) {
+	return o.NewRangeReader(ctx, 0, -1)
+}
+
 func (o *objectMock) NewRangeReader(_ context.Context, offset, length int64) (stiface.Reader, error) {
 	if o.name == "" {
 		return nil, ErrEmptyObjectName
@@ -92,23 +100,62 @@ func (o *objectMock) NewRangeReader(_ context.Context, offset, length int64) (st
 	res := &readerMock{file: file}
 	if length > -1 {
 		res.buf = make([]byte, length)
-		_, err = file.Read(res.buf)
-		if err != nil {
+		n, err := io.ReadFull(file, res.buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 			return nil, err
 		}
+		res.buf = res.buf[:n]
+		res.ranged = true
 	}
 
 	return res, nil
 }
 
-func (o *objectMock) Delete(_ context.Context) error {
+func (o *objectMock) Delete(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if o.name == "" {
 		return ErrEmptyObjectName
 	}
-	return o.fs.Remove(o.name)
+	err := o.fs.Remove(o.name)
+	if os.IsNotExist(err) {
+		return storage.ErrObjectNotExist
+	}
+	return err
+}
+
+func (o *objectMock) CopierFrom(src stiface.ObjectHandle) stiface.Copier {
+	return &copierMock{dst: o, src: src.(*objectMock)}
+}
+
+type copierMock struct {
+	stiface.Copier
+
+	dst, src *objectMock
+}
+
+func (c *copierMock) Run(ctx context.Context) (*storage.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(c.src.fs, c.src.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, storage.ErrObjectNotExist
+		}
+		return nil, err
+	}
+	if err := afero.WriteFile(c.dst.fs, c.dst.name, data, 0644); err != nil {
+		return nil, err
+	}
+	return c.dst.Attrs(ctx)
 }
 
-func (o *objectMock) Attrs(_ context.Context) (*storage.ObjectAttrs, error) {
+func (o *objectMock) Attrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if o.name == "" {
 		return nil, ErrEmptyObjectName
 	}
@@ -135,13 +182,16 @@ func (o *objectMock) Attrs(_ context.Context) (*storage.ObjectAttrs, error) {
 	return res, nil
 }
 
+// writerMock commits the object on Close, like GCS does, so that an object
+// can be rewritten while it is being read.
 type writerMock struct {
 	stiface.Writer
 
 	name string
 	fs   afero.Fs
 
-	file afero.File
+	buf     bytes.Buffer
+	written bool
 }
 
 func (w *writerMock) Write(p []byte) (n int, err error) {
@@ -149,38 +199,27 @@ func (w *writerMock) Write(p []byte) (n int, err error) {
 		return 0, ErrEmptyObjectName
 	}
 
-	if w.file == nil {
-		w.file, err = w.fs.Create(w.name)
-		if err != nil {
-			return 0, err
-		}
-	}
-
-	return w.file.Write(p)
+	w.written = true
+	return w.buf.Write(p)
 }
 
 func (w *writerMock) Close() error {
 	if w.name == "" {
 		return ErrEmptyObjectName
 	}
-	if w.file == nil {
-		var err error
-		if strings.HasSuffix(w.name, "/") {
-			err = w.fs.Mkdir(w.name, 0755)
-			if err != nil {
-				return err
-			}
-		} else {
-			_, err = w.Write([]byte{})
-			if err != nil {
-				return err
-			}
-		}
+	if !w.written && strings.HasSuffix(w.name, "/") {
+		return w.fs.Mkdir(w.name, 0755)
+	}
+
+	file, err := w.fs.Create(w.name)
+	if err != nil {
+		return err
 	}
-	if w.file != nil {
-		return w.file.Close()
+	if _, err = file.Write(w.buf.Bytes()); err != nil {
+		file.Close()
+		return err
 	}
-	return nil
+	return file.Close()
 }
 
 type readerMock struct {
@@ -188,17 +227,34 @@ type readerMock struct {
 
 	file afero.File
 
-	buf []byte
+	// a ranged reader only returns buf
+	buf    []byte
+	ranged bool
 }
 
 func (r *readerMock) Remain() int64 {
-	return 0
+	if r.ranged {
+		return int64(len(r.buf))
+	}
+	info, err := r.file.Stat()
+	if err != nil {
+		return 0
+	}
+	pos, err := r.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0
+	}
+	return info.Size() - pos
 }
 
 func (r *readerMock) Read(p []byte) (int, error) {
-	if r.buf != nil {
-		copy(p, r.buf)
-		return len(r.buf), nil
+	if r.ranged {
+		if len(r.buf) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(p, r.buf)
+		r.buf = r.buf[n:]
+		return n, nil
 	}
 	return r.file.Read(p)
 }
@@ -22,6 +22,7 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
 	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
 )
 
 const (
@@ -69,7 +70,7 @@ func TestMain(m *testing.M) {
 
 	// in order to respect deferring
 	var exitCode int
-	defer os.Exit(exitCode)
+	defer func() { os.Exit(exitCode) }()
 
 	defer func() {
 		err := recover()
@@ -576,6 +577,15 @@ func TestGcsReaddir(t *testing.T) {
 				t.Errorf("%v: children, got '%v', expected '%v'", name, fileNames, d.children)
 			}
 
+			// the directory has been read to the end
+			if _, err := dir.Readdir(1); err != io.EOF {
+				t.Errorf("%v: expected io.EOF, got %v", name, err)
+			}
+
+			dir, err = gcsAfs.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
 			fi, err = dir.Readdir(1)
 			if err != nil {
 				t.Fatal(err)
@@ -639,6 +649,15 @@ func TestGcsReaddirnames(t *testing.T) {
 				t.Errorf("%v: children, got '%v', expected '%v'", name, fileNames, d.children)
 			}
 
+			// the directory has been read to the end
+			if _, err := dir.Readdirnames(1); err != io.EOF {
+				t.Errorf("%v: expected io.EOF, got %v", name, err)
+			}
+
+			dir, err = gcsAfs.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
 			fileNames, err = dir.Readdirnames(1)
 			if err != nil {
 				t.Fatal(err)
@@ -836,3 +855,95 @@ func TestGcsRemoveAll(t *testing.T) {
 		}
 	})
 }
+
+func TestGcsRename(t *testing.T) {
+	name := filepath.Join(bucketName, "renamed")
+	if err := gcsAfs.WriteFile(name, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer gcsAfs.Remove(name)
+	aDir := filepath.Join(bucketName, "a")
+	newName := filepath.Join(aDir, "renamed")
+
+	err := gcsAfs.Rename(name, newName)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error renaming into a missing folder, got: %v", err)
+	}
+	if _, err := gcsAfs.Stat(aDir); err == nil {
+		t.Errorf("folder %s was created by a failed rename", aDir)
+	}
+	if data, err := gcsAfs.ReadFile(name); err != nil || string(data) != "content" {
+		t.Errorf("file after a failed rename: %q, %v", data, err)
+	}
+
+	if err := gcsAfs.Mkdir(aDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer gcsAfs.RemoveAll(aDir)
+	if err := gcsAfs.Rename(name, newName); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := gcsAfs.ReadFile(newName); err != nil || string(data) != "content" {
+		t.Errorf("renamed file: %q, %v", data, err)
+	}
+}
+
+func TestGcsContext(t *testing.T) {
+	createFiles(t)
+	defer removeFiles(t)
+
+	cfs, ok := gcsAfs.Fs.(afero.FsContext)
+	if !ok {
+		t.Fatal("GcsFs does not implement afero.FsContext")
+	}
+	name := filepath.Join(bucketName, "testFile")
+
+	if _, err := cfs.StatContext(context.Background(), name); err != nil {
+		t.Fatalf("stat with a live context failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := cfs.StatContext(ctx, name); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected stat to fail with context.Canceled, got: %v", err)
+	}
+	if _, err := cfs.OpenContext(ctx, name); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected open to fail with context.Canceled, got: %v", err)
+	}
+	if err := afero.WithContext(gcsAfs.Fs, ctx).Remove(name); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected remove to fail with context.Canceled, got: %v", err)
+	}
+	if _, err := gcsAfs.Stat(name); err != nil {
+		t.Errorf("file must survive a cancelled remove: %v", err)
+	}
+
+	// A file created with a context that ends later must stay usable.
+	reqCtx, reqCancel := context.WithCancel(context.Background())
+	created := filepath.Join(bucketName, "ctxFile")
+	f, err := cfs.CreateContext(reqCtx, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reqCancel()
+	defer gcsAfs.Remove(created)
+	data, err := gcsAfs.ReadFile(created)
+	if err != nil || string(data) != "data" {
+		t.Errorf("reading after the context of its creation ended: %q, %v", data, err)
+	}
+	if err := gcsAfs.WriteFile(created, []byte("more"), 0644); err != nil {
+		t.Errorf("writing after the context of its creation ended: %v", err)
+	}
+}
+
+func TestGcsConformance(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		return afero.NewBasePathFs(&GcsFs{NewGcsFs(context.Background(), newClientMock())}, bucketName)
+	}, aferotest.Chmod, aferotest.Chown, aferotest.Chtimes, aferotest.Symlinks)
+}
@@ -0,0 +1,533 @@
+package afero
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GlobOptions tune GlobWithOptions.
+type GlobOptions struct {
+	// CaseInsensitive matches names regardless of case.
+	CaseInsensitive bool
+	// Hidden lets wildcards match names starting with a dot, which are
+	// otherwise only matched by pattern segments starting with a dot.
+	Hidden bool
+	// Exclude leaves out the paths matching any of these patterns, and
+	// everything below them. A pattern without a separator is matched
+	// against the last name of paths, and a relative one with a separator,
+	// such as "vendor/**", against the paths below the directory the search
+	// starts from: the leading directories of pattern without wildcards.
+	// Wildcards in these patterns match names starting with a dot as well.
+	Exclude []string
+}
+
+// GlobWithOptions returns the names of all files matching pattern, sorted,
+// or nil if there is no matching file. On top of the syntax of Match,
+// pattern may contain
+//
+//	**      as a whole path segment, any number of directories, even none
+//	{a,b}   either alternative, which may be nested and hold separators
+//	[!a-z]  a negated character class, like [^a-z]
+//
+// Only the directories which may hold a match are read, and the segments
+// without wildcards are looked up directly, which keeps the number of
+// Readdir calls small on remote filesystems. "**" doesn't descend into
+// symbolic links.
+//
+// Like Glob, GlobWithOptions ignores file system errors such as I/O errors
+// reading directories. The only possible returned error is ErrBadPattern,
+// when a pattern is malformed. A nil opts stands for the zero GlobOptions.
+func GlobWithOptions(fs Fs, pattern string, opts *GlobOptions) ([]string, error) {
+	if opts == nil {
+		opts = &GlobOptions{}
+	}
+	g := &globber{fs: fs, opts: opts}
+
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	starts := map[string][]globState{}
+	var bases []string
+	for _, p := range patterns {
+		base, segs, err := compileGlob(p, opts)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := starts[base]; !ok {
+			bases = append(bases, base)
+		}
+		starts[base] = append(starts[base], globState{len(g.patterns), 0})
+		g.patterns = append(g.patterns, segs)
+	}
+	for _, p := range opts.Exclude {
+		excludes, err := expandBraces(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, exclude := range excludes {
+			names := strings.Split(globSlash(exclude), "/")
+			anchored := len(names) > 1 && names[0] != "" && filepath.VolumeName(exclude) == ""
+			if len(names) == 1 {
+				names = append([]string{"**"}, names...)
+			}
+			segs, err := compileGlobSegments(names, opts)
+			if err != nil {
+				return nil, err
+			}
+			if anchored {
+				g.anchored = append(g.anchored, segs)
+			} else {
+				g.exclude = append(g.exclude, segs)
+			}
+		}
+	}
+
+	for _, base := range bases {
+		g.base = base
+		states := starts[base]
+		if len(g.patterns[states[0].p]) == 0 {
+			// nothing to match but the base itself
+			if _, err := lstatIfPossible(fs, base); err == nil && !g.excluded(base) {
+				g.matches = append(g.matches, base)
+			}
+		}
+		g.search(base, states)
+	}
+
+	sort.Strings(g.matches)
+	matches := g.matches[:0]
+	for i, m := range g.matches {
+		if i == 0 || m != g.matches[i-1] {
+			matches = append(matches, m)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	return matches, nil
+}
+
+// globSegment matches a single name of a path.
+type globSegment struct {
+	// anyDirs is set for "**"
+	anyDirs bool
+	// literal is the name matched by a segment without wildcards, if re
+	// is nil
+	literal string
+	re      *regexp.Regexp
+	// dot is set if the segment starts with a literal dot
+	dot bool
+}
+
+func (s *globSegment) match(name string, opts *GlobOptions) bool {
+	if strings.HasPrefix(name, ".") && !s.dot && !opts.Hidden {
+		return false
+	}
+	switch {
+	case s.anyDirs:
+		return true
+	case s.re != nil:
+		return s.re.MatchString(name)
+	}
+	return name == s.literal
+}
+
+// globPattern is a pattern split into the segments names are matched with.
+type globPattern []globSegment
+
+// globState is a position in a pattern: the segment s of patterns[p].
+type globState struct {
+	p, s int
+}
+
+// closure adds to states those reached by "**" matching no directory.
+func closure(patterns []globPattern, states []globState) []globState {
+	seen := make(map[globState]bool, len(states))
+	var result []globState
+	for _, st := range states {
+		for !seen[st] {
+			seen[st] = true
+			result = append(result, st)
+			if st.s == len(patterns[st.p]) || !patterns[st.p][st.s].anyDirs {
+				break
+			}
+			st.s++
+		}
+	}
+	return result
+}
+
+// globSlash turns the separators of pattern into slashes.
+func globSlash(pattern string) string {
+	if filepath.Separator == '\\' {
+		return filepath.ToSlash(pattern)
+	}
+	return pattern
+}
+
+// expandBraces returns the patterns pattern stands for once its {a,b}
+// alternations are expanded. Braces without a comma are kept as they are.
+func expandBraces(pattern string) ([]string, error) {
+	escapes := filepath.Separator != '\\'
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case '[':
+			// braces don't group within a class
+			for j := i + 1; j < len(pattern); j++ {
+				if pattern[j] == '\\' && escapes {
+					j++
+				} else if pattern[j] == ']' {
+					i = j
+					break
+				}
+			}
+		case '{':
+			depth, end := 0, -1
+			var commas []int
+		scan:
+			for j := i; j < len(pattern); j++ {
+				switch pattern[j] {
+				case '\\':
+					if escapes {
+						j++
+					}
+				case '{':
+					depth++
+				case ',':
+					if depth == 1 {
+						commas = append(commas, j)
+					}
+				case '}':
+					depth--
+					if depth == 0 {
+						end = j
+						break scan
+					}
+				}
+			}
+			if end < 0 {
+				return nil, filepath.ErrBadPattern
+			}
+			if len(commas) == 0 {
+				continue
+			}
+			var expanded []string
+			start := i + 1
+			for _, comma := range append(commas, end) {
+				alternative := pattern[:i] + pattern[start:comma] + pattern[end+1:]
+				patterns, err := expandBraces(alternative)
+				if err != nil {
+					return nil, err
+				}
+				expanded = append(expanded, patterns...)
+				start = comma + 1
+			}
+			return expanded, nil
+		}
+	}
+	return []string{pattern}, nil
+}
+
+// compileGlob splits pattern into the directory the search starts from, made
+// of its leading segments without wildcards, and the segments matched below.
+func compileGlob(pattern string, opts *GlobOptions) (string, globPattern, error) {
+	pattern = globSlash(pattern)
+	base := filepath.VolumeName(pattern)
+	rest := pattern[len(base):]
+	if strings.HasPrefix(rest, "/") {
+		base += string(filepath.Separator)
+	}
+	var names []string
+	for _, name := range strings.Split(rest, "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	segs, err := compileGlobSegments(names, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	for len(segs) > 0 && !segs[0].anyDirs && segs[0].re == nil {
+		base = filepath.Join(base, segs[0].literal)
+		segs = segs[1:]
+	}
+	if base == "" {
+		base = "."
+	}
+	return base, segs, nil
+}
+
+func compileGlobSegments(names []string, opts *GlobOptions) (globPattern, error) {
+	segs := make(globPattern, len(names))
+	for i, name := range names {
+		seg, err := compileGlobSegment(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		segs[i] = seg
+	}
+	return segs, nil
+}
+
+// compileGlobSegment turns a pattern segment into a regular expression,
+// unless it has no wildcards and case matters.
+func compileGlobSegment(pattern string, opts *GlobOptions) (globSegment, error) {
+	if pattern == "**" {
+		return globSegment{anyDirs: true}, nil
+	}
+	escapes := filepath.Separator != '\\'
+	var re, literal strings.Builder
+	wildcards := false
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '*':
+			re.WriteString(".*")
+			wildcards = true
+		case r == '?':
+			re.WriteString(".")
+			wildcards = true
+		case r == '[':
+			class, n, err := compileGlobClass(runes[i+1:], escapes)
+			if err != nil {
+				return globSegment{}, err
+			}
+			re.WriteString(class)
+			i += n
+			wildcards = true
+		case r == '\\' && escapes:
+			i++
+			if i == len(runes) {
+				return globSegment{}, filepath.ErrBadPattern
+			}
+			fallthrough
+		default:
+			re.WriteString(regexp.QuoteMeta(string(runes[i])))
+			literal.WriteRune(runes[i])
+		}
+	}
+
+	seg := globSegment{literal: literal.String()}
+	seg.dot = len(runes) > 0 && (runes[0] == '.' || runes[0] == '\\') && strings.HasPrefix(seg.literal, ".")
+	if !wildcards && !opts.CaseInsensitive {
+		return seg, nil
+	}
+	flags := "(?s)"
+	if opts.CaseInsensitive {
+		flags = "(?is)"
+	}
+	var err error
+	seg.re, err = regexp.Compile(flags + "^" + re.String() + "$")
+	if err != nil {
+		return globSegment{}, filepath.ErrBadPattern
+	}
+	return seg, nil
+}
+
+// compileGlobClass turns the character class starting after a '[' in
+// pattern into a regular expression, and returns how many runes it took up
+// to the closing ']'.
+func compileGlobClass(pattern []rune, escapes bool) (string, int, error) {
+	var class strings.Builder
+	class.WriteString("[")
+	i := 0
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		class.WriteString("^")
+		i++
+	}
+	start := i
+	for i < len(pattern) && pattern[i] != ']' {
+		lo, n, err := globClassChar(pattern[i:], escapes)
+		if err != nil {
+			return "", 0, err
+		}
+		i += n
+		hi := lo
+		if i < len(pattern) && pattern[i] == '-' {
+			hi, n, err = globClassChar(pattern[i+1:], escapes)
+			if err != nil || hi < lo {
+				return "", 0, filepath.ErrBadPattern
+			}
+			i += n + 1
+		}
+		fmt.Fprintf(&class, `\x{%x}-\x{%x}`, lo, hi)
+	}
+	if i == len(pattern) || i == start {
+		return "", 0, filepath.ErrBadPattern
+	}
+	class.WriteString("]")
+	return class.String(), i + 1, nil
+}
+
+// globClassChar returns the character starting pattern, within a class, and
+// how many runes it takes.
+func globClassChar(pattern []rune, escapes bool) (rune, int, error) {
+	switch {
+	case len(pattern) == 0, pattern[0] == '-', pattern[0] == ']':
+		return 0, 0, filepath.ErrBadPattern
+	case pattern[0] == '\\' && escapes:
+		if len(pattern) == 1 {
+			return 0, 0, filepath.ErrBadPattern
+		}
+		return pattern[1], 2, nil
+	}
+	return pattern[0], 1, nil
+}
+
+// globber holds the state of a single GlobWithOptions.
+type globber struct {
+	fs       Fs
+	opts     *GlobOptions
+	patterns []globPattern
+	exclude  []globPattern
+	// anchored are the relative exclude patterns, matched below base, the
+	// directory searched
+	anchored []globPattern
+	base     string
+	matches  []string
+}
+
+// search looks for matches below dir, which has been matched up to states.
+func (g *globber) search(dir string, states []globState) {
+	literals := true
+	var active []globState
+	for _, st := range closure(g.patterns, states) {
+		if st.s == len(g.patterns[st.p]) {
+			continue
+		}
+		seg := g.patterns[st.p][st.s]
+		literals = literals && !seg.anyDirs && seg.re == nil
+		active = append(active, st)
+	}
+	if len(active) == 0 {
+		return
+	}
+
+	if literals {
+		seen := map[string]bool{}
+		for _, st := range active {
+			name := g.patterns[st.p][st.s].literal
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if info, err := lstatIfPossible(g.fs, filepath.Join(dir, name)); err == nil {
+				g.visit(dir, name, info, active)
+			}
+		}
+		return
+	}
+
+	f, err := g.fs.Open(dir)
+	if err != nil {
+		return
+	}
+	infos, _ := f.Readdir(-1)
+	f.Close()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	for _, info := range infos {
+		g.visit(dir, info.Name(), info, active)
+	}
+}
+
+// visit matches the entry name of dir, described by info, against states,
+// and searches below it if it is a directory which may hold more matches.
+func (g *globber) visit(dir, name string, info os.FileInfo, states []globState) {
+	var next, throughAny []globState
+	for _, st := range states {
+		seg := g.patterns[st.p][st.s]
+		switch {
+		case !seg.match(name, g.opts):
+		case seg.anyDirs:
+			throughAny = append(throughAny, st)
+		default:
+			next = append(next, globState{st.p, st.s + 1})
+		}
+	}
+	if len(next) == 0 && len(throughAny) == 0 {
+		return
+	}
+	path := filepath.Join(dir, name)
+	if g.excluded(path) {
+		return
+	}
+
+	for _, st := range closure(g.patterns, append(next, throughAny...)) {
+		if st.s == len(g.patterns[st.p]) {
+			g.matches = append(g.matches, path)
+			break
+		}
+	}
+
+	isDir := info.IsDir()
+	if info.Mode()&os.ModeSymlink != 0 {
+		if fi, err := g.fs.Stat(path); err == nil && fi.IsDir() {
+			isDir = true
+			// "**" doesn't go through links
+			throughAny = nil
+		}
+	}
+	if isDir {
+		g.search(path, append(next, throughAny...))
+	}
+}
+
+// excluded reports whether path matches any of the patterns excluded.
+func (g *globber) excluded(path string) bool {
+	if len(g.exclude) == 0 && len(g.anchored) == 0 {
+		return false
+	}
+	opts := *g.opts
+	opts.Hidden = true
+	if globMatch(g.exclude, strings.Split(globSlash(path), "/"), &opts) {
+		return true
+	}
+	if len(g.anchored) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(g.base, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return globMatch(g.anchored, strings.Split(globSlash(rel), "/"), &opts)
+}
+
+// globMatch reports whether the path made of names matches any of patterns.
+func globMatch(patterns []globPattern, names []string, opts *GlobOptions) bool {
+	states := make([]globState, len(patterns))
+	for i := range patterns {
+		states[i] = globState{i, 0}
+	}
+	for _, name := range names {
+		var next []globState
+		for _, st := range closure(patterns, states) {
+			if st.s == len(patterns[st.p]) {
+				continue
+			}
+			seg := patterns[st.p][st.s]
+			switch {
+			case !seg.match(name, opts):
+			case seg.anyDirs:
+				next = append(next, st)
+			default:
+				next = append(next, globState{st.p, st.s + 1})
+			}
+		}
+		states = next
+	}
+	for _, st := range closure(patterns, states) {
+		if st.s == len(patterns[st.p]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package afero
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readdirCountFs counts the directories read.
+type readdirCountFs struct {
+	*MemMapFs
+	opened []string
+}
+
+func (fs *readdirCountFs) Open(name string) (File, error) {
+	f, err := fs.MemMapFs.Open(name)
+	if err == nil {
+		if info, err := f.Stat(); err == nil && info.IsDir() {
+			fs.opened = append(fs.opened, name)
+		}
+	}
+	return f, err
+}
+
+func globFixture(t *testing.T) *MemMapFs {
+	t.Helper()
+	fs := &MemMapFs{}
+	for _, name := range []string{
+		"/assets/site.css",
+		"/assets/app.js",
+		"/assets/app.js.map",
+		"/assets/vendor/lib/x.js",
+		"/assets/themes/dark/Theme.CSS",
+		"/assets/.cache/old.js",
+		"/assets/.hidden.css",
+		"/docs/readme.md",
+		"/docs/a1.md",
+		"/docs/b2.md",
+	} {
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func TestGlobWithOptions(t *testing.T) {
+	fs := globFixture(t)
+	for _, test := range []struct {
+		pattern  string
+		opts     *GlobOptions
+		expected []string
+	}{
+		{"/assets/**/*.{css,js}", nil, []string{"/assets/app.js", "/assets/site.css", "/assets/vendor/lib/x.js"}},
+		{"/assets/**/*.{css,js}", &GlobOptions{CaseInsensitive: true}, []string{"/assets/app.js", "/assets/site.css", "/assets/themes/dark/Theme.CSS", "/assets/vendor/lib/x.js"}},
+		{"/assets/**/*.{css,js}", &GlobOptions{Hidden: true}, []string{"/assets/.cache/old.js", "/assets/.hidden.css", "/assets/app.js", "/assets/site.css", "/assets/vendor/lib/x.js"}},
+		{"/assets/**/.*", nil, []string{"/assets/.cache", "/assets/.hidden.css"}},
+		{"/assets/**/*.js", &GlobOptions{Exclude: []string{"**/vendor"}}, []string{"/assets/app.js"}},
+		{"/assets/*", &GlobOptions{Exclude: []string{"*.{js,map}"}}, []string{"/assets/site.css", "/assets/themes", "/assets/vendor"}},
+		{"/assets/**/*.js", &GlobOptions{Hidden: true, Exclude: []string{".*"}}, []string{"/assets/app.js", "/assets/vendor/lib/x.js"}},
+		{"/assets/**/*.js", &GlobOptions{Exclude: []string{"vendor/**"}}, []string{"/assets/app.js"}},
+		{"/assets/**/*.js", &GlobOptions{Exclude: []string{"vendor/lib/x.js"}}, []string{"/assets/app.js"}},
+		{"/assets/**", &GlobOptions{Exclude: []string{"vendor/lib", "themes/*"}}, []string{"/assets/app.js", "/assets/app.js.map", "/assets/site.css", "/assets/themes", "/assets/vendor"}},
+		{"/assets/**/*.{js,map}", &GlobOptions{Exclude: []string{"app.js.map", "lib/x.js"}}, []string{"/assets/app.js", "/assets/vendor/lib/x.js"}},
+		{"/assets/{vendor/lib,themes}/*", nil, []string{"/assets/themes/dark", "/assets/vendor/lib/x.js"}},
+		{"/assets/**", nil, []string{"/assets/app.js", "/assets/app.js.map", "/assets/site.css", "/assets/themes", "/assets/themes/dark", "/assets/themes/dark/Theme.CSS", "/assets/vendor", "/assets/vendor/lib", "/assets/vendor/lib/x.js"}},
+		{"/docs/[!r]?.md", nil, []string{"/docs/a1.md", "/docs/b2.md"}},
+		{"/docs/[^a-a]*.md", nil, []string{"/docs/b2.md", "/docs/readme.md"}},
+		{"/docs/{readme,a1}.md", nil, []string{"/docs/a1.md", "/docs/readme.md"}},
+		{"/DOCS/README.MD", &GlobOptions{CaseInsensitive: true}, []string{"/docs/readme.md"}},
+		{"/docs/missing.md", nil, nil},
+	} {
+		var expected []string
+		for _, name := range test.expected {
+			expected = append(expected, filepath.FromSlash(name))
+		}
+		matches, err := GlobWithOptions(fs, test.pattern, test.opts)
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+		} else if !reflect.DeepEqual(matches, expected) {
+			t.Errorf("%s %+v: got %v, expected %v", test.pattern, test.opts, matches, expected)
+		}
+	}
+}
+
+func TestGlobWithOptionsPruning(t *testing.T) {
+	fs := &readdirCountFs{MemMapFs: globFixture(t)}
+	matches, err := GlobWithOptions(fs, "/assets/vendor/*/x.{js,css}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("got %v", matches)
+	}
+	expected := []string{filepath.FromSlash("/assets/vendor")}
+	if !reflect.DeepEqual(fs.opened, expected) {
+		t.Errorf("read %v, expected only %v", fs.opened, expected)
+	}
+
+	fs.opened = nil
+	GlobWithOptions(fs, "/assets/**/*.js", &GlobOptions{Exclude: []string{"/assets/themes"}})
+	for _, dir := range fs.opened {
+		if dir == filepath.FromSlash("/assets/themes") || dir == filepath.FromSlash("/docs") {
+			t.Errorf("read %s which can't hold a match", dir)
+		}
+	}
+}
+
+func TestGlobWithOptionsErrors(t *testing.T) {
+	fs := globFixture(t)
+	for _, pattern := range []string{"[", "[]", "[z-a]", "/a/{b,c", "/a/[!"} {
+		if _, err := GlobWithOptions(fs, pattern, nil); err != filepath.ErrBadPattern {
+			t.Errorf("%s: got %v, expected ErrBadPattern", pattern, err)
+		}
+	}
+	if _, err := GlobWithOptions(fs, "*", &GlobOptions{Exclude: []string{"["}}); err != filepath.ErrBadPattern {
+		t.Errorf("exclude: got %v, expected ErrBadPattern", err)
+	}
+}
+
+func TestGlobWithOptionsSymlinks(t *testing.T) {
+	fs := globFixture(t)
+	fs.SymlinkIfPossible("/assets", "/assets/vendor/loop")
+	matches, err := GlobWithOptions(fs, "/assets/**/x.js", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.FromSlash("/assets/vendor/lib/x.js")}; !reflect.DeepEqual(matches, expected) {
+		t.Errorf("got %v, expected %v", matches, expected)
+	}
+	matches, _ = GlobWithOptions(fs, "/assets/vendor/loop/*.css", nil)
+	if expected := []string{filepath.FromSlash("/assets/vendor/loop/site.css")}; !reflect.DeepEqual(matches, expected) {
+		t.Errorf("through a link: got %v, expected %v", matches, expected)
+	}
+}
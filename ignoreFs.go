@@ -0,0 +1,466 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	_ Lstater    = (*IgnoreFs)(nil)
+	_ Symlinker  = (*IgnoreFs)(nil)
+	_ LinkReader = (*IgnoreFs)(nil)
+)
+
+// The IgnoreFs hides the paths of its source matched by rules in the
+// gitignore format: later rules override earlier ones, "!" negates a rule,
+// a pattern with a slash other than a trailing one is anchored to the
+// directory of its rules and matches at any depth otherwise, and a trailing
+// slash restricts it to directories. As with git, nothing below an ignored
+// directory can be included again.
+//
+// Ignored paths don't exist for Open, Stat and Readdir, and changing them
+// fails with EPERM, as does removing or renaming a directory holding any.
+// A symbolic link is hidden along with the path it points to.
+type IgnoreFs struct {
+	source     Fs
+	rules      []ignoreRule
+	ignoreFile string
+
+	mu sync.Mutex
+	// dirRules caches the rules of the ignore file of every directory
+	// looked at, nil if it has none
+	dirRules map[string][]ignoreRule
+}
+
+// NewIgnoreFs returns an IgnoreFs hiding the paths of source matched by
+// rules, given as the lines of a gitignore file at the root. If ignoreFile
+// is set, the files of that name, such as ".gitignore", add the rules they
+// hold for the directory they are in, after the rules given. They are read
+// once, and again after they are changed through the IgnoreFs.
+func NewIgnoreFs(source Fs, rules []string, ignoreFile string) *IgnoreFs {
+	return &IgnoreFs{
+		source:     source,
+		rules:      parseIgnoreRules(rules),
+		ignoreFile: ignoreFile,
+		dirRules:   make(map[string][]ignoreRule),
+	}
+}
+
+// ignoreRule is a line of a gitignore file.
+type ignoreRule struct {
+	pattern globPattern
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreGlobOptions let wildcards match hidden files, as they do in git.
+var ignoreGlobOptions = &GlobOptions{Hidden: true}
+
+// parseIgnoreRules parses the lines of a gitignore file, leaving out the
+// malformed ones as git does.
+func parseIgnoreRules(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var rule ignoreRule
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		var names []string
+		if !strings.Contains(line, "/") {
+			names = append(names, "**")
+		}
+		for _, name := range strings.Split(line, "/") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if names[len(names)-1] == "**" {
+			// what is inside, not the directory itself
+			names = append(names, "*")
+		}
+		pattern, err := compileGlobSegments(names, ignoreGlobOptions)
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// rulesOf returns the rules of the ignore file in dir.
+func (f *IgnoreFs) rulesOf(dir string) []ignoreRule {
+	if f.ignoreFile == "" {
+		return nil
+	}
+	f.mu.Lock()
+	rules, ok := f.dirRules[dir]
+	f.mu.Unlock()
+	if ok {
+		return rules
+	}
+	if data, err := ReadFile(f.source, filepath.Join(dir, f.ignoreFile)); err == nil {
+		rules = parseIgnoreRules(strings.Split(string(data), "\n"))
+	}
+	f.mu.Lock()
+	f.dirRules[dir] = rules
+	f.mu.Unlock()
+	return rules
+}
+
+// changed forgets the ignore files read if the change of name may affect
+// them.
+func (f *IgnoreFs) changed(name string, tree bool) {
+	if f.ignoreFile != "" && (tree || filepath.Base(name) == f.ignoreFile) {
+		f.mu.Lock()
+		f.dirRules = make(map[string][]ignoreRule)
+		f.mu.Unlock()
+	}
+}
+
+// ignored reports whether name, described by info if it isn't nil, is
+// hidden by the rules.
+func (f *IgnoreFs) ignored(name string, info os.FileInfo) bool {
+	name = filepath.Clean(filepath.Join(FilePathSeparator, name))
+	if name == FilePathSeparator {
+		return false
+	}
+	names := strings.Split(filepath.ToSlash(name), "/")[1:]
+	for i := range names {
+		last := i == len(names)-1
+		isDir := func() bool {
+			if !last {
+				return true
+			}
+			if info == nil {
+				info, _ = lstatIfPossible(f.source, name)
+			}
+			return info != nil && info.IsDir()
+		}
+		if f.matches(names[:i+1], isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rules which apply to the path made of names
+// ignore it. The rules of deeper directories come after those above them.
+func (f *IgnoreFs) matches(names []string, isDir func() bool) bool {
+	ignored := applyIgnoreRules(f.rules, names, isDir, false)
+	dir := FilePathSeparator
+	for i := range names {
+		ignored = applyIgnoreRules(f.rulesOf(dir), names[i:], isDir, ignored)
+		dir = filepath.Join(dir, names[i])
+	}
+	return ignored
+}
+
+// applyIgnoreRules returns whether the path made of names, relative to the
+// directory of rules, is ignored once the rules are applied, given whether
+// it was before.
+func applyIgnoreRules(rules []ignoreRule, names []string, isDir func() bool, ignored bool) bool {
+	for _, rule := range rules {
+		if rule.negate != ignored {
+			// wouldn't change anything
+			continue
+		}
+		if globMatch([]globPattern{rule.pattern}, names, ignoreGlobOptions) && (!rule.dirOnly || isDir()) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// resolve returns the path name leads to, with the symbolic links on any
+// of its elements replaced by their targets, and whether it is hidden: if it
+// is ignored or goes through an ignored path. A path going through too many
+// links is taken as hidden.
+func (f *IgnoreFs) resolve(name string) (string, bool) {
+	reader, canReadlink := f.source.(LinkReader)
+	names := ignoreNames(name)
+	resolved := FilePathSeparator
+	for hops := 0; len(names) > 0; {
+		next := filepath.Join(resolved, names[0])
+		names = names[1:]
+		if f.ignored(next, nil) {
+			return "", true
+		}
+		if !canReadlink {
+			resolved = next
+			continue
+		}
+		target, err := reader.ReadlinkIfPossible(next)
+		if err != nil {
+			resolved = next
+			continue
+		}
+		if hops++; hops > 40 {
+			return "", true
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(resolved, target)
+		}
+		names = append(ignoreNames(target), names...)
+		resolved = FilePathSeparator
+	}
+	return resolved, false
+}
+
+// hidden reports whether name is ignored, or leads to an ignored path
+// through symbolic links on any of its elements.
+func (f *IgnoreFs) hidden(name string) bool {
+	_, hidden := f.resolve(name)
+	return hidden
+}
+
+// hideEntry reports whether the entry path of a directory listing, under
+// the resolved path of the directory, is hidden.
+func (f *IgnoreFs) hideEntry(path string, info os.FileInfo) bool {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return f.hidden(path)
+	}
+	return f.ignored(path, info)
+}
+
+// ignoreNames splits name into its elements, from the root.
+func ignoreNames(name string) []string {
+	name = filepath.Clean(filepath.Join(FilePathSeparator, name))
+	if name == FilePathSeparator {
+		return nil
+	}
+	return strings.Split(name[1:], FilePathSeparator)
+}
+
+// checkRead fails with ENOENT if name is hidden.
+func (f *IgnoreFs) checkRead(op, name string) error {
+	if f.hidden(name) {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+	}
+	return nil
+}
+
+// checkWrite fails with EPERM if name is hidden.
+func (f *IgnoreFs) checkWrite(op, name string) error {
+	if f.hidden(name) {
+		return &os.PathError{Op: op, Path: name, Err: syscall.EPERM}
+	}
+	return nil
+}
+
+// checkTree fails with EPERM if name is hidden or anything below it is.
+func (f *IgnoreFs) checkTree(op, name string) error {
+	if err := f.checkWrite(op, name); err != nil {
+		return err
+	}
+	err := Walk(f.source, name, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.ignored(path, info) {
+			return &os.PathError{Op: op, Path: name, Err: syscall.EPERM}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *IgnoreFs) Name() string {
+	return "IgnoreFs"
+}
+
+func (f *IgnoreFs) Create(name string) (File, error) {
+	if err := f.checkWrite("create", name); err != nil {
+		return nil, err
+	}
+	f.changed(name, false)
+	return f.source.Create(name)
+}
+
+func (f *IgnoreFs) Mkdir(name string, perm os.FileMode) error {
+	if err := f.checkWrite("mkdir", name); err != nil {
+		return err
+	}
+	return f.source.Mkdir(name, perm)
+}
+
+func (f *IgnoreFs) MkdirAll(path string, perm os.FileMode) error {
+	if err := f.checkWrite("mkdir", path); err != nil {
+		return err
+	}
+	return f.source.MkdirAll(path, perm)
+}
+
+func (f *IgnoreFs) Open(name string) (File, error) {
+	resolved, hidden := f.resolve(name)
+	if hidden {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	}
+	file, err := f.source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &filterFile{File: file, name: resolved, hide: f.hideEntry}, nil
+}
+
+func (f *IgnoreFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return f.Open(name)
+	}
+	resolved, hidden := f.resolve(name)
+	if hidden {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	f.changed(name, false)
+	file, err := f.source.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &filterFile{File: file, name: resolved, hide: f.hideEntry}, nil
+}
+
+func (f *IgnoreFs) Remove(name string) error {
+	if err := f.checkWrite("remove", name); err != nil {
+		return err
+	}
+	f.changed(name, false)
+	return f.source.Remove(name)
+}
+
+func (f *IgnoreFs) RemoveAll(path string) error {
+	if err := f.checkTree("removeall", path); err != nil {
+		return err
+	}
+	f.changed(path, true)
+	return f.source.RemoveAll(path)
+}
+
+func (f *IgnoreFs) Rename(oldname, newname string) error {
+	if err := f.checkTree("rename", oldname); err != nil {
+		return err
+	}
+	if err := f.checkWrite("rename", newname); err != nil {
+		return err
+	}
+	f.changed(oldname, true)
+	return f.source.Rename(oldname, newname)
+}
+
+func (f *IgnoreFs) Stat(name string) (os.FileInfo, error) {
+	if err := f.checkRead("stat", name); err != nil {
+		return nil, err
+	}
+	return f.source.Stat(name)
+}
+
+func (f *IgnoreFs) Chmod(name string, mode os.FileMode) error {
+	if err := f.checkWrite("chmod", name); err != nil {
+		return err
+	}
+	return f.source.Chmod(name, mode)
+}
+
+func (f *IgnoreFs) Chown(name string, uid, gid int) error {
+	if err := f.checkWrite("chown", name); err != nil {
+		return err
+	}
+	return f.source.Chown(name, uid, gid)
+}
+
+func (f *IgnoreFs) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.checkWrite("chtimes", name); err != nil {
+		return err
+	}
+	return f.source.Chtimes(name, atime, mtime)
+}
+
+func (f *IgnoreFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if err := f.checkRead("lstat", name); err != nil {
+		return nil, false, err
+	}
+	if lsf, ok := f.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := f.Stat(name)
+	return fi, false, err
+}
+
+func (f *IgnoreFs) SymlinkIfPossible(oldname, newname string) error {
+	if f.hidden(newname) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	if linker, ok := f.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (f *IgnoreFs) ReadlinkIfPossible(name string) (string, error) {
+	if err := f.checkRead("readlink", name); err != nil {
+		return "", err
+	}
+	if reader, ok := f.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// filterFile leaves the entries for which hide is true out of the listing
+// of the directory name, with the entries joined to name.
+type filterFile struct {
+	File
+	name string
+	hide func(name string, info os.FileInfo) bool
+}
+
+// Readdir reads further entries of the source directory if some of those
+// it got were left out, to return c entries unless at the end.
+func (f *filterFile) Readdir(c int) ([]os.FileInfo, error) {
+	var fis []os.FileInfo
+	for {
+		infos, err := f.File.Readdir(c - len(fis))
+		for _, info := range infos {
+			if !f.hide(filepath.Join(f.name, info.Name()), info) {
+				fis = append(fis, info)
+			}
+		}
+		if err != nil || c <= 0 || len(fis) == c || len(infos) == 0 {
+			if err == io.EOF && len(fis) > 0 {
+				err = nil
+			}
+			return fis, err
+		}
+	}
+}
+
+func (f *filterFile) Readdirnames(c int) ([]string, error) {
+	fis, err := f.Readdir(c)
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
@@ -0,0 +1,185 @@
+package afero
+
+import (
+	"os"
+	"sort"
+	"testing"
+)
+
+func ignoreFixture(t *testing.T) *MemMapFs {
+	t.Helper()
+	fs := &MemMapFs{}
+	for name, content := range map[string]string{
+		"/.gitignore":           "# build output\nbuild/\n*.log\n!keep.log\n/secret\n",
+		"/main.go":              "main",
+		"/debug.log":            "debug",
+		"/keep.log":             "keep",
+		"/secret":               "root secret",
+		"/build/out":            "out",
+		"/src/build":            "a file named build",
+		"/src/secret":           "not anchored here",
+		"/src/.gitignore":       "*.tmp\n!important.tmp\ndeep/**\n",
+		"/src/x.tmp":            "x",
+		"/src/important.tmp":    "important",
+		"/src/deep/inner/file":  "deep",
+		"/docs/.env":            "TOKEN=1",
+		"/docs/build/page.html": "page",
+	} {
+		if err := WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func TestIgnoreFs(t *testing.T) {
+	fs := NewIgnoreFs(ignoreFixture(t), []string{".env", "!/docs/build/"}, ".gitignore")
+	for name, visible := range map[string]bool{
+		"/main.go":              true,
+		"/debug.log":            false,
+		"/keep.log":             true,
+		"/secret":               false,
+		"/build":                false,
+		"/build/out":            false,
+		"/src/build":            true,
+		"/src/secret":           true,
+		"/src/x.tmp":            false,
+		"/src/important.tmp":    true,
+		"/src/deep":             true,
+		"/src/deep/inner":       false,
+		"/src/deep/inner/file":  false,
+		"/docs/.env":            false,
+		// the ignore files come after the rules given
+		"/docs/build/page.html": false,
+	} {
+		_, err := fs.Stat(name)
+		if visible && err != nil {
+			t.Errorf("%s: expected to be visible, got %v", name, err)
+		}
+		if !visible && !os.IsNotExist(err) {
+			t.Errorf("%s: expected not to exist, got %v", name, err)
+		}
+		if _, err := fs.Open(name); visible != (err == nil) {
+			t.Errorf("%s: open got %v", name, err)
+		}
+	}
+
+	names, err := readDirNames(fs, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{".gitignore", "docs", "keep.log", "main.go", "src"}
+	if !equalNames(names, expected) {
+		t.Errorf("got %v, expected %v", names, expected)
+	}
+	f, _ := fs.Open("/src")
+	names, _ = f.Readdirnames(2)
+	more, _ := f.Readdirnames(10)
+	f.Close()
+	expected = []string{".gitignore", "build", "deep", "important.tmp", "secret"}
+	if len(names) != 2 || !equalNames(append(names, more...), expected) {
+		t.Errorf("got %v then %v, expected %v", names, more, expected)
+	}
+}
+
+func equalNames(names, expected []string) bool {
+	sort.Strings(names)
+	if len(names) != len(expected) {
+		return false
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIgnoreFsWrites(t *testing.T) {
+	source := ignoreFixture(t)
+	fs := NewIgnoreFs(source, nil, ".gitignore")
+	if _, err := fs.Create("/new.log"); !os.IsPermission(err) {
+		t.Errorf("create: got %v, expected EPERM", err)
+	}
+	if err := WriteFile(fs, "/keep.log", []byte("kept"), 0644); err != nil {
+		t.Errorf("write: %v", err)
+	}
+	if err := fs.Remove("/debug.log"); !os.IsPermission(err) {
+		t.Errorf("remove: got %v, expected EPERM", err)
+	}
+	if err := fs.Rename("/main.go", "/main.log"); !os.IsPermission(err) {
+		t.Errorf("rename: got %v, expected EPERM", err)
+	}
+	if err := fs.RemoveAll("/src"); !os.IsPermission(err) {
+		t.Errorf("removeall: got %v, expected EPERM for the hidden files", err)
+	}
+	checkFile(t, source, "/src/x.tmp", "x")
+	if err := fs.RemoveAll("/docs/build"); !os.IsPermission(err) {
+		t.Errorf("removeall: got %v, expected EPERM for a hidden directory", err)
+	}
+	if err := fs.Chmod("/secret", 0600); !os.IsPermission(err) {
+		t.Errorf("chmod: got %v, expected EPERM", err)
+	}
+
+	// changing an ignore file through the IgnoreFs applies it
+	if err := WriteFile(fs, "/.gitignore", []byte("*.go\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/debug.log"); err != nil {
+		t.Errorf("debug.log: got %v after the rules changed", err)
+	}
+	if _, err := fs.Stat("/main.go"); !os.IsNotExist(err) {
+		t.Errorf("main.go: got %v after the rules changed", err)
+	}
+}
+
+func TestIgnoreFsSymlinks(t *testing.T) {
+	source := ignoreFixture(t)
+	source.SymlinkIfPossible("/secret", "/src/link")
+	source.SymlinkIfPossible("link", "/src/chain")
+	source.SymlinkIfPossible("main.go", "/shortcut")
+	source.SymlinkIfPossible("/build", "/buildlink")
+	fs := NewIgnoreFs(source, nil, ".gitignore")
+	for _, name := range []string{"/src/link", "/src/chain", "/buildlink", "/buildlink/out"} {
+		if _, err := ReadFile(fs, name); !os.IsNotExist(err) {
+			t.Errorf("%s: got %v, expected the link to be hidden", name, err)
+		}
+	}
+	checkFile(t, fs, "/shortcut", "main")
+	if err := fs.SymlinkIfPossible("/main.go", "/link.log"); !os.IsPermission(err) {
+		t.Errorf("symlink: got %v, expected EPERM", err)
+	}
+	if err := fs.SymlinkIfPossible("/main.go", "/buildlink/new"); !os.IsPermission(err) {
+		t.Errorf("symlink through a link: got %v, expected EPERM", err)
+	}
+
+	// the links on the way are resolved whether the last one is followed
+	// or not
+	if _, _, err := fs.LstatIfPossible("/buildlink/out"); !os.IsNotExist(err) {
+		t.Errorf("lstat: got %v, expected the path to be hidden", err)
+	}
+	source.SymlinkIfPossible("/build/out", "/build/outlink")
+	if _, err := fs.ReadlinkIfPossible("/buildlink/outlink"); !os.IsNotExist(err) {
+		t.Errorf("readlink: got %v, expected the path to be hidden", err)
+	}
+
+	// the entries of a linked directory are matched where they are
+	source.SymlinkIfPossible("/src", "/ls")
+	anchored := NewIgnoreFs(source, []string{"/src/secret"}, ".gitignore")
+	names, err := readDirNames(anchored, "/ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if name == "secret" || name == "link" {
+			t.Errorf("%s listed through a linked directory", name)
+		}
+	}
+
+	// a link loop fails closed
+	source.SymlinkIfPossible("/loop2", "/loop1")
+	source.SymlinkIfPossible("/loop1", "/loop2")
+	if _, _, err := fs.LstatIfPossible("/loop1"); !os.IsNotExist(err) {
+		t.Errorf("lstat of a link loop: got %v, expected it to be hidden", err)
+	}
+}
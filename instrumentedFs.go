@@ -0,0 +1,547 @@
+package afero
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	_ FsContext = (*InstrumentedFs)(nil)
+	_ Lstater   = (*InstrumentedFs)(nil)
+	_ Symlinker = (*InstrumentedFs)(nil)
+)
+
+// MetricsExporter receives the measurements of an InstrumentedFs, to keep
+// them or pass them on to a metrics system. Its methods may be called from
+// several goroutines at the same time.
+type MetricsExporter interface {
+	// ObserveOperation records an operation op of backend which took d and
+	// failed with err, if not nil.
+	ObserveOperation(backend, op string, d time.Duration, err error)
+	// AddBytes records n bytes read, if read is set, or written through
+	// the files of backend.
+	AddBytes(backend string, read bool, n int64)
+}
+
+// Tracer starts a span for every operation of an InstrumentedFs. ctx is the
+// context given to the FsContext methods, and for operations on files the
+// one they were opened with.
+type Tracer interface {
+	StartSpan(ctx context.Context, op, path string) Span
+}
+
+// Span is an operation traced by a Tracer.
+type Span interface {
+	// End is called once the operation is over, with its error.
+	End(err error)
+}
+
+// InstrumentOptions tune an InstrumentedFs.
+type InstrumentOptions struct {
+	// Backend labels the measurements, the name of the source Fs if empty.
+	Backend string
+	// Metrics receives the measurements, if set.
+	Metrics MetricsExporter
+	// Tracer traces the operations, if set.
+	Tracer Tracer
+}
+
+// The InstrumentedFs measures the operations made on its source and on the
+// files it opens: how long they take, whether they fail, and how many
+// bytes go through the files. Operations are named as in an AuditRecord.
+// io.EOF isn't counted as an error.
+type InstrumentedFs struct {
+	source  Fs
+	ctxFs   FsContext
+	backend string
+	metrics MetricsExporter
+	tracer  Tracer
+}
+
+// NewInstrumentedFs returns an InstrumentedFs measuring the operations on
+// source. A nil opts stands for the zero InstrumentOptions.
+func NewInstrumentedFs(source Fs, opts *InstrumentOptions) *InstrumentedFs {
+	if opts == nil {
+		opts = &InstrumentOptions{}
+	}
+	backend := opts.Backend
+	if backend == "" {
+		backend = source.Name()
+	}
+	return &InstrumentedFs{
+		source:  source,
+		ctxFs:   ToContextFs(source),
+		backend: backend,
+		metrics: opts.Metrics,
+		tracer:  opts.Tracer,
+	}
+}
+
+// observe starts measuring the operation op on path, and returns the
+// function to call with its error once it is over.
+func (i *InstrumentedFs) observe(ctx context.Context, op, path string) func(error) {
+	start := time.Now()
+	var span Span
+	if i.tracer != nil {
+		span = i.tracer.StartSpan(ctx, op, path)
+	}
+	return func(err error) {
+		if err == io.EOF {
+			err = nil
+		}
+		if i.metrics != nil {
+			i.metrics.ObserveOperation(i.backend, op, time.Since(start), err)
+		}
+		if span != nil {
+			span.End(err)
+		}
+	}
+}
+
+func (i *InstrumentedFs) addBytes(read bool, n int) {
+	if i.metrics != nil && n > 0 {
+		i.metrics.AddBytes(i.backend, read, int64(n))
+	}
+}
+
+// wrap returns f, opened with ctx and err, as a file measuring its
+// operations.
+func (i *InstrumentedFs) wrap(ctx context.Context, f File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedFile{File: f, fs: i, ctx: ctx}, nil
+}
+
+func (i *InstrumentedFs) Name() string {
+	return "InstrumentedFs"
+}
+
+func (i *InstrumentedFs) Create(name string) (File, error) {
+	return i.CreateContext(context.Background(), name)
+}
+
+func (i *InstrumentedFs) Mkdir(name string, perm os.FileMode) error {
+	return i.MkdirContext(context.Background(), name, perm)
+}
+
+func (i *InstrumentedFs) MkdirAll(path string, perm os.FileMode) error {
+	return i.MkdirAllContext(context.Background(), path, perm)
+}
+
+func (i *InstrumentedFs) Open(name string) (File, error) {
+	return i.OpenContext(context.Background(), name)
+}
+
+func (i *InstrumentedFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return i.OpenFileContext(context.Background(), name, flag, perm)
+}
+
+func (i *InstrumentedFs) Remove(name string) error {
+	return i.RemoveContext(context.Background(), name)
+}
+
+func (i *InstrumentedFs) RemoveAll(path string) error {
+	return i.RemoveAllContext(context.Background(), path)
+}
+
+func (i *InstrumentedFs) Rename(oldname, newname string) error {
+	return i.RenameContext(context.Background(), oldname, newname)
+}
+
+func (i *InstrumentedFs) Stat(name string) (os.FileInfo, error) {
+	return i.StatContext(context.Background(), name)
+}
+
+func (i *InstrumentedFs) Chmod(name string, mode os.FileMode) error {
+	return i.ChmodContext(context.Background(), name, mode)
+}
+
+func (i *InstrumentedFs) Chown(name string, uid, gid int) error {
+	return i.ChownContext(context.Background(), name, uid, gid)
+}
+
+func (i *InstrumentedFs) Chtimes(name string, atime, mtime time.Time) error {
+	return i.ChtimesContext(context.Background(), name, atime, mtime)
+}
+
+func (i *InstrumentedFs) CreateContext(ctx context.Context, name string) (File, error) {
+	done := i.observe(ctx, "create", name)
+	f, err := i.ctxFs.CreateContext(ctx, name)
+	done(err)
+	return i.wrap(ctx, f, err)
+}
+
+func (i *InstrumentedFs) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	done := i.observe(ctx, "mkdir", name)
+	err := i.ctxFs.MkdirContext(ctx, name, perm)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	done := i.observe(ctx, "mkdirall", path)
+	err := i.ctxFs.MkdirAllContext(ctx, path, perm)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) OpenContext(ctx context.Context, name string) (File, error) {
+	done := i.observe(ctx, "open", name)
+	f, err := i.ctxFs.OpenContext(ctx, name)
+	done(err)
+	return i.wrap(ctx, f, err)
+}
+
+func (i *InstrumentedFs) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	done := i.observe(ctx, "openfile", name)
+	f, err := i.ctxFs.OpenFileContext(ctx, name, flag, perm)
+	done(err)
+	return i.wrap(ctx, f, err)
+}
+
+func (i *InstrumentedFs) RemoveContext(ctx context.Context, name string) error {
+	done := i.observe(ctx, "remove", name)
+	err := i.ctxFs.RemoveContext(ctx, name)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) RemoveAllContext(ctx context.Context, path string) error {
+	done := i.observe(ctx, "removeall", path)
+	err := i.ctxFs.RemoveAllContext(ctx, path)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) RenameContext(ctx context.Context, oldname, newname string) error {
+	done := i.observe(ctx, "rename", oldname)
+	err := i.ctxFs.RenameContext(ctx, oldname, newname)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	done := i.observe(ctx, "stat", name)
+	fi, err := i.ctxFs.StatContext(ctx, name)
+	done(err)
+	return fi, err
+}
+
+func (i *InstrumentedFs) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	done := i.observe(ctx, "chmod", name)
+	err := i.ctxFs.ChmodContext(ctx, name, mode)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	done := i.observe(ctx, "chown", name)
+	err := i.ctxFs.ChownContext(ctx, name, uid, gid)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) ChtimesContext(ctx context.Context, name string, atime, mtime time.Time) error {
+	done := i.observe(ctx, "chtimes", name)
+	err := i.ctxFs.ChtimesContext(ctx, name, atime, mtime)
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	done := i.observe(context.Background(), "lstat", name)
+	var fi os.FileInfo
+	var ok bool
+	var err error
+	if lsf, isLstater := i.source.(Lstater); isLstater {
+		fi, ok, err = lsf.LstatIfPossible(name)
+	} else {
+		fi, err = i.source.Stat(name)
+	}
+	done(err)
+	return fi, ok, err
+}
+
+func (i *InstrumentedFs) SymlinkIfPossible(oldname, newname string) error {
+	done := i.observe(context.Background(), "symlink", newname)
+	var err error
+	if linker, ok := i.source.(Linker); ok {
+		err = linker.SymlinkIfPossible(oldname, newname)
+	} else {
+		err = &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	done(err)
+	return err
+}
+
+func (i *InstrumentedFs) ReadlinkIfPossible(name string) (string, error) {
+	done := i.observe(context.Background(), "readlink", name)
+	target, err := readlinkIfPossible(i.source, name)
+	done(err)
+	return target, err
+}
+
+// instrumentedFile measures the operations on a file opened through an
+// InstrumentedFs with ctx.
+type instrumentedFile struct {
+	File
+	fs  *InstrumentedFs
+	ctx context.Context
+}
+
+func (f *instrumentedFile) observe(op string) func(error) {
+	return f.fs.observe(f.ctx, op, f.Name())
+}
+
+func (f *instrumentedFile) Close() error {
+	done := f.observe("close")
+	err := f.File.Close()
+	done(err)
+	return err
+}
+
+func (f *instrumentedFile) Read(p []byte) (int, error) {
+	done := f.observe("read")
+	n, err := f.File.Read(p)
+	done(err)
+	f.fs.addBytes(true, n)
+	return n, err
+}
+
+func (f *instrumentedFile) ReadAt(p []byte, off int64) (int, error) {
+	done := f.observe("readat")
+	n, err := f.File.ReadAt(p, off)
+	done(err)
+	f.fs.addBytes(true, n)
+	return n, err
+}
+
+func (f *instrumentedFile) Seek(offset int64, whence int) (int64, error) {
+	done := f.observe("seek")
+	pos, err := f.File.Seek(offset, whence)
+	done(err)
+	return pos, err
+}
+
+func (f *instrumentedFile) Write(p []byte) (int, error) {
+	done := f.observe("write")
+	n, err := f.File.Write(p)
+	done(err)
+	f.fs.addBytes(false, n)
+	return n, err
+}
+
+func (f *instrumentedFile) WriteAt(p []byte, off int64) (int, error) {
+	done := f.observe("writeat")
+	n, err := f.File.WriteAt(p, off)
+	done(err)
+	f.fs.addBytes(false, n)
+	return n, err
+}
+
+func (f *instrumentedFile) WriteString(s string) (int, error) {
+	done := f.observe("writestring")
+	n, err := f.File.WriteString(s)
+	done(err)
+	f.fs.addBytes(false, n)
+	return n, err
+}
+
+func (f *instrumentedFile) Readdir(count int) ([]os.FileInfo, error) {
+	done := f.observe("readdir")
+	fis, err := f.File.Readdir(count)
+	done(err)
+	return fis, err
+}
+
+func (f *instrumentedFile) Readdirnames(n int) ([]string, error) {
+	done := f.observe("readdirnames")
+	names, err := f.File.Readdirnames(n)
+	done(err)
+	return names, err
+}
+
+func (f *instrumentedFile) Stat() (os.FileInfo, error) {
+	done := f.observe("fstat")
+	fi, err := f.File.Stat()
+	done(err)
+	return fi, err
+}
+
+func (f *instrumentedFile) Sync() error {
+	done := f.observe("sync")
+	err := f.File.Sync()
+	done(err)
+	return err
+}
+
+func (f *instrumentedFile) Truncate(size int64) error {
+	done := f.observe("truncate")
+	err := f.File.Truncate(size)
+	done(err)
+	return err
+}
+
+// errnoNames are the names ErrnoName gives to common errors.
+var errnoNames = []struct {
+	err  error
+	name string
+}{
+	{syscall.ENOENT, "ENOENT"},
+	{syscall.EEXIST, "EEXIST"},
+	{syscall.EACCES, "EACCES"},
+	{syscall.EPERM, "EPERM"},
+	{syscall.ENOTDIR, "ENOTDIR"},
+	{syscall.EISDIR, "EISDIR"},
+	{syscall.ENOTEMPTY, "ENOTEMPTY"},
+	{syscall.EXDEV, "EXDEV"},
+	{syscall.EINVAL, "EINVAL"},
+	{syscall.EBADF, "EBADF"},
+	{syscall.EIO, "EIO"},
+	{syscall.ENOSPC, "ENOSPC"},
+	{syscall.EROFS, "EROFS"},
+	{os.ErrNotExist, "ENOENT"},
+	{os.ErrExist, "EEXIST"},
+	{os.ErrPermission, "EPERM"},
+	{os.ErrClosed, "EBADF"},
+	{context.Canceled, "canceled"},
+	{context.DeadlineExceeded, "deadline"},
+}
+
+// ErrnoName returns a short name for the kind of err, suitable as a metric
+// label: the name of its errno, such as "ENOENT", if it is a common one,
+// "errno" followed by the number for other errnos, "canceled" or
+// "deadline" for context errors, and "other" otherwise. It returns "" for
+// a nil err.
+func ErrnoName(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, e := range errnoNames {
+		if errors.Is(err, e.err) {
+			return e.name
+		}
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return fmt.Sprintf("errno%d", int(errno))
+	}
+	return "other"
+}
+
+// LatencyBuckets are the upper bounds of the latency buckets of an
+// OpStats.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// OpKey identifies an operation of a backend.
+type OpKey struct {
+	Backend string
+	Op      string
+}
+
+// OpStats are the measurements of an operation kept by MemoryMetrics.
+type OpStats struct {
+	Count int64
+	// Errors counts the failures by ErrnoName.
+	Errors map[string]int64
+	// Latency counts the operations by duration: Latency[i] those which
+	// took up to LatencyBuckets[i] and longer than the bucket before, the
+	// last one those which took longer than all the buckets.
+	Latency []int64
+	Total   time.Duration
+}
+
+// MemoryMetrics is a MetricsExporter keeping the measurements in memory,
+// for tests or to be scraped.
+type MemoryMetrics struct {
+	mu    sync.Mutex
+	ops   map[OpKey]*OpStats
+	bytes map[string]*[2]int64
+}
+
+var _ MetricsExporter = (*MemoryMetrics)(nil)
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{ops: make(map[OpKey]*OpStats), bytes: make(map[string]*[2]int64)}
+}
+
+func (m *MemoryMetrics) ObserveOperation(backend, op string, d time.Duration, err error) {
+	key := OpKey{backend, op}
+	bucket := len(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.ops[key]
+	if !ok {
+		stats = &OpStats{Errors: make(map[string]int64), Latency: make([]int64, len(LatencyBuckets)+1)}
+		m.ops[key] = stats
+	}
+	stats.Count++
+	stats.Latency[bucket]++
+	stats.Total += d
+	if err != nil {
+		stats.Errors[ErrnoName(err)]++
+	}
+}
+
+func (m *MemoryMetrics) AddBytes(backend string, read bool, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts, ok := m.bytes[backend]
+	if !ok {
+		counts = new([2]int64)
+		m.bytes[backend] = counts
+	}
+	if read {
+		counts[0] += n
+	} else {
+		counts[1] += n
+	}
+}
+
+// Ops returns a copy of the measurements of every operation seen.
+func (m *MemoryMetrics) Ops() map[OpKey]OpStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ops := make(map[OpKey]OpStats, len(m.ops))
+	for key, stats := range m.ops {
+		copied := *stats
+		copied.Errors = make(map[string]int64, len(stats.Errors))
+		for name, n := range stats.Errors {
+			copied.Errors[name] = n
+		}
+		copied.Latency = append([]int64(nil), stats.Latency...)
+		ops[key] = copied
+	}
+	return ops
+}
+
+// Bytes returns the number of bytes read and written through the files of
+// backend.
+func (m *MemoryMetrics) Bytes(backend string) (read, written int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if counts, ok := m.bytes[backend]; ok {
+		return counts[0], counts[1]
+	}
+	return 0, 0
+}
@@ -0,0 +1,170 @@
+package afero
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type spanLog struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type spanLogKey struct{}
+
+type spanLogEntry struct {
+	log  *spanLog
+	name string
+}
+
+func (l *spanLog) StartSpan(ctx context.Context, op, path string) Span {
+	name := op + " " + path
+	if v, ok := ctx.Value(spanLogKey{}).(string); ok {
+		name += " " + v
+	}
+	return &spanLogEntry{l, name}
+}
+
+func (s *spanLogEntry) End(err error) {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+	s.log.spans = append(s.log.spans, fmt.Sprintf("%s: %s", s.name, ErrnoName(err)))
+}
+
+func TestInstrumentedFs(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	fs := NewInstrumentedFs(NewMemMapFs(), &InstrumentOptions{Metrics: metrics})
+
+	if err := WriteFile(fs, "/a", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadFile(fs, "/a")
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("ReadFile: %q, %v", data, err)
+	}
+	if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := fs.Mkdir("/a", 0755); !os.IsExist(err) {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	ops := metrics.Ops()
+	backend := "MemMapFS"
+	for op, count := range map[string]int64{"openfile": 1, "open": 1, "write": 1, "close": 2, "stat": 1, "mkdir": 1} {
+		if got := ops[OpKey{backend, op}].Count; got != count {
+			t.Errorf("%s count = %d, want %d", op, got, count)
+		}
+	}
+	if got := ops[OpKey{backend, "stat"}].Errors["ENOENT"]; got != 1 {
+		t.Errorf("stat ENOENT errors = %d, want 1", got)
+	}
+	if got := ops[OpKey{backend, "mkdir"}].Errors["EEXIST"]; got != 1 {
+		t.Errorf("mkdir EEXIST errors = %d, want 1", got)
+	}
+	if errs := ops[OpKey{backend, "read"}].Errors; len(errs) != 0 {
+		t.Errorf("read errors = %v, want none: EOF isn't an error", errs)
+	}
+	var latencies int64
+	for _, n := range ops[OpKey{backend, "close"}].Latency {
+		latencies += n
+	}
+	if latencies != 2 {
+		t.Errorf("close latencies = %d, want 2", latencies)
+	}
+	if read, written := metrics.Bytes(backend); read != 5 || written != 5 {
+		t.Errorf("Bytes = %d, %d, want 5, 5", read, written)
+	}
+}
+
+func TestInstrumentedFsTracer(t *testing.T) {
+	spans := &spanLog{}
+	fs := NewInstrumentedFs(NewMemMapFs(), &InstrumentOptions{Backend: "mem", Tracer: spans})
+
+	ctx := context.WithValue(context.Background(), spanLogKey{}, "traced")
+	f, err := fs.CreateContext(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("data"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := fs.Open("/missing"); err == nil {
+		t.Fatal("Open of a missing file succeeded")
+	}
+
+	want := []string{
+		"create /a traced: ",
+		"writestring /a traced: ",
+		"close /a traced: ",
+		"open /missing: ENOENT",
+	}
+	if !reflect.DeepEqual(spans.spans, want) {
+		t.Errorf("spans = %q, want %q", spans.spans, want)
+	}
+}
+
+func TestInstrumentedFsContext(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	fs := NewInstrumentedFs(NewMemMapFs(), &InstrumentOptions{Backend: "mem", Metrics: metrics})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fs.MkdirContext(ctx, "/dir", 0755); !errors.Is(err, context.Canceled) {
+		t.Fatalf("MkdirContext: %v", err)
+	}
+	if got := metrics.Ops()[OpKey{"mem", "mkdir"}].Errors["canceled"]; got != 1 {
+		t.Errorf("mkdir canceled errors = %d, want 1", got)
+	}
+}
+
+func TestErrnoName(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{&os.PathError{Op: "open", Path: "/a", Err: syscall.ENOENT}, "ENOENT"},
+		{os.ErrNotExist, "ENOENT"},
+		{&os.LinkError{Op: "rename", Old: "/a", New: "/b", Err: syscall.EXDEV}, "EXDEV"},
+		{fmt.Errorf("wrapped: %w", syscall.EACCES), "EACCES"},
+		{context.DeadlineExceeded, "deadline"},
+		{syscall.Errno(200), "errno200"},
+		{errors.New("boom"), "other"},
+	} {
+		if got := ErrnoName(test.err); got != test.want {
+			t.Errorf("ErrnoName(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestMemoryMetricsLatency(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	metrics.ObserveOperation("b", "op", 50*time.Microsecond, nil)
+	metrics.ObserveOperation("b", "op", 5*time.Millisecond, nil)
+	metrics.ObserveOperation("b", "op", time.Minute, nil)
+
+	stats := metrics.Ops()[OpKey{"b", "op"}]
+	want := []int64{1, 0, 1, 0, 0, 0, 1}
+	for i := range want {
+		if stats.Latency[i] != want[i] {
+			t.Fatalf("Latency = %v, want %v", stats.Latency, want)
+		}
+	}
+	if stats.Total != time.Minute+5*time.Millisecond+50*time.Microsecond {
+		t.Errorf("Total = %v", stats.Total)
+	}
+
+	stats.Latency[0] = 100
+	if metrics.Ops()[OpKey{"b", "op"}].Latency[0] != 1 {
+		t.Error("Ops doesn't return a copy")
+	}
+}
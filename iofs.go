@@ -7,6 +7,8 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -158,7 +160,7 @@ func (f FromIOFS) MkdirAll(path string, perm os.FileMode) error {
 }
 
 func (f FromIOFS) Open(name string) (File, error) {
-	file, err := f.FS.Open(name)
+	file, err := f.FS.Open(ioPath(name))
 	if err != nil {
 		return nil, err
 	}
@@ -167,6 +169,9 @@ func (f FromIOFS) Open(name string) (File, error) {
 }
 
 func (f FromIOFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, notImplemented("open", name)
+	}
 	return f.Open(name)
 }
 
@@ -182,7 +187,7 @@ func (f FromIOFS) Rename(oldname, newname string) error {
 	return notImplemented("rename", oldname)
 }
 
-func (f FromIOFS) Stat(name string) (os.FileInfo, error) { return fs.Stat(f.FS, name) }
+func (f FromIOFS) Stat(name string) (os.FileInfo, error) { return fs.Stat(f.FS, ioPath(name)) }
 
 func (f FromIOFS) Name() string { return "fromiofs" }
 
@@ -283,6 +288,16 @@ func (f fromIOFSFile) WriteString(s string) (ret int, err error) {
 	return -1, notImplemented("writestring", f.name)
 }
 
+// ioPath turns a name of afero, which may be rooted, into the unrooted form
+// io/fs expects.
+func ioPath(name string) string {
+	name = strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
 func notImplemented(op, path string) error {
 	return &fs.PathError{Op: op, Path: path, Err: fs.ErrPermission}
 }
@@ -49,9 +49,13 @@ func TestLstatIfPossible(t *testing.T) {
 	roFsMem := &ReadOnlyFs{source: memFs}
 
 	pathFileMem := filepath.Join(memWorkDir, "aferom.txt")
+	pathSymlinkMem := filepath.Join(memWorkDir, "symaferom.txt")
 
 	WriteFile(osFs, filepath.Join(workDir, "afero.txt"), []byte("Hi, Afero!"), 0777)
 	WriteFile(memFs, filepath.Join(pathFileMem), []byte("Hi, Afero!"), 0777)
+	if err := memFs.(Linker).SymlinkIfPossible("aferom.txt", pathSymlinkMem); err != nil {
+		t.Fatal(err)
+	}
 
 	os.Chdir(workDir)
 	if err := os.Symlink("afero.txt", "symafero.txt"); err != nil {
@@ -95,8 +99,8 @@ func TestLstatIfPossible(t *testing.T) {
 	testLstat(overlayFs1, pathFile, pathSymlink)
 	testLstat(overlayFs2, pathFile, pathSymlink)
 	testLstat(basePathFs, "afero.txt", "symafero.txt")
-	testLstat(overlayFsMemOnly, pathFileMem, "")
-	testLstat(basePathFsMem, "aferom.txt", "")
+	testLstat(overlayFsMemOnly, pathFileMem, pathSymlinkMem)
+	testLstat(basePathFsMem, "aferom.txt", "symaferom.txt")
 	testLstat(roFs, pathFile, pathSymlink)
-	testLstat(roFsMem, pathFileMem, "")
+	testLstat(roFsMem, pathFileMem, pathSymlinkMem)
 }
@@ -13,6 +13,8 @@
 
 package mem
 
+import "path/filepath"
+
 type Dir interface {
 	Len() int
 	Names() []string
@@ -29,6 +31,30 @@ func AddToMemDir(dir *FileData, f *FileData) {
 	dir.memDir.Add(f)
 }
 
+// FindInMemDir returns the entry of dir called name, or nil if there is none.
+func FindInMemDir(dir *FileData, name string) *FileData {
+	if dir.memDir == nil {
+		return nil
+	}
+	if m, ok := dir.memDir.(*DirMap); ok {
+		return (*m)[name]
+	}
+	for _, f := range dir.memDir.Files() {
+		if filepath.Base(f.name) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// MemDirFiles returns the entries of dir sorted by name.
+func MemDirFiles(dir *FileData) []*FileData {
+	if dir.memDir == nil {
+		return nil
+	}
+	return dir.memDir.Files()
+}
+
 func InitializeDir(d *FileData) {
 	if d.memDir == nil {
 		d.dir = true
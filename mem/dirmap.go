@@ -13,13 +13,17 @@
 
 package mem
 
-import "sort"
+import (
+	"path/filepath"
+	"sort"
+)
 
+// DirMap holds the entries of a directory keyed by their base name.
 type DirMap map[string]*FileData
 
 func (m DirMap) Len() int           { return len(m) }
-func (m DirMap) Add(f *FileData)    { m[f.name] = f }
-func (m DirMap) Remove(f *FileData) { delete(m, f.name) }
+func (m DirMap) Add(f *FileData)    { m[filepath.Base(f.name)] = f }
+func (m DirMap) Remove(f *FileData) { delete(m, filepath.Base(f.name)) }
 func (m DirMap) Files() (files []*FileData) {
 	for _, f := range m {
 		files = append(files, f)
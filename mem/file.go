@@ -34,6 +34,9 @@ type File struct {
 	closed       bool
 	readOnly     bool
 	fileData     *FileData
+	// written is set by changes to the content, until onWrite is called
+	written bool
+	onWrite func()
 }
 
 func NewFileHandle(data *FileData) *File {
@@ -48,6 +51,22 @@ func (f File) Data() *FileData {
 	return f.fileData
 }
 
+// OnWrite makes Sync and Close call fn if the content has been changed
+// through the handle since the previous call.
+func (f *File) OnWrite(fn func()) {
+	f.onWrite = fn
+}
+
+func (f *File) notifyWrite() {
+	f.fileData.Lock()
+	written := f.written
+	f.written = false
+	f.fileData.Unlock()
+	if written && f.onWrite != nil {
+		f.onWrite()
+	}
+}
+
 type FileData struct {
 	sync.Mutex
 	name    string
@@ -58,6 +77,9 @@ type FileData struct {
 	modtime time.Time
 	uid     int
 	gid     int
+	// shared is set when data is used by a clone as well, and must be
+	// copied before it is changed.
+	shared bool
 }
 
 func (d *FileData) Name() string {
@@ -74,6 +96,66 @@ func CreateDir(name string) *FileData {
 	return &FileData{name: name, memDir: &DirMap{}, dir: true, modtime: time.Now()}
 }
 
+// CreateSymlink creates a symbolic link pointing to target. Like on most
+// POSIX filesystems the target is stored as the content of the link, so its
+// size is the length of the target.
+func CreateSymlink(name string, target string) *FileData {
+	return &FileData{name: name, data: []byte(target), mode: os.ModeSymlink | os.ModePerm, modtime: time.Now()}
+}
+
+// IsSymlink reports whether f is a symbolic link.
+func IsSymlink(f *FileData) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.mode&os.ModeSymlink != 0
+}
+
+// ReadSymlink returns the target of the symbolic link f.
+func ReadSymlink(f *FileData) string {
+	f.Lock()
+	defer f.Unlock()
+	return string(f.data)
+}
+
+// Clone returns a copy of f and, for a directory, of everything below it.
+// The content of files is shared by f and its copy until either of them is
+// written to.
+func Clone(f *FileData) *FileData {
+	f.Lock()
+	f.shared = true
+	c := &FileData{
+		name:    f.name,
+		data:    f.data,
+		dir:     f.dir,
+		mode:    f.mode,
+		modtime: f.modtime,
+		uid:     f.uid,
+		gid:     f.gid,
+		shared:  true,
+	}
+	var children []*FileData
+	if f.memDir != nil {
+		children = f.memDir.Files()
+		c.memDir = &DirMap{}
+	}
+	f.Unlock()
+
+	for _, child := range children {
+		c.memDir.Add(Clone(child))
+	}
+	return c
+}
+
+// unshare gives f its own copy of data if it is shared with a clone.
+// The caller must hold the lock of f.
+func (f *FileData) unshare() {
+	if !f.shared {
+		return
+	}
+	f.data = append([]byte(nil), f.data...)
+	f.shared = false
+}
+
 func ChangeFileName(f *FileData, newname string) {
 	f.Lock()
 	f.name = newname
@@ -108,6 +190,13 @@ func SetGID(f *FileData, gid int) {
 	f.Unlock()
 }
 
+// GetOwner returns the uid and gid of f.
+func GetOwner(f *FileData) (uid, gid int) {
+	f.Lock()
+	defer f.Unlock()
+	return f.uid, f.gid
+}
+
 func GetFileInfo(f *FileData) *FileInfo {
 	return &FileInfo{f}
 }
@@ -128,6 +217,7 @@ func (f *File) Close() error {
 		setModTime(f.fileData, time.Now())
 	}
 	f.fileData.Unlock()
+	f.notifyWrite()
 	return nil
 }
 
@@ -140,6 +230,7 @@ func (f *File) Stat() (os.FileInfo, error) {
 }
 
 func (f *File) Sync() error {
+	f.notifyWrite()
 	return nil
 }
 
@@ -210,6 +301,9 @@ func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 	atomic.StoreInt64(&f.at, off)
 	n, err = f.Read(b)
 	atomic.StoreInt64(&f.at, prev)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
 	return
 }
 
@@ -225,6 +319,8 @@ func (f *File) Truncate(size int64) error {
 	}
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
+	f.fileData.unshare()
+	f.written = true
 	if size > int64(len(f.fileData.data)) {
 		diff := size - int64(len(f.fileData.data))
 		f.fileData.data = append(f.fileData.data, bytes.Repeat([]byte{00}, int(diff))...)
@@ -239,6 +335,10 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	if f.closed == true {
 		return 0, ErrFileClosed
 	}
+	if f.fileData.dir && offset == 0 && whence == io.SeekStart {
+		// the directory is listed again from its start, as with an os.File
+		atomic.StoreInt64(&f.readDirCount, 0)
+	}
 	switch whence {
 	case io.SeekStart:
 		atomic.StoreInt64(&f.at, offset)
@@ -261,6 +361,8 @@ func (f *File) Write(b []byte) (n int, err error) {
 	cur := atomic.LoadInt64(&f.at)
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
+	f.fileData.unshare()
+	f.written = true
 	diff := cur - int64(len(f.fileData.data))
 	var tail []byte
 	if n+int(cur) < len(f.fileData.data) {
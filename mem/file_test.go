@@ -296,3 +296,35 @@ func TestFileWriteAndSeek(t *testing.T) {
 		assert(cur == off, cur, off)
 	}
 }
+
+func TestCloneSharesUntilWrite(t *testing.T) {
+	t.Parallel()
+
+	orig := CreateFile("/file")
+	f := NewFileHandle(orig)
+	f.Write([]byte("abcdef"))
+	f.Truncate(3)
+
+	c := Clone(orig)
+	f.Seek(3, io.SeekStart)
+	f.Write([]byte("XYZ"))
+
+	cf := NewFileHandle(c)
+	cf.Seek(0, io.SeekEnd)
+	cf.Write([]byte("123"))
+
+	if got := string(orig.data); got != "abcXYZ" {
+		t.Errorf("original: got %q", got)
+	}
+	if got := string(c.data); got != "abc123" {
+		t.Errorf("clone: got %q", got)
+	}
+
+	dir := CreateDir("/dir")
+	AddToMemDir(dir, CreateFile("/dir/a"))
+	cdir := Clone(dir)
+	AddToMemDir(dir, CreateFile("/dir/b"))
+	if n := len(MemDirFiles(cdir)); n != 1 {
+		t.Errorf("cloned directory should have 1 entry, got %d", n)
+	}
+}
@@ -15,11 +15,11 @@ package afero
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/afero/mem"
@@ -27,107 +27,208 @@ import (
 
 const chmodBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky // Only a subset of bits are allowed to be changed. Documented under os.Chmod()
 
+var (
+	_ Symlinker = (*MemMapFs)(nil)
+	_ Watcher   = (*MemMapFs)(nil)
+)
+
+// MemMapFs keeps the filesystem as a tree of mem.FileData. Every directory
+// guards its own entries with its lock, so unrelated subtrees can be changed
+// concurrently. mu is only taken exclusively by Rename, which moves nodes
+// from one directory to another.
 type MemMapFs struct {
-	mu   sync.RWMutex
-	data map[string]*mem.FileData
-	init sync.Once
+	mu      sync.RWMutex
+	root    *mem.FileData
+	init    sync.Once
+	watches watches
 }
 
 func NewMemMapFs() Fs {
 	return &MemMapFs{}
 }
 
-func (m *MemMapFs) getData() map[string]*mem.FileData {
+func (m *MemMapFs) getRoot() *mem.FileData {
 	m.init.Do(func() {
-		m.data = make(map[string]*mem.FileData)
 		// Root should always exist, right?
 		// TODO: what about windows?
-		root := mem.CreateDir(FilePathSeparator)
-		mem.SetMode(root, os.ModeDir|0755)
-		m.data[FilePathSeparator] = root
+		m.root = mem.CreateDir(FilePathSeparator)
+		mem.SetMode(m.root, os.ModeDir|0755)
 	})
-	return m.data
+	return m.root
 }
 
 func (*MemMapFs) Name() string { return "MemMapFS" }
 
-func (m *MemMapFs) Create(name string) (File, error) {
-	name = normalizePath(name)
+// Snapshot returns an independent copy of the filesystem, with the same
+// directory structure, contents, modes, times and owners. The contents of
+// files are shared by both until either side writes to them, so a snapshot
+// costs little more than walking the tree.
+func (m *MemMapFs) Snapshot() *MemMapFs {
 	m.mu.Lock()
-	file := mem.CreateFile(name)
-	m.getData()[name] = file
-	m.registerWithParent(file, 0)
-	m.mu.Unlock()
-	return mem.NewFileHandle(file), nil
+	defer m.mu.Unlock()
+
+	s := &MemMapFs{root: mem.Clone(m.getRoot())}
+	s.init.Do(func() {})
+	return s
 }
 
-func (m *MemMapFs) unRegisterWithParent(fileName string) error {
-	f, err := m.lockfreeOpen(fileName)
+// Restore rolls the filesystem back to the state of snapshot. The snapshot
+// itself is left untouched, so it can be restored any number of times.
+// Files opened before keep referring to their previous content. Watchers are
+// not notified of the changes.
+func (m *MemMapFs) Restore(snapshot *MemMapFs) {
+	root := snapshot.Snapshot().root
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.getRoot()
+	m.root = root
+}
+
+func (m *MemMapFs) Create(name string) (File, error) {
+	file, err := m.create(name)
 	if err != nil {
-		return err
-	}
-	parent := m.findParent(f)
-	if parent == nil {
-		log.Panic("parent of ", f.Name(), " is nil")
+		return nil, err
 	}
-
-	parent.Lock()
-	mem.RemoveFromMemDir(parent, f)
-	parent.Unlock()
-	return nil
+	return m.newFileHandle(file), nil
 }
 
-func (m *MemMapFs) findParent(f *mem.FileData) *mem.FileData {
-	pdir, _ := filepath.Split(f.Name())
-	pdir = filepath.Clean(pdir)
-	pfile, err := m.lockfreeOpen(pdir)
+func (m *MemMapFs) create(name string) (*mem.FileData, error) {
+	name = normalizePath(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dir, f, path, err := m.walk(name, true, true, 0)
+	if err == nil && (dir == nil || f != nil && mem.GetFileInfo(f).IsDir()) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
 	if err != nil {
-		return nil
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
 	}
-	return pfile
+	file := mem.CreateFile(path)
+	dir.Lock()
+	mem.AddToMemDir(dir, file)
+	dir.Unlock()
+	m.watches.notify(path, Create)
+	return file, nil
 }
 
-func (m *MemMapFs) registerWithParent(f *mem.FileData, perm os.FileMode) {
-	if f == nil {
-		return
+// newFileHandle returns a writable handle of f, which reports the writes
+// through it to the watchers when it is synced or closed.
+func (m *MemMapFs) newFileHandle(f *mem.FileData) *mem.File {
+	file := mem.NewFileHandle(f)
+	file.OnWrite(func() {
+		m.watches.notify(f.Name(), Write)
+	})
+	return file
+}
+
+// walk resolves name in the tree, following symbolic links on the way. The
+// last element of name is only followed if followLast is set. It returns the
+// directory holding the last element, the element itself or nil if it does
+// not exist, and its path. Missing directories on the way are created with
+// perm if mkdirs is set.
+// The caller must hold m.mu.
+func (m *MemMapFs) walk(name string, followLast, mkdirs bool, perm os.FileMode) (dir, f *mem.FileData, path string, err error) {
+	type step struct {
+		f    *mem.FileData
+		path string
 	}
-	parent := m.findParent(f)
-	if parent == nil {
-		pdir := filepath.Dir(filepath.Clean(f.Name()))
-		err := m.lockfreeMkdir(pdir, perm)
-		if err != nil {
-			//log.Println("Mkdir error:", err)
-			return
+	root := step{f: m.getRoot(), path: FilePathSeparator}
+	if !strings.HasPrefix(name, FilePathSeparator) {
+		// relative paths start from the root, but keep their form
+		root.path = ""
+	}
+	stack := []step{root}
+	parts := strings.Split(name, FilePathSeparator)
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
 		}
-		parent, err = m.lockfreeOpen(pdir)
-		if err != nil {
-			//log.Println("Open after Mkdir error:", err)
-			return
+
+		cur := stack[len(stack)-1]
+		last := len(parts) == 0
+		path := filepath.Join(cur.path, part)
+
+		cur.f.Lock()
+		child := mem.FindInMemDir(cur.f, part)
+		created := false
+		if child == nil && !last && mkdirs {
+			child = mem.CreateDir(path)
+			mem.SetMode(child, os.ModeDir|perm)
+			mem.AddToMemDir(cur.f, child)
+			created = true
+		}
+		cur.f.Unlock()
+		if created {
+			m.watches.notify(path, Create)
+		}
+
+		if child == nil {
+			if last {
+				return cur.f, nil, path, nil
+			}
+			return nil, nil, "", ErrFileNotFound
 		}
+		if mem.IsSymlink(child) && (!last || followLast) {
+			links++
+			if links > maxSymlinks {
+				return nil, nil, "", syscall.ELOOP
+			}
+			target := filepath.Clean(mem.ReadSymlink(child))
+			if filepath.IsAbs(target) {
+				stack = []step{{f: m.getRoot(), path: FilePathSeparator}}
+			}
+			parts = append(strings.Split(target, FilePathSeparator), parts...)
+			continue
+		}
+		if !last && !mem.GetFileInfo(child).IsDir() {
+			if mkdirs {
+				return nil, nil, "", syscall.ENOTDIR
+			}
+			return nil, nil, "", ErrFileNotFound
+		}
+		stack = append(stack, step{f: child, path: path})
 	}
 
-	parent.Lock()
-	mem.InitializeDir(parent)
-	mem.AddToMemDir(parent, f)
-	parent.Unlock()
+	top := stack[len(stack)-1]
+	if len(stack) == 1 {
+		return nil, top.f, FilePathSeparator, nil
+	}
+	return stack[len(stack)-2].f, top.f, top.path, nil
 }
 
-func (m *MemMapFs) lockfreeMkdir(name string, perm os.FileMode) error {
-	name = normalizePath(name)
-	x, ok := m.getData()[name]
-	if ok {
-		// Only return ErrFileExists if it's a file, not a directory.
-		i := mem.FileInfo{FileData: x}
-		if !i.IsDir() {
-			return ErrFileExists
-		}
-	} else {
-		item := mem.CreateDir(name)
-		mem.SetMode(item, os.ModeDir|perm)
-		m.getData()[name] = item
-		m.registerWithParent(item, perm)
+// addChild adds f to dir under name, unless dir has such an entry already.
+func addChild(dir, f *mem.FileData, name string) bool {
+	dir.Lock()
+	defer dir.Unlock()
+	if mem.FindInMemDir(dir, filepath.Base(name)) != nil {
+		return false
 	}
-	return nil
+	mem.AddToMemDir(dir, f)
+	return true
+}
+
+// removeChild takes f out of dir, unless it has been replaced in the
+// meantime.
+func removeChild(dir, f *mem.FileData, name string) bool {
+	dir.Lock()
+	defer dir.Unlock()
+	if mem.FindInMemDir(dir, filepath.Base(name)) != f {
+		return false
+	}
+	mem.RemoveFromMemDir(dir, f)
+	return true
 }
 
 func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
@@ -135,20 +236,22 @@ func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
 	name = normalizePath(name)
 
 	m.mu.RLock()
-	_, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if ok {
+	defer m.mu.RUnlock()
+
+	dir, f, path, err := m.walk(name, false, true, perm)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if f != nil {
 		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
 	}
-
-	m.mu.Lock()
-	item := mem.CreateDir(name)
+	item := mem.CreateDir(path)
 	mem.SetMode(item, os.ModeDir|perm)
-	m.getData()[name] = item
-	m.registerWithParent(item, perm)
-	m.mu.Unlock()
-
-	return m.setFileMode(name, perm|os.ModeDir)
+	if !addChild(dir, item, path) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
+	}
+	m.watches.notify(path, Create)
+	return nil
 }
 
 func (m *MemMapFs) MkdirAll(path string, perm os.FileMode) error {
@@ -187,31 +290,30 @@ func (m *MemMapFs) Open(name string) (File, error) {
 func (m *MemMapFs) openWrite(name string) (File, error) {
 	f, err := m.open(name)
 	if f != nil {
-		return mem.NewFileHandle(f), err
+		return m.newFileHandle(f), err
 	}
 	return nil, err
 }
 
 func (m *MemMapFs) open(name string) (*mem.FileData, error) {
+	return m.lookup("open", name, true)
+}
+
+// lookup returns the node for name, following symbolic links on the way. The
+// last element of name is only followed if followLast is set.
+func (m *MemMapFs) lookup(op, name string, followLast bool) (*mem.FileData, error) {
 	name = normalizePath(name)
 
 	m.mu.RLock()
-	f, ok := m.getData()[name]
+	_, f, _, err := m.walk(name, followLast, false, 0)
 	m.mu.RUnlock()
-	if !ok {
-		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileNotFound}
+	if err == nil && f == nil {
+		err = ErrFileNotFound
 	}
-	return f, nil
-}
-
-func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
-	name = normalizePath(name)
-	f, ok := m.getData()[name]
-	if ok {
-		return f, nil
-	} else {
-		return nil, ErrFileNotFound
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
 	}
+	return f, nil
 }
 
 func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
@@ -221,6 +323,9 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 	if err == nil && (flag&os.O_EXCL > 0) {
 		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileExists}
 	}
+	if err == nil && flag&(os.O_WRONLY|os.O_RDWR) != 0 && mem.GetFileInfo(file.(*mem.File).Data()).IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
 	if os.IsNotExist(err) && (flag&os.O_CREATE > 0) {
 		file, err = m.Create(name)
 		chmod = true
@@ -238,7 +343,7 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 			return nil, err
 		}
 	}
-	if flag&os.O_TRUNC > 0 && flag&(os.O_RDWR|os.O_WRONLY) > 0 {
+	if !chmod && flag&os.O_TRUNC > 0 && flag&(os.O_RDWR|os.O_WRONLY) > 0 {
 		err = file.Truncate(0)
 		if err != nil {
 			file.Close()
@@ -254,42 +359,74 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 func (m *MemMapFs) Remove(name string) error {
 	name = normalizePath(name)
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	if _, ok := m.getData()[name]; ok {
-		err := m.unRegisterWithParent(name)
-		if err != nil {
-			return &os.PathError{Op: "remove", Path: name, Err: err}
-		}
-		delete(m.getData(), name)
-	} else {
+	dir, f, path, err := m.walk(name, false, false, 0)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if dir == nil && f != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	if f == nil || !removeChild(dir, f, path) {
 		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
 	}
+	m.watches.notify(path, Remove)
 	return nil
 }
 
+// RemoveAll detaches path from its directory, which drops the whole subtree
+// at once. Removing the root empties it.
 func (m *MemMapFs) RemoveAll(path string) error {
 	path = normalizePath(path)
-	m.mu.Lock()
-	m.unRegisterWithParent(path)
-	m.mu.Unlock()
 
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	for p := range m.getData() {
-		if p == path || strings.HasPrefix(p, path+FilePathSeparator) {
-			m.mu.RUnlock()
-			m.mu.Lock()
-			delete(m.getData(), p)
-			m.mu.Unlock()
-			m.mu.RLock()
+	dir, f, p, err := m.walk(path, false, false, 0)
+	if err == ErrFileNotFound || err == nil && f == nil {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+	if dir == nil {
+		f.Lock()
+		children := mem.MemDirFiles(f)
+		for _, child := range children {
+			mem.RemoveFromMemDir(f, child)
 		}
+		f.Unlock()
+		for _, child := range children {
+			m.notifyRemoved(child)
+		}
+		return nil
+	}
+	if removeChild(dir, f, p) {
+		m.notifyRemoved(f)
 	}
 	return nil
 }
 
+// notifyRemoved reports the removal of f and everything below it, deepest
+// entries first.
+func (m *MemMapFs) notifyRemoved(f *mem.FileData) {
+	if !m.watches.active() {
+		return
+	}
+	f.Lock()
+	children := mem.MemDirFiles(f)
+	f.Unlock()
+	for _, child := range children {
+		m.notifyRemoved(child)
+	}
+	m.watches.notify(f.Name(), Remove)
+}
+
+// Rename follows POSIX semantics: renaming a directory moves its whole
+// subtree, an existing empty directory or file of the same kind is replaced,
+// and everything happens atomically under the lock of the filesystem.
 func (m *MemMapFs) Rename(oldname, newname string) error {
 	oldname = normalizePath(oldname)
 	newname = normalizePath(newname)
@@ -298,28 +435,109 @@ func (m *MemMapFs) Rename(oldname, newname string) error {
 		return nil
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldDir, fileData, oldpath, err := m.walk(oldname, false, false, 0)
+	if err == nil && fileData == nil {
+		err = ErrFileNotFound
+	}
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	isDir := mem.GetFileInfo(fileData).IsDir()
+	if oldDir == nil || isDir && isWithin(oldpath, newname) {
+		// a directory can't become its own descendant
+		return &os.PathError{Op: "rename", Path: oldname, Err: syscall.EINVAL}
+	}
+
+	newDir, target, newpath, err := m.walk(newname, false, false, 0)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	if target == fileData {
+		return nil
+	}
+	if newDir == nil || isDir && isWithin(oldpath, newpath) {
+		return &os.PathError{Op: "rename", Path: oldname, Err: syscall.EINVAL}
+	}
+
+	if target != nil {
+		targetIsDir := mem.GetFileInfo(target).IsDir()
+		switch {
+		case isDir && !targetIsDir:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTDIR}
+		case !isDir && targetIsDir:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.EISDIR}
+		case targetIsDir && len(mem.MemDirFiles(target)) > 0:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTEMPTY}
+		}
+		removeChild(newDir, target, newpath)
+	}
+
+	removeChild(oldDir, fileData, oldpath)
+	renameTree(fileData, newpath)
+	newDir.Lock()
+	mem.AddToMemDir(newDir, fileData)
+	newDir.Unlock()
+	m.watches.notify(oldpath, Rename)
+	m.watches.notify(newpath, Create)
+	return nil
+}
+
+// isWithin reports whether name is dir itself or lies below it.
+func isWithin(dir, name string) bool {
+	dir = filepath.Join(FilePathSeparator, dir)
+	name = filepath.Join(FilePathSeparator, name)
+	return name == dir || strings.HasPrefix(name, strings.TrimSuffix(dir, FilePathSeparator)+FilePathSeparator)
+}
+
+// renameTree names f after name and updates the names of everything below
+// it accordingly.
+func renameTree(f *mem.FileData, name string) {
+	mem.ChangeFileName(f, name)
+	f.Lock()
+	children := mem.MemDirFiles(f)
+	f.Unlock()
+	for _, child := range children {
+		renameTree(child, filepath.Join(name, filepath.Base(child.Name())))
+	}
+}
+
+func (m *MemMapFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	f, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, true, err
+	}
+	return mem.GetFileInfo(f), true, nil
+}
+
+func (m *MemMapFs) SymlinkIfPossible(oldname, newname string) error {
+	newname = normalizePath(newname)
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if _, ok := m.getData()[oldname]; ok {
-		m.mu.RUnlock()
-		m.mu.Lock()
-		m.unRegisterWithParent(oldname)
-		fileData := m.getData()[oldname]
-		delete(m.getData(), oldname)
-		mem.ChangeFileName(fileData, newname)
-		m.getData()[newname] = fileData
-		m.registerWithParent(fileData, 0)
-		m.mu.Unlock()
-		m.mu.RLock()
-	} else {
-		return &os.PathError{Op: "rename", Path: oldname, Err: ErrFileNotFound}
+
+	dir, f, path, err := m.walk(newname, false, true, 0)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if f != nil || !addChild(dir, mem.CreateSymlink(path, oldname), path) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
 	}
+	m.watches.notify(path, Create)
 	return nil
 }
 
-func (m *MemMapFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
-	fileInfo, err := m.Stat(name)
-	return fileInfo, false, err
+func (m *MemMapFs) ReadlinkIfPossible(name string) (string, error) {
+	f, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !mem.IsSymlink(f) {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return mem.ReadSymlink(f), nil
 }
 
 func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
@@ -334,71 +552,83 @@ func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
 func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
 	mode &= chmodBits
 
-	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if !ok {
-		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
+	f, err := m.lookup("chmod", name, true)
+	if err != nil {
+		return err
 	}
 	prevOtherBits := mem.GetFileInfo(f).Mode() & ^chmodBits
 
 	mode = prevOtherBits | mode
-	return m.setFileMode(name, mode)
+	if err := m.setFileMode(name, mode); err != nil {
+		return err
+	}
+	m.watches.notify(normalizePath(name), Chmod)
+	return nil
 }
 
 func (m *MemMapFs) setFileMode(name string, mode os.FileMode) error {
-	name = normalizePath(name)
-
-	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if !ok {
-		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
+	f, err := m.lookup("chmod", name, true)
+	if err != nil {
+		return err
 	}
 
-	m.mu.Lock()
 	mem.SetMode(f, mode)
-	m.mu.Unlock()
 
 	return nil
 }
 
 func (m *MemMapFs) Chown(name string, uid, gid int) error {
-	name = normalizePath(name)
-
-	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if !ok {
-		return &os.PathError{Op: "chown", Path: name, Err: ErrFileNotFound}
+	f, err := m.lookup("chown", name, true)
+	if err != nil {
+		return err
 	}
 
 	mem.SetUID(f, uid)
 	mem.SetGID(f, gid)
+	m.watches.notify(normalizePath(name), Chmod)
 
 	return nil
 }
 
 func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = normalizePath(name)
-
-	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if !ok {
-		return &os.PathError{Op: "chtimes", Path: name, Err: ErrFileNotFound}
+	f, err := m.lookup("chtimes", name, true)
+	if err != nil {
+		return err
 	}
 
-	m.mu.Lock()
 	mem.SetModTime(f, mtime)
-	m.mu.Unlock()
+	m.watches.notify(normalizePath(name), Chmod)
 
 	return nil
 }
 
+// Watch reports the changes to path, which must exist. Writes through a file
+// handle are reported once, when the handle is synced or closed.
+func (m *MemMapFs) Watch(path string, recursive bool) (<-chan Event, error) {
+	if _, err := m.lookup("watch", path, true); err != nil {
+		return nil, err
+	}
+	return m.watches.add(normalizePath(path), recursive), nil
+}
+
+func (m *MemMapFs) Unwatch(events <-chan Event) error {
+	return m.watches.remove(events)
+}
+
 func (m *MemMapFs) List() {
-	for _, x := range m.data {
-		y := mem.FileInfo{FileData: x}
-		fmt.Println(x.Name(), y.Size())
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var list func(f *mem.FileData)
+	list = func(f *mem.FileData) {
+		f.Lock()
+		children := mem.MemDirFiles(f)
+		f.Unlock()
+		for _, x := range children {
+			y := mem.FileInfo{FileData: x}
+			fmt.Println(x.Name(), y.Size())
+			list(x)
+		}
 	}
+	list(m.getRoot())
 }
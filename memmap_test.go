@@ -3,9 +3,12 @@ package afero
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -665,8 +668,7 @@ func TestMemFsOpenFileModeIllegal(t *testing.T) {
 	}
 }
 
-// LstatIfPossible should always return false, since MemMapFs does not
-// support symlinks.
+// LstatIfPossible should always return true, since MemMapFs supports symlinks.
 func TestMemFsLstatIfPossible(t *testing.T) {
 	t.Parallel()
 
@@ -688,7 +690,512 @@ func TestMemFsLstatIfPossible(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Function returned err: %v", err)
 	}
-	if lstatCalled {
-		t.Fatalf("Function indicated lstat was called. This should never be true.")
+	if !lstatCalled {
+		t.Fatalf("Function indicated lstat was not called. This should always be true.")
 	}
 }
+
+func TestMemFsSymlink(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	linker := fs.(Symlinker)
+
+	if err := WriteFile(fs, "/dir/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"/abs.txt":      "/dir/file.txt",
+		"/dir/rel.txt":  "file.txt",
+		"/up.txt":       "dir/../dir/file.txt",
+		"/dirlink":      "dir",
+		"/chain.txt":    "dirlink/rel.txt",
+		"/dangling.txt": "/nowhere/file.txt",
+	}
+	for link, target := range links {
+		if err := linker.SymlinkIfPossible(target, link); err != nil {
+			t.Fatalf("symlink %s -> %s: %v", link, target, err)
+		}
+	}
+
+	for _, name := range []string{"/abs.txt", "/dir/rel.txt", "/up.txt", "/dirlink/file.txt", "/chain.txt"} {
+		b, err := ReadFile(fs, name)
+		if err != nil {
+			t.Errorf("read %s: %v", name, err)
+			continue
+		}
+		if string(b) != "content" {
+			t.Errorf("read %s: got %q", name, b)
+		}
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 || fi.Size() != int64(len("content")) {
+			t.Errorf("stat %s must describe the target, got mode %s size %d", name, fi.Mode(), fi.Size())
+		}
+	}
+
+	for link, target := range links {
+		fi, lstat, err := linker.LstatIfPossible(link)
+		if err != nil || !lstat {
+			t.Fatalf("lstat %s: %v, %v", link, lstat, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("lstat %s: expected a symlink, got mode %s", link, fi.Mode())
+		}
+		got, err := linker.ReadlinkIfPossible(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != target {
+			t.Errorf("readlink %s: got %q, want %q", link, got, target)
+		}
+	}
+
+	if _, err := fs.Stat("/dangling.txt"); !os.IsNotExist(err) {
+		t.Errorf("stat of a dangling link: expected not exist, got %v", err)
+	}
+	if err := linker.SymlinkIfPossible("/dir/file.txt", "/abs.txt"); !os.IsExist(err) {
+		t.Errorf("symlink over an existing file: expected exist, got %v", err)
+	}
+	if _, err := linker.ReadlinkIfPossible("/dir/file.txt"); err == nil {
+		t.Error("readlink of a regular file should fail")
+	}
+
+	// writes go to the target, removes to the link
+	if err := WriteFile(fs, "/dirlink/rel.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ReadFile(fs, "/dir/file.txt"); string(b) != "changed" {
+		t.Errorf("write through a link: got %q", b)
+	}
+	if err := fs.Remove("/abs.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/dir/file.txt"); err != nil {
+		t.Errorf("removing a link must not remove its target: %v", err)
+	}
+	if err := fs.RemoveAll("/dirlink"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/dir/file.txt"); err != nil {
+		t.Errorf("removing a directory link must not remove its target: %v", err)
+	}
+}
+
+func TestMemFsSymlinkLoop(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	linker := fs.(Linker)
+	if err := linker.SymlinkIfPossible("/b", "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := linker.SymlinkIfPossible("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := linker.SymlinkIfPossible("self/x", "/self"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/a", "/b/c", "/self"} {
+		_, err := fs.Stat(name)
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ELOOP {
+			t.Errorf("stat %s: expected ELOOP, got %v", name, err)
+		}
+	}
+	if _, err := fs.Create("/a"); err == nil {
+		t.Error("create through a loop should fail")
+	}
+	if _, _, err := fs.(Lstater).LstatIfPossible("/a"); err != nil {
+		t.Errorf("lstat must not follow the loop: %v", err)
+	}
+}
+
+func TestMemFsSymlinkWalkAndGlob(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	if err := WriteFile(fs, "/src/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.(Linker).SymlinkIfPossible("/src", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	var walked []string
+	err := Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		if path == "/link" && info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("walk should report the link itself, got mode %s", info.Mode())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/", "/link", "/src", "/src/a.txt"}; strings.Join(walked, ",") != strings.Join(want, ",") {
+		t.Errorf("walk: got %v, want %v", walked, want)
+	}
+
+	matches, err := Glob(fs, "/link/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0] != "/link/a.txt" {
+		t.Errorf("glob through a link: got %v", matches)
+	}
+}
+
+func TestMemFsRenameDir(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	for _, name := range []string{"/tmp/out/a.txt", "/tmp/out/sub/b.txt", "/tmp/out/sub/deeper/c.txt"} {
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.MkdirAll("/final", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Rename("/tmp/out", "/final/out"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/tmp/out/a.txt", "/tmp/out/sub", "/tmp/out/sub/deeper/c.txt", "/tmp/out"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s should be gone after the rename, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"/tmp/out/a.txt", "/tmp/out/sub/b.txt", "/tmp/out/sub/deeper/c.txt"} {
+		moved := strings.Replace(name, "/tmp", "/final", 1)
+		b, err := ReadFile(fs, moved)
+		if err != nil {
+			t.Errorf("%s should exist after the rename: %v", moved, err)
+			continue
+		}
+		if string(b) != name {
+			t.Errorf("%s: got content %q", moved, b)
+		}
+	}
+
+	names, err := ReadDir(fs, "/final/out/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0].Name() != "b.txt" || names[1].Name() != "deeper" {
+		t.Errorf("unexpected entries in the moved directory: %v", names)
+	}
+	if names, _ := ReadDir(fs, "/tmp"); len(names) != 0 {
+		t.Errorf("/tmp should be empty, got %v", names)
+	}
+
+	f, err := fs.Open("/final/out/sub/deeper/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if f.Name() != filepath.FromSlash("/final/out/sub/deeper/c.txt") {
+		t.Errorf("moved file has a stale name: %s", f.Name())
+	}
+}
+
+func TestMemFsRenameReplace(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	setup := func() {
+		fs.RemoveAll("/r")
+		for _, name := range []string{"/r/dir/a.txt", "/r/full/b.txt", "/r/file.txt", "/r/other.txt"} {
+			if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := fs.Mkdir("/r/empty", 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		oldname, newname string
+		err              error
+	}{
+		{"/r/dir", "/r/empty", nil},
+		{"/r/dir", "/r/full", syscall.ENOTEMPTY},
+		{"/r/dir", "/r/file.txt", syscall.ENOTDIR},
+		{"/r/file.txt", "/r/empty", syscall.EISDIR},
+		{"/r/file.txt", "/r/other.txt", nil},
+		{"/r/dir", "/r/dir/inside", syscall.EINVAL},
+	} {
+		setup()
+		err := fs.Rename(test.oldname, test.newname)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("rename %s to %s: %v", test.oldname, test.newname, err)
+			}
+			if fi, err := fs.Stat(test.newname); err != nil || fi.IsDir() {
+				continue
+			}
+			if b, err := ReadFile(fs, test.newname); err != nil || string(b) != test.oldname {
+				t.Errorf("rename %s to %s: target not replaced, got %q", test.oldname, test.newname, b)
+			}
+			continue
+		}
+		perr, ok := err.(*os.PathError)
+		if !ok || perr.Err != test.err {
+			t.Errorf("rename %s to %s: expected %v, got %v", test.oldname, test.newname, test.err, err)
+		}
+		if _, err := fs.Stat(test.oldname); err != nil {
+			t.Errorf("a failed rename must leave %s in place: %v", test.oldname, err)
+		}
+	}
+
+	setup()
+	if err := fs.Rename("/r/dir", "/r/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/r/empty/a.txt"); err != nil {
+		t.Errorf("renamed directory content is missing: %v", err)
+	}
+}
+
+func TestMemFsRenameConcurrent(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	const n = 20
+	for i := 0; i < n; i++ {
+		if err := WriteFile(fs, fmt.Sprintf("/src/%d/file.txt", i), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Mkdir("/dst", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			done <- fs.Rename(fmt.Sprintf("/src/%d", i), fmt.Sprintf("/dst/%d", i))
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if names, _ := ReadDir(fs, "/src"); len(names) != 0 {
+		t.Errorf("/src should be empty, got %d entries", len(names))
+	}
+	for i := 0; i < n; i++ {
+		if _, err := fs.Stat(fmt.Sprintf("/dst/%d/file.txt", i)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMemFsConcurrentSubtrees(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	const dirs, files = 8, 50
+
+	done := make(chan error)
+	for i := 0; i < dirs; i++ {
+		go func(i int) {
+			dir := fmt.Sprintf("/tree/%d", i)
+			for j := 0; j < files; j++ {
+				if err := WriteFile(fs, fmt.Sprintf("%s/sub/%d.txt", dir, j), []byte("x"), 0644); err != nil {
+					done <- err
+					return
+				}
+			}
+			if i%2 == 0 {
+				done <- fs.RemoveAll(dir)
+				return
+			}
+			done <- nil
+		}(i)
+	}
+	for i := 0; i < dirs; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < dirs; i++ {
+		names, err := ReadDir(fs, fmt.Sprintf("/tree/%d/sub", i))
+		if i%2 == 0 {
+			if !os.IsNotExist(err) {
+				t.Errorf("/tree/%d should have been removed, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != files {
+			t.Errorf("/tree/%d/sub: expected %d entries, got %d", i, files, len(names))
+		}
+	}
+}
+
+func TestMemFsRemoveAllSubtree(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs()
+	for _, name := range []string{"/a/b/c/d.txt", "/a/b/e.txt", "/a/bb.txt"} {
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := fs.Open("/a/b/c/d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fs.RemoveAll("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/a/b", "/a/b/c", "/a/b/c/d.txt", "/a/b/e.txt"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s should be gone, got %v", name, err)
+		}
+	}
+	if _, err := fs.Stat("/a/bb.txt"); err != nil {
+		t.Errorf("a sibling with a common prefix must survive: %v", err)
+	}
+
+	// open handles keep working on the detached subtree
+	b, err := ioutil.ReadAll(f)
+	if err != nil || string(b) != "/a/b/c/d.txt" {
+		t.Errorf("reading a removed file: %q, %v", b, err)
+	}
+
+	if err := fs.RemoveAll("/"); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := ReadDir(fs, "/"); err != nil || len(names) != 0 {
+		t.Errorf("removing the root should empty it, got %v, %v", names, err)
+	}
+}
+
+func TestMemFsSnapshot(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs().(*MemMapFs)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := WriteFile(fs, "/fixture/dir/a.txt", []byte("original"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/fixture/dir/keep.txt", []byte("keep"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chtimes("/fixture/dir/keep.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chown("/fixture/dir/keep.txt", 1000, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SymlinkIfPossible("dir/a.txt", "/fixture/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := fs.Snapshot()
+
+	// changes to either side stay on that side
+	if err := WriteFile(fs, "/fixture/dir/a.txt", []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/fixture/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := snap.OpenFile("/fixture/link", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(" snapshot")
+	f.Close()
+
+	if b, _ := ReadFile(fs, "/fixture/dir/a.txt"); string(b) != "changed" {
+		t.Errorf("fs: got %q", b)
+	}
+	if b, _ := ReadFile(snap, "/fixture/dir/a.txt"); string(b) != "original snapshot" {
+		t.Errorf("snapshot: got %q", b)
+	}
+	if _, err := snap.Stat("/fixture/new.txt"); !os.IsNotExist(err) {
+		t.Errorf("a file created after the snapshot leaked into it: %v", err)
+	}
+
+	if err := fs.RemoveAll("/fixture"); err != nil {
+		t.Fatal(err)
+	}
+	fs.Restore(snap)
+
+	if b, _ := ReadFile(fs, "/fixture/dir/a.txt"); string(b) != "original snapshot" {
+		t.Errorf("restored: got %q", b)
+	}
+	fi, err := fs.Stat("/fixture/dir/keep.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0640 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("restored file has mode %v and time %v", fi.Mode(), fi.ModTime())
+	}
+	if target, err := fs.ReadlinkIfPossible("/fixture/link"); err != nil || target != "dir/a.txt" {
+		t.Errorf("restored link: %q, %v", target, err)
+	}
+	if _, err := fs.Stat("/fixture/new.txt"); !os.IsNotExist(err) {
+		t.Errorf("restore should drop files created after the snapshot: %v", err)
+	}
+
+	// the snapshot can be restored again after the restored fs changed
+	if err := WriteFile(fs, "/fixture/dir/a.txt", []byte("again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs.Restore(snap)
+	if b, _ := ReadFile(fs, "/fixture/dir/a.txt"); string(b) != "original snapshot" {
+		t.Errorf("second restore: got %q", b)
+	}
+}
+
+func TestMemFsSnapshotConcurrent(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemMapFs().(*MemMapFs)
+	if err := WriteFile(fs, "/data.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f, err := fs.OpenFile("/data.txt", os.O_RDWR, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		for i := 0; i < 100; i++ {
+			f.WriteAt([]byte("x"), int64(i%10))
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		snap := fs.Snapshot()
+		if b, err := ReadFile(snap, "/data.txt"); err != nil || len(b) != 10 {
+			t.Errorf("snapshot %d: %q, %v", i, b, err)
+		}
+		fs.Restore(snap)
+	}
+	<-done
+}
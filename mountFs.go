@@ -0,0 +1,529 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*MountFs)(nil)
+var _ Symlinker = (*MountFs)(nil)
+
+// The MountFs presents several filesystems as one namespace, each of them
+// mounted under a path prefix. Every call goes to the filesystem with the
+// longest prefix of the name, with the prefix removed from the name. The
+// directories leading to mount points are made up if no filesystem has them,
+// and mount points show up in the listing of their parent directory.
+//
+// Renaming files from one mount to another fails with EXDEV, as on most
+// operating systems.
+type MountFs struct {
+	mu     sync.RWMutex
+	mounts map[string]Fs
+}
+
+func NewMountFs() *MountFs {
+	return &MountFs{mounts: make(map[string]Fs)}
+}
+
+// Mount makes fs available under prefix, replacing whatever was mounted
+// there before. Mount fs under "/" to serve everything not covered by other
+// mounts.
+func (m *MountFs) Mount(prefix string, fs Fs) {
+	m.mu.Lock()
+	m.mounts[mountPath(prefix)] = fs
+	m.mu.Unlock()
+}
+
+// Unmount removes the filesystem mounted under prefix.
+func (m *MountFs) Unmount(prefix string) error {
+	prefix = mountPath(prefix)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mounts[prefix]; !ok {
+		return &os.PathError{Op: "unmount", Path: prefix, Err: syscall.EINVAL}
+	}
+	delete(m.mounts, prefix)
+	return nil
+}
+
+// mountPath cleans name and makes it absolute.
+func mountPath(name string) string {
+	return filepath.Join(FilePathSeparator, name)
+}
+
+// route returns the filesystem mounted under the longest prefix of name,
+// along with that prefix and the name within the filesystem.
+func (m *MountFs) route(name string) (fs Fs, prefix, inner string, ok bool) {
+	name = mountPath(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for p := name; ; p = filepath.Dir(p) {
+		if fs, ok := m.mounts[p]; ok {
+			return fs, p, mountPath(strings.TrimPrefix(name, p)), true
+		}
+		if p == filepath.Dir(p) {
+			return nil, "", "", false
+		}
+	}
+}
+
+// children returns the names of the mount points right below the directory
+// name, and the filesystems mounted there or nil for a made up directory
+// leading to deeper mount points.
+func (m *MountFs) children(name string) map[string]Fs {
+	name = mountPath(name)
+	prefix := strings.TrimSuffix(name, FilePathSeparator) + FilePathSeparator
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	children := make(map[string]Fs)
+	for p, fs := range m.mounts {
+		if p == name || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(rest, FilePathSeparator); i >= 0 {
+			if _, ok := children[rest[:i]]; !ok {
+				children[rest[:i]] = nil
+			}
+			continue
+		}
+		children[rest] = fs
+	}
+	return children
+}
+
+// isMountPoint reports whether name is a mount point or leads to one. Those
+// can't be removed or renamed.
+func (m *MountFs) isMountPoint(name string) bool {
+	name = mountPath(name)
+	m.mu.RLock()
+	_, ok := m.mounts[name]
+	m.mu.RUnlock()
+	return ok || len(m.children(name)) > 0
+}
+
+func (m *MountFs) Name() string {
+	return "MountFs"
+}
+
+func (m *MountFs) Create(name string) (File, error) {
+	fs, _, inner, ok := m.route(name)
+	if !ok {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrNotExist}
+	}
+	f, err := fs.Create(inner)
+	if err != nil {
+		return nil, err
+	}
+	return &mountFile{File: f, name: mountPath(name)}, nil
+}
+
+func (m *MountFs) Mkdir(name string, perm os.FileMode) error {
+	fs, _, inner, ok := m.route(name)
+	if len(m.children(name)) > 0 {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
+	}
+	if !ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.Mkdir(inner, perm)
+}
+
+func (m *MountFs) MkdirAll(path string, perm os.FileMode) error {
+	fs, _, inner, ok := m.route(path)
+	if len(m.children(path)) > 0 {
+		return nil
+	}
+	if !ok {
+		return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrNotExist}
+	}
+	return fs.MkdirAll(inner, perm)
+}
+
+func (m *MountFs) Open(name string) (File, error) {
+	name = mountPath(name)
+	fs, _, inner, ok := m.route(name)
+	children := m.children(name)
+
+	var f File
+	var err error
+	if ok {
+		if f, err = fs.Open(inner); err != nil && len(children) == 0 {
+			return nil, err
+		}
+	}
+	if len(children) == 0 {
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return &mountFile{File: f, name: name}, nil
+	}
+	if f != nil {
+		if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+			// a mount point hides the file below it
+			f.Close()
+			f = nil
+		}
+	}
+	return &mountDir{source: f, name: name, mounts: m.mountInfos(children)}, nil
+}
+
+// mountInfos returns the infos of the mount points children.
+func (m *MountFs) mountInfos(children map[string]Fs) []os.FileInfo {
+	infos := make([]os.FileInfo, 0, len(children))
+	for name, fs := range children {
+		infos = append(infos, m.mountInfo(name, fs))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos
+}
+
+// mountInfo returns the info of the root of fs, mounted under name, or a
+// made up directory if fs is nil or has no root.
+func (m *MountFs) mountInfo(name string, fs Fs) os.FileInfo {
+	if fs != nil {
+		if fi, err := fs.Stat(FilePathSeparator); err == nil {
+			return &mountFileInfo{FileInfo: fi, name: name}
+		}
+	}
+	return &mountFileInfo{name: name}
+}
+
+func (m *MountFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return m.Open(name)
+	}
+	fs, _, inner, ok := m.route(name)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	f, err := fs.OpenFile(inner, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &mountFile{File: f, name: mountPath(name)}, nil
+}
+
+func (m *MountFs) Remove(name string) error {
+	if m.isMountPoint(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	fs, _, inner, ok := m.route(name)
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.Remove(inner)
+}
+
+func (m *MountFs) RemoveAll(path string) error {
+	if m.isMountPoint(path) {
+		return &os.PathError{Op: "removeall", Path: path, Err: syscall.EBUSY}
+	}
+	fs, _, inner, ok := m.route(path)
+	if !ok {
+		return nil
+	}
+	return fs.RemoveAll(inner)
+}
+
+func (m *MountFs) Rename(oldname, newname string) error {
+	if m.isMountPoint(oldname) || m.isMountPoint(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EBUSY}
+	}
+	oldfs, oldprefix, oldinner, ok := m.route(oldname)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	_, newprefix, newinner, ok := m.route(newname)
+	if !ok || oldprefix != newprefix {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return oldfs.Rename(oldinner, newinner)
+}
+
+func (m *MountFs) Stat(name string) (os.FileInfo, error) {
+	name = mountPath(name)
+	fs, _, inner, ok := m.route(name)
+	hasMounts := len(m.children(name)) > 0
+	if ok {
+		fi, err := fs.Stat(inner)
+		if err == nil && (fi.IsDir() || !hasMounts) {
+			if fi.Name() != filepath.Base(name) {
+				// the root of a mount
+				return &mountFileInfo{FileInfo: fi, name: filepath.Base(name)}, nil
+			}
+			return fi, nil
+		}
+		if !hasMounts {
+			return nil, err
+		}
+	}
+	if hasMounts {
+		return &mountFileInfo{name: filepath.Base(name)}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *MountFs) Chmod(name string, mode os.FileMode) error {
+	fs, _, inner, ok := m.route(name)
+	if !ok {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.Chmod(inner, mode)
+}
+
+func (m *MountFs) Chown(name string, uid, gid int) error {
+	fs, _, inner, ok := m.route(name)
+	if !ok {
+		return &os.PathError{Op: "chown", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.Chown(inner, uid, gid)
+}
+
+func (m *MountFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	fs, _, inner, ok := m.route(name)
+	if !ok {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrNotExist}
+	}
+	return fs.Chtimes(inner, atime, mtime)
+}
+
+func (m *MountFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fs, _, inner, ok := m.route(name)
+	if ok && len(m.children(name)) == 0 {
+		if lstater, ok := fs.(Lstater); ok {
+			return lstater.LstatIfPossible(inner)
+		}
+	}
+	fi, err := m.Stat(name)
+	return fi, false, err
+}
+
+// SymlinkIfPossible creates the link in the mount of newname. An absolute
+// oldname has to be in the same mount.
+func (m *MountFs) SymlinkIfPossible(oldname, newname string) error {
+	fs, prefix, inner, ok := m.route(newname)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	target := oldname
+	if filepath.IsAbs(oldname) {
+		_, oldprefix, oldinner, ok := m.route(oldname)
+		if !ok || oldprefix != prefix {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EXDEV}
+		}
+		target = oldinner
+	}
+	if linker, ok := fs.(Linker); ok {
+		return linker.SymlinkIfPossible(target, inner)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (m *MountFs) ReadlinkIfPossible(name string) (string, error) {
+	fs, prefix, inner, ok := m.route(name)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+	reader, ok := fs.(LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+	}
+	target, err := reader.ReadlinkIfPossible(inner)
+	if err != nil || !filepath.IsAbs(target) {
+		return target, err
+	}
+	return filepath.Join(prefix, target), nil
+}
+
+// mountFile is a file of a mounted filesystem, named after its path in the
+// MountFs.
+type mountFile struct {
+	File
+	name string
+}
+
+func (f *mountFile) Name() string {
+	return f.name
+}
+
+func (f *mountFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil || fi.Name() == filepath.Base(f.name) {
+		return fi, err
+	}
+	return &mountFileInfo{FileInfo: fi, name: filepath.Base(f.name)}, nil
+}
+
+// mountFileInfo renames the info of the root of a mounted filesystem after
+// its mount point. Without FileInfo it describes a made up directory.
+type mountFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *mountFileInfo) Name() string { return fi.name }
+
+func (fi *mountFileInfo) Size() int64 {
+	if fi.FileInfo == nil {
+		return 0
+	}
+	return fi.FileInfo.Size()
+}
+
+func (fi *mountFileInfo) Mode() os.FileMode {
+	if fi.FileInfo == nil {
+		return os.ModeDir | 0555
+	}
+	return fi.FileInfo.Mode()
+}
+
+func (fi *mountFileInfo) ModTime() time.Time {
+	if fi.FileInfo == nil {
+		return time.Time{}
+	}
+	return fi.FileInfo.ModTime()
+}
+
+func (fi *mountFileInfo) IsDir() bool {
+	return fi.Mode().IsDir()
+}
+
+func (fi *mountFileInfo) Sys() interface{} {
+	if fi.FileInfo == nil {
+		return nil
+	}
+	return fi.FileInfo.Sys()
+}
+
+// mountDir is a directory holding mount points. Its listing is the one of
+// the source directory, if any, with the mount points on top.
+type mountDir struct {
+	source File
+	name   string
+	mounts []os.FileInfo
+	off    int
+	files  []os.FileInfo
+}
+
+func (d *mountDir) Close() error {
+	if d.source != nil {
+		return d.source.Close()
+	}
+	return nil
+}
+
+func (d *mountDir) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, &os.PathError{Op: "seek", Path: d.name, Err: syscall.EINVAL}
+	}
+	if d.source != nil {
+		// the source is listed again from its start
+		if _, err := d.source.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	d.off = 0
+	d.files = nil
+	return 0, nil
+}
+
+func (d *mountDir) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) WriteString(s string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *mountDir) Name() string {
+	return d.name
+}
+
+func (d *mountDir) Stat() (os.FileInfo, error) {
+	if d.source != nil {
+		return d.source.Stat()
+	}
+	return &mountFileInfo{name: filepath.Base(d.name)}, nil
+}
+
+func (d *mountDir) Sync() error {
+	return nil
+}
+
+func (d *mountDir) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: d.name, Err: syscall.EISDIR}
+}
+
+// Readdir lists the source directory and the mount points in it, a mount
+// point hiding the entry of the same name.
+// At the end of the directory view, the error is io.EOF if c > 0.
+func (d *mountDir) Readdir(c int) ([]os.FileInfo, error) {
+	if d.files == nil {
+		var fis []os.FileInfo
+		if d.source != nil {
+			var err error
+			if fis, err = d.source.Readdir(-1); err != nil {
+				return nil, err
+			}
+		}
+		mounted := make(map[string]bool)
+		for _, fi := range d.mounts {
+			mounted[fi.Name()] = true
+		}
+		d.files = append([]os.FileInfo{}, d.mounts...)
+		for _, fi := range fis {
+			if !mounted[fi.Name()] {
+				d.files = append(d.files, fi)
+			}
+		}
+		sort.Slice(d.files, func(i, j int) bool { return d.files[i].Name() < d.files[j].Name() })
+	}
+	files := d.files[d.off:]
+
+	if c <= 0 {
+		d.off += len(files)
+		return files, nil
+	}
+
+	if len(files) == 0 {
+		return nil, io.EOF
+	}
+
+	if c > len(files) {
+		c = len(files)
+	}
+
+	d.off += c
+	return files[:c], nil
+}
+
+func (d *mountDir) Readdirnames(c int) ([]string, error) {
+	fis, err := d.Readdir(c)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, nil
+}
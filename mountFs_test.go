@@ -0,0 +1,167 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func newMountFixture(t *testing.T) (root, tmp, data Fs, mfs *MountFs) {
+	root, tmp, data = NewMemMapFs(), NewMemMapFs(), NewMemMapFs()
+	for fs, files := range map[Fs][]string{
+		root: {"/etc/hosts", "/tmp/hidden.txt"},
+		tmp:  {"/scratch.txt", "/dir/a.txt"},
+		data: {"/db.sqlite"},
+	} {
+		for _, name := range files {
+			if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	mfs = NewMountFs()
+	mfs.Mount("/", root)
+	mfs.Mount("/tmp", tmp)
+	mfs.Mount("/srv/data", data)
+	return root, tmp, data, mfs
+}
+
+func TestMountFsRouting(t *testing.T) {
+	root, tmp, _, mfs := newMountFixture(t)
+
+	for name, expected := range map[string]string{
+		"/etc/hosts":          "/etc/hosts",
+		"/tmp/scratch.txt":    "/scratch.txt",
+		"/tmp/dir/a.txt":      "/dir/a.txt",
+		"/srv/data/db.sqlite": "/db.sqlite",
+	} {
+		if b, err := ReadFile(mfs, name); err != nil || string(b) != expected {
+			t.Errorf("%s: expected %q, got %q, %v", name, expected, b, err)
+		}
+	}
+	if _, err := mfs.Stat("/tmp/hidden.txt"); !os.IsNotExist(err) {
+		t.Errorf("a mount must hide what is below it: %v", err)
+	}
+
+	if err := WriteFile(mfs, "/tmp/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmp.Stat("/new.txt"); err != nil {
+		t.Errorf("file not created in the mounted fs: %v", err)
+	}
+	if _, err := root.Stat("/tmp/new.txt"); !os.IsNotExist(err) {
+		t.Errorf("file created in the wrong fs: %v", err)
+	}
+
+	f, err := mfs.Open("/tmp/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != "/tmp/dir/a.txt" {
+		t.Errorf("file name should be the path in the MountFs, got %s", f.Name())
+	}
+	f.Close()
+
+	if err := mfs.Rename("/tmp/scratch.txt", "/tmp/dir/moved.txt"); err != nil {
+		t.Error(err)
+	}
+	err = mfs.Rename("/tmp/dir/moved.txt", "/etc/moved.txt")
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+		t.Errorf("rename across mounts: expected EXDEV, got %v", err)
+	}
+	if err := mfs.Remove("/tmp"); err == nil || err.(*os.PathError).Err != syscall.EBUSY {
+		t.Errorf("removing a mount point: expected EBUSY, got %v", err)
+	}
+
+	if err := mfs.Unmount("/tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("/tmp/hidden.txt"); err != nil {
+		t.Errorf("unmounting should uncover the files below: %v", err)
+	}
+	if err := mfs.Unmount("/tmp"); err == nil {
+		t.Error("unmounting twice should fail")
+	}
+}
+
+func TestMountFsReaddir(t *testing.T) {
+	_, _, _, mfs := newMountFixture(t)
+
+	readdirnames := func(name string) string {
+		f, err := mfs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(names, ",")
+	}
+	if names := readdirnames("/"); names != "etc,srv,tmp" {
+		t.Errorf("/: got %s", names)
+	}
+	if names := readdirnames("/srv"); names != "data" {
+		t.Errorf("/srv: got %s", names)
+	}
+	if names := readdirnames("/tmp"); names != "dir,scratch.txt" {
+		t.Errorf("/tmp: got %s", names)
+	}
+
+	// listed again from the start after seeking back to it
+	f, err := mfs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Readdirnames(-1)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if strings.Join(names, ",") != "etc,srv,tmp" || err != nil {
+		t.Errorf("/ after seeking to the start: got %v, %v", names, err)
+	}
+
+	fi, err := mfs.Stat("/srv")
+	if err != nil || !fi.IsDir() || fi.Name() != "srv" {
+		t.Errorf("made up directory: %v, %v", fi, err)
+	}
+	fi, err = mfs.Stat("/srv/data")
+	if err != nil || !fi.IsDir() || fi.Name() != "data" {
+		t.Errorf("mount point: %v, %v", fi, err)
+	}
+
+	// without a root mount only the mount points exist
+	mfs.Unmount("/")
+	if names := readdirnames("/"); names != "srv,tmp" {
+		t.Errorf("/ without root mount: got %s", names)
+	}
+	if _, err := mfs.Stat("/etc/hosts"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+}
+
+func TestMountFsSymlinks(t *testing.T) {
+	_, _, _, mfs := newMountFixture(t)
+
+	if err := mfs.SymlinkIfPossible("/tmp/dir/a.txt", "/tmp/link"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ReadFile(mfs, "/tmp/link"); err != nil || string(b) != "/dir/a.txt" {
+		t.Errorf("reading through the link: %q, %v", b, err)
+	}
+	if target, err := mfs.ReadlinkIfPossible("/tmp/link"); err != nil || target != "/tmp/dir/a.txt" {
+		t.Errorf("readlink: %q, %v", target, err)
+	}
+	if fi, ok, err := mfs.LstatIfPossible("/tmp/link"); err != nil || !ok || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat: %v, %t, %v", fi, ok, err)
+	}
+	err := mfs.SymlinkIfPossible("/etc/hosts", "/tmp/hosts")
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+		t.Errorf("link across mounts: expected EXDEV, got %v", err)
+	}
+}
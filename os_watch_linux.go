@@ -0,0 +1,449 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var _ Watcher = (*OsFs)(nil)
+
+// Watch reports the changes to name through inotify. Directories below name
+// are registered as they appear if recursive is set. Events carry absolute
+// names. Writes are reported as they happen, so a file written in several
+// calls gives several Write events.
+func (OsFs) Watch(name string, recursive bool) (<-chan Event, error) {
+	return osWatcher.watch(name, recursive)
+}
+
+func (OsFs) Unwatch(events <-chan Event) error {
+	return osWatcher.unwatch(events)
+}
+
+// osWatcher is shared by all OsFs, which have no state of their own.
+var osWatcher inotify
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotify translates the events of an inotify instance, which is open as
+// long as anything is watched.
+type inotify struct {
+	mu      sync.Mutex
+	file    *os.File
+	fd      int // of file, which must not be asked for it
+	watches watches
+	roots   map[<-chan Event]inotifyRoot
+	paths   map[int32]string // watch descriptors to names
+	wds     map[string]int32
+	// moves keeps the old names of directories moved away, by cookie,
+	// until the other half of the move shows up.
+	moves map[uint32]string
+	// state is what is known about the watched entries, to find what has
+	// been missed when the event queue overflows.
+	state map[string]pollEntry
+	// overflowed and grown are left by handle for catchUp, which reads
+	// without holding mu: the event queue has overflowed, and directories
+	// have appeared in recursively watched trees.
+	overflowed bool
+	grown      []inotifyTree
+}
+
+// inotifyTree is a directory which appeared in a watched tree, whose entries
+// are to be reported as created if report is set.
+type inotifyTree struct {
+	path   string
+	report bool
+}
+
+type inotifyRoot struct {
+	path      string
+	recursive bool
+}
+
+func (i *inotify) watch(name string, recursive bool) (<-chan Event, error) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+
+	i.mu.Lock()
+	if i.file == nil {
+		fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+		if err != nil {
+			i.mu.Unlock()
+			return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+		}
+		i.file = os.NewFile(uintptr(fd), "inotify")
+		i.roots = make(map[<-chan Event]inotifyRoot)
+		i.paths = make(map[int32]string)
+		i.wds = make(map[string]int32)
+		i.moves = make(map[uint32]string)
+		i.state = make(map[string]pollEntry)
+		i.fd = fd
+		go i.read(i.file)
+	}
+	// the root keeps the instance open while its tree is read
+	f := i.file
+	events := i.watches.add(path, recursive)
+	i.roots[events] = inotifyRoot{path: path, recursive: recursive}
+	i.mu.Unlock()
+
+	if err := i.addTree(f, path, recursive, false); err != nil {
+		i.mu.Lock()
+		i.watches.remove(events)
+		i.drop(events)
+		i.mu.Unlock()
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	return events, nil
+}
+
+func (i *inotify) unwatch(events <-chan Event) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.watches.remove(events); err != nil {
+		return err
+	}
+	i.drop(events)
+	return nil
+}
+
+// drop forgets the root of events, and removes the kernel watches no other
+// root uses. The instance is closed with the last root.
+// The caller must hold i.mu.
+func (i *inotify) drop(events <-chan Event) {
+	delete(i.roots, events)
+	if len(i.roots) == 0 {
+		i.close()
+		return
+	}
+	for path, wd := range i.wds {
+		if i.users(path, true) == 0 {
+			syscall.InotifyRmWatch(i.fd, uint32(wd))
+			delete(i.wds, path)
+			delete(i.paths, wd)
+		}
+	}
+	for path := range i.state {
+		if i.users(path, false) == 0 {
+			delete(i.state, path)
+		}
+	}
+}
+
+// users counts the roots which watch path, with a kernel watch of its own
+// if watched is set, or at least as an entry of a watched directory.
+// The caller must hold i.mu.
+func (i *inotify) users(path string, watched bool) int {
+	n := 0
+	for _, root := range i.roots {
+		switch {
+		case root.path == path, root.recursive && isWithin(root.path, path):
+			n++
+		case !watched && filepath.Dir(path) == root.path:
+			n++
+		}
+	}
+	return n
+}
+
+// close closes the inotify instance, which ends its reader.
+// The caller must hold i.mu.
+func (i *inotify) close() {
+	i.file.Close()
+	i.file = nil
+	i.roots, i.paths, i.wds, i.moves, i.state = nil, nil, nil, nil, nil
+	i.overflowed, i.grown = false, nil
+}
+
+func (i *inotify) read(f *os.File) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		i.mu.Lock()
+		if i.file != f {
+			i.mu.Unlock()
+			return
+		}
+		i.handle(buf[:n])
+		i.mu.Unlock()
+		i.catchUp(f)
+	}
+}
+
+// catchUp does what handle has left to do outside of i.mu, for the
+// instance f.
+func (i *inotify) catchUp(f *os.File) {
+	i.mu.Lock()
+	if i.file != f {
+		i.mu.Unlock()
+		return
+	}
+	overflowed, grown := i.overflowed, i.grown
+	i.overflowed, i.grown = false, nil
+	i.mu.Unlock()
+
+	if overflowed {
+		// the rescan covers the directories which appeared as well
+		i.rescan(f)
+		return
+	}
+	for _, t := range grown {
+		i.addTree(f, t.path, true, t.report)
+	}
+}
+
+// handle translates the raw events in buf.
+// The caller must hold i.mu.
+func (i *inotify) handle(buf []byte) {
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := syscall.SizeofInotifyEvent + int(raw.Len)
+		if end > len(buf) {
+			break
+		}
+		base := strings.TrimRight(string(buf[syscall.SizeofInotifyEvent:end]), "\x00")
+		buf = buf[end:]
+
+		if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			i.overflowed = true
+			continue
+		}
+		dir, ok := i.paths[raw.Wd]
+		if !ok {
+			continue
+		}
+		if raw.Mask&syscall.IN_IGNORED != 0 {
+			delete(i.paths, raw.Wd)
+			if i.wds[dir] == raw.Wd {
+				delete(i.wds, dir)
+			}
+			continue
+		}
+		name := dir
+		if base != "" {
+			name = filepath.Join(dir, base)
+		} else if _, ok := i.wds[filepath.Dir(dir)]; ok {
+			// the watch of the parent reports this as well
+			continue
+		}
+		i.event(name, raw.Mask, raw.Cookie)
+	}
+
+	// directories moved out of the watched trees
+	for cookie, old := range i.moves {
+		i.dropTree(old)
+		delete(i.moves, cookie)
+	}
+}
+
+// event reports a single change to name.
+// The caller must hold i.mu.
+func (i *inotify) event(name string, mask, cookie uint32) {
+	isDir := mask&syscall.IN_ISDIR != 0
+	switch {
+	case mask&syscall.IN_CREATE != 0:
+		i.watches.notify(name, Create)
+		i.update(name)
+		if isDir && i.recursiveAt(name) {
+			i.grown = append(i.grown, inotifyTree{name, true})
+		}
+	case mask&syscall.IN_MOVED_FROM != 0:
+		i.watches.notify(name, Rename)
+		if isDir {
+			i.moves[cookie] = name
+		}
+		i.forget(name)
+	case mask&syscall.IN_MOVED_TO != 0:
+		i.watches.notify(name, Create)
+		i.update(name)
+		if !isDir {
+			break
+		}
+		if old, ok := i.moves[cookie]; ok {
+			delete(i.moves, cookie)
+			i.moveTree(old, name)
+		}
+		if i.recursiveAt(name) {
+			i.grown = append(i.grown, inotifyTree{name, false})
+		}
+	case mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0:
+		i.watches.notify(name, Remove)
+		i.forget(name)
+	case mask&syscall.IN_MOVE_SELF != 0:
+		i.watches.notify(name, Rename)
+		i.forget(name)
+	case mask&syscall.IN_MODIFY != 0:
+		i.watches.notify(name, Write)
+		i.update(name)
+	case mask&syscall.IN_ATTRIB != 0:
+		i.watches.notify(name, Chmod)
+		i.update(name)
+	}
+}
+
+// recursiveAt reports whether name is below a recursive watch.
+// The caller must hold i.mu.
+func (i *inotify) recursiveAt(name string) bool {
+	for _, root := range i.roots {
+		if root.recursive && isWithin(root.path, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree adds kernel watches for path, and for the directories below it if
+// recursive is set. Their entries are reported as created if report is set,
+// as they may have appeared before the watches were in place. The tree is
+// read without holding i.mu, which is only taken to record each entry;
+// os.ErrClosed is returned if f is closed meanwhile.
+func (i *inotify) addTree(f *os.File, path string, recursive, report bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err := i.record(f, path, info, false, true); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	return Walk(OsFs{}, path, func(name string, info os.FileInfo, err error) error {
+		if name == path {
+			return err
+		}
+		if err != nil {
+			// unreadable parts are left out
+			return nil
+		}
+		watch := info.IsDir() && recursive
+		if err := i.record(f, name, info, report, watch); err != nil {
+			return err
+		}
+		if info.IsDir() && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// record notes the attributes of name, reports it as created if report is
+// set, and adds a kernel watch for it if watch is set, unless f has been
+// closed.
+func (i *inotify) record(f *os.File, name string, info os.FileInfo, report, watch bool) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.file != f {
+		return os.ErrClosed
+	}
+	i.state[name] = newPollEntry(info)
+	if report {
+		i.watches.notify(name, Create)
+	}
+	if watch {
+		return i.add(name)
+	}
+	return nil
+}
+
+// add adds a kernel watch for path.
+// The caller must hold i.mu.
+func (i *inotify) add(path string) error {
+	wd, err := syscall.InotifyAddWatch(i.fd, path, inotifyMask)
+	if err != nil {
+		return err
+	}
+	i.paths[int32(wd)] = path
+	i.wds[path] = int32(wd)
+	return nil
+}
+
+// moveTree renames the kernel watches and what is known below old after a
+// directory has been moved within the watched trees.
+// The caller must hold i.mu.
+func (i *inotify) moveTree(old, name string) {
+	for path, wd := range i.wds {
+		if isWithin(old, path) {
+			moved := name + strings.TrimPrefix(path, old)
+			delete(i.wds, path)
+			i.wds[moved] = wd
+			i.paths[wd] = moved
+		}
+	}
+	for path, entry := range i.state {
+		if isWithin(old, path) {
+			delete(i.state, path)
+			i.state[name+strings.TrimPrefix(path, old)] = entry
+		}
+	}
+}
+
+// dropTree removes the kernel watches at or below path.
+// The caller must hold i.mu.
+func (i *inotify) dropTree(path string) {
+	for name, wd := range i.wds {
+		if isWithin(path, name) {
+			syscall.InotifyRmWatch(i.fd, uint32(wd))
+			delete(i.wds, name)
+			delete(i.paths, wd)
+		}
+	}
+}
+
+// update records the current attributes of name.
+// The caller must hold i.mu.
+func (i *inotify) update(name string) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		delete(i.state, name)
+		return
+	}
+	i.state[name] = newPollEntry(info)
+}
+
+// forget drops what is known about name and everything below it.
+// The caller must hold i.mu.
+func (i *inotify) forget(name string) {
+	for path := range i.state {
+		if isWithin(name, path) {
+			delete(i.state, path)
+		}
+	}
+}
+
+// rescan walks all watched trees of the instance f after events have been
+// lost, and reports the differences to what is known.
+func (i *inotify) rescan(f *os.File) {
+	i.mu.Lock()
+	if i.file != f {
+		i.mu.Unlock()
+		return
+	}
+	prev := i.state
+	i.state = make(map[string]pollEntry, len(prev))
+	roots := make([]inotifyRoot, 0, len(i.roots))
+	for _, root := range i.roots {
+		roots = append(roots, root)
+	}
+	i.mu.Unlock()
+
+	for _, root := range roots {
+		i.addTree(f, root.path, root.recursive, false)
+	}
+
+	i.mu.Lock()
+	if i.file == f {
+		i.watches.report(prev, i.state)
+	}
+	i.mu.Unlock()
+}
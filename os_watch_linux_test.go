@@ -0,0 +1,170 @@
+package afero
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestOsFsWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "afero-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewOsFs().(*OsFs)
+	events, err := fs.Watch(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Unwatch(events)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	f, err := fs.Create(path("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("content")
+	f.Close()
+	expectEvents(t, events, Event{path("file"), Create}, Event{path("file"), Write})
+	if err := fs.Chmod(path("file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{path("file"), Chmod})
+
+	// directories created later are watched as well
+	if err := fs.Mkdir(path("sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{path("sub"), Create})
+	if _, err := fs.Create(path("sub/a")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{path("sub/a"), Create})
+
+	// and keep being watched under their new name
+	if err := fs.Rename(path("sub"), path("moved")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{path("sub"), Rename}, Event{path("moved"), Create})
+	if err := fs.Remove(path("moved/a")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{path("moved/a"), Remove})
+	expectNoEvents(t, events)
+}
+
+func TestOsFsWatchOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "afero-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewOsFs().(*OsFs)
+	events, err := fs.Watch(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Unwatch(events)
+
+	// the watcher knows an older version of the file, whose change is lost
+	// with the queue overflowing
+	name := filepath.Join(dir, "missed")
+	osWatcher.mu.Lock()
+	ioutil.WriteFile(name, nil, 0644)
+	osWatcher.state[name] = pollEntry{size: 1}
+	overflow := syscall.InotifyEvent{Wd: -1, Mask: syscall.IN_Q_OVERFLOW}
+	osWatcher.handle((*[syscall.SizeofInotifyEvent]byte)(unsafe.Pointer(&overflow))[:])
+	f := osWatcher.file
+	osWatcher.mu.Unlock()
+	osWatcher.catchUp(f)
+
+	expectEvents(t, events, Event{name, Write})
+}
+
+func TestOsFsUnwatchRemovesWatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "afero-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "sub")
+	if err := os.MkdirAll(filepath.Join(sub, "deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewOsFs().(*OsFs)
+	all, err := fs.Watch(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := fs.Watch(sub, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Unwatch(events)
+	if err := fs.Unwatch(all); err != nil {
+		t.Fatal(err)
+	}
+
+	osWatcher.mu.Lock()
+	var watched []string
+	for path := range osWatcher.wds {
+		watched = append(watched, path)
+	}
+	osWatcher.mu.Unlock()
+	if len(watched) != 1 || watched[0] != sub {
+		t.Errorf("watching %v, expected only %s", watched, sub)
+	}
+
+	if _, err := fs.Create(filepath.Join(sub, "file")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{filepath.Join(sub, "file"), Create})
+}
+
+func TestBasePathFsWatchOsFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "afero-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewBasePathFs(NewOsFs(), dir).(*BasePathFs)
+	events, err := NewReadOnlyFs(fs).(*ReadOnlyFs).Watch("/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Unwatch(events)
+
+	if err := fs.Mkdir("/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/sub", Create})
+}
+
+func TestBasePathFsWatchWrappedOsFs(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "afero-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a relative base over an OsFs the BasePathFs can't see
+	fs := NewBasePathFs(NewReadOnlyFs(NewOsFs()), dir).(*BasePathFs)
+	events, err := fs.Watch("/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Unwatch(events)
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/sub", Create})
+}
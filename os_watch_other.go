@@ -0,0 +1,55 @@
+// +build !linux
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var _ Watcher = (*OsFs)(nil)
+
+// osPollInterval is how often OsFs looks for changes where the system offers
+// no notifications afero knows about.
+const osPollInterval = time.Second
+
+// Watch reports the changes to name by polling it with a PollingWatcher.
+// Events carry absolute names.
+func (OsFs) Watch(name string, recursive bool) (<-chan Event, error) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	w := NewPollingWatcher(OsFs{}, path, osPollInterval)
+	events, err := w.Watch(path, recursive)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	osPollers.Lock()
+	defer osPollers.Unlock()
+	if osPollers.m == nil {
+		osPollers.m = make(map[<-chan Event]*PollingWatcher)
+	}
+	osPollers.m[events] = w
+	return events, nil
+}
+
+func (OsFs) Unwatch(events <-chan Event) error {
+	osPollers.Lock()
+	defer osPollers.Unlock()
+	w, ok := osPollers.m[events]
+	if !ok {
+		return ErrNotWatching
+	}
+	delete(osPollers.m, events)
+	return w.Close()
+}
+
+// osPollers holds the watcher of every watch on an OsFs.
+var osPollers struct {
+	sync.Mutex
+	m map[<-chan Event]*PollingWatcher
+}
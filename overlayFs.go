@@ -0,0 +1,426 @@
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*OverlayFs)(nil)
+
+// The OverlayFs stacks any number of read only lower layers below a writable
+// upper layer. Lookups go through the layers from the top down, the first
+// layer holding a name wins. Changes are only made in the upper layer:
+// changing a file of a lower layer copies it to the upper layer first.
+//
+// Directories present in several layers are merged: Readdir reads every
+// layer once and folds the entries from the top down with Merger, an entry
+// of a higher layer hiding those of the same name below it. A file hides
+// directories of the same name in the layers below.
+//
+// As in the CopyOnWriteFs without Whiteouts, files present in a lower layer
+// can neither be removed nor renamed.
+type OverlayFs struct {
+	// Merger weaves the entries of a directory with those of the same
+	// directory in the next lower layer. The default keeps the entries of
+	// the higher layer.
+	Merger DirsMerger
+
+	// layers holds the upper layer first, followed by the lower ones.
+	layers []Fs
+}
+
+// NewOverlayFs returns an OverlayFs with upper on top of lowers, the first
+// of lowers being the topmost of them.
+func NewOverlayFs(upper Fs, lowers ...Fs) Fs {
+	return &OverlayFs{layers: append([]Fs{upper}, lowers...)}
+}
+
+func (o *OverlayFs) Name() string {
+	return "OverlayFs"
+}
+
+func (o *OverlayFs) upper() Fs {
+	return o.layers[0]
+}
+
+func (o *OverlayFs) isNotExist(err error) bool {
+	if e, ok := err.(*os.PathError); ok {
+		err = e.Err
+	}
+	if err == os.ErrNotExist || err == syscall.ENOENT || err == syscall.ENOTDIR {
+		return true
+	}
+	return false
+}
+
+// dirLayers returns the layers making up the directory name, from the top
+// down to the first layer holding something else than a directory under
+// this name, which hides the layers below.
+func (o *OverlayFs) dirLayers(name string) ([]Fs, error) {
+	name = filepath.Clean(name)
+	parent := filepath.Dir(name)
+	if parent == name {
+		return o.layers, nil
+	}
+	layers, err := o.dirLayers(parent)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []Fs
+	for _, layer := range layers {
+		fi, err := layer.Stat(name)
+		if err != nil {
+			if o.isNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if !fi.IsDir() {
+			if len(dirs) == 0 {
+				return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.ENOTDIR}
+			}
+			break
+		}
+		dirs = append(dirs, layer)
+	}
+	if len(dirs) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return dirs, nil
+}
+
+// find returns the topmost layer holding name, the layers below it which
+// may hold it as well, and its info.
+func (o *OverlayFs) find(name string) (Fs, []Fs, os.FileInfo, error) {
+	layers, err := o.dirLayers(filepath.Dir(filepath.Clean(name)))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i, layer := range layers {
+		fi, err := layer.Stat(name)
+		if err == nil {
+			return layer, layers[i+1:], fi, nil
+		}
+		if !o.isNotExist(err) {
+			return nil, nil, nil, err
+		}
+	}
+	return nil, nil, nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// isDir reports whether name is a directory in the overlay.
+func (o *OverlayFs) isDir(name string) (bool, error) {
+	_, _, fi, err := o.find(name)
+	if err != nil {
+		if o.isNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+// copyUp makes sure name is present in the upper layer, copying it from the
+// topmost lower layer holding it.
+func (o *OverlayFs) copyUp(name string) error {
+	layer, _, fi, err := o.find(name)
+	if err != nil || layer == o.upper() {
+		return err
+	}
+	if fi.IsDir() {
+		return o.upper().MkdirAll(name, fi.Mode().Perm())
+	}
+	return copyToLayer(layer, o.upper(), name)
+}
+
+// prepareParent creates the directory of name in the upper layer if it is
+// only present in a lower one.
+func (o *OverlayFs) prepareParent(name string) error {
+	dir := filepath.Dir(name)
+	_, _, fi, err := o.find(dir)
+	if err != nil {
+		if o.isNotExist(err) {
+			return &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+		}
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR}
+	}
+	return o.upper().MkdirAll(dir, 0777)
+}
+
+func (o *OverlayFs) Stat(name string) (os.FileInfo, error) {
+	_, _, fi, err := o.find(name)
+	return fi, err
+}
+
+func (o *OverlayFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	layers, err := o.dirLayers(filepath.Dir(filepath.Clean(name)))
+	if err != nil {
+		return nil, false, err
+	}
+	for _, layer := range layers {
+		var fi os.FileInfo
+		var b bool
+		var err error
+		if l, ok := layer.(Lstater); ok {
+			fi, b, err = l.LstatIfPossible(name)
+		} else {
+			fi, err = layer.Stat(name)
+		}
+		if err == nil || !o.isNotExist(err) {
+			return fi, b, err
+		}
+	}
+	return nil, false, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+}
+
+func (o *OverlayFs) Open(name string) (File, error) {
+	top, lowers, fi, err := o.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return top.Open(name)
+	}
+
+	// Collect the directory from every layer down to the first one holding
+	// something else than a directory under this name.
+	var dirs []File
+	for _, layer := range append([]Fs{top}, lowers...) {
+		fi, err := layer.Stat(name)
+		if err != nil && o.isNotExist(err) {
+			continue
+		}
+		if err == nil && !fi.IsDir() {
+			break
+		}
+		var f File
+		if err == nil {
+			f, err = layer.Open(name)
+		}
+		if err != nil {
+			for _, f := range dirs {
+				f.Close()
+			}
+			return nil, err
+		}
+		dirs = append(dirs, f)
+	}
+	if len(dirs) == 1 {
+		return dirs[0], nil
+	}
+	return &overlayDir{File: dirs[0], lowers: dirs[1:], merge: o.Merger}, nil
+}
+
+func (o *OverlayFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return o.Open(name)
+	}
+
+	layer, _, _, err := o.find(name)
+	if err != nil && !o.isNotExist(err) {
+		return nil, err
+	}
+	switch {
+	case layer == nil:
+		if err := o.prepareParent(name); err != nil {
+			return nil, err
+		}
+	case layer != o.upper():
+		if err := o.copyUp(name); err != nil {
+			return nil, err
+		}
+	}
+	return o.upper().OpenFile(name, flag, perm)
+}
+
+func (o *OverlayFs) Create(name string) (File, error) {
+	return o.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+}
+
+func (o *OverlayFs) Mkdir(name string, perm os.FileMode) error {
+	layer, _, _, err := o.find(name)
+	if layer != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
+	}
+	if !o.isNotExist(err) {
+		return err
+	}
+	if err := o.prepareParent(name); err != nil {
+		return err
+	}
+	return o.upper().Mkdir(name, perm)
+}
+
+func (o *OverlayFs) MkdirAll(name string, perm os.FileMode) error {
+	isaDir, err := o.isDir(name)
+	if err != nil {
+		return err
+	}
+	if isaDir {
+		// This is in line with how os.MkdirAll behaves.
+		return nil
+	}
+	return o.upper().MkdirAll(name, perm)
+}
+
+func (o *OverlayFs) Chmod(name string, mode os.FileMode) error {
+	if err := o.copyUp(name); err != nil {
+		return err
+	}
+	return o.upper().Chmod(name, mode)
+}
+
+func (o *OverlayFs) Chown(name string, uid, gid int) error {
+	if err := o.copyUp(name); err != nil {
+		return err
+	}
+	return o.upper().Chown(name, uid, gid)
+}
+
+func (o *OverlayFs) Chtimes(name string, atime, mtime time.Time) error {
+	if err := o.copyUp(name); err != nil {
+		return err
+	}
+	return o.upper().Chtimes(name, atime, mtime)
+}
+
+// inLower reports whether name is present in a lower layer of the overlay.
+func (o *OverlayFs) inLower(name string) (bool, error) {
+	layer, lowers, _, err := o.find(name)
+	if err != nil {
+		if o.isNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if layer != o.upper() {
+		return true, nil
+	}
+	for _, layer := range lowers {
+		_, err := layer.Stat(name)
+		if err == nil {
+			return true, nil
+		}
+		if !o.isNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Renaming files present in a lower layer is not permitted.
+func (o *OverlayFs) Rename(oldname, newname string) error {
+	lower, err := o.inLower(oldname)
+	if err != nil {
+		return err
+	}
+	if lower {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	if err := o.prepareParent(newname); err != nil {
+		return err
+	}
+	return o.upper().Rename(oldname, newname)
+}
+
+// Removing files present only in a lower layer is not permitted. If a file
+// is present in the upper layer and a lower one, only the upper one will be
+// removed.
+func (o *OverlayFs) Remove(name string) error {
+	err := o.upper().Remove(name)
+	if err == nil || !o.isNotExist(err) {
+		return err
+	}
+	if lower, _ := o.inLower(name); lower {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+	}
+	return err
+}
+
+func (o *OverlayFs) RemoveAll(name string) error {
+	if _, err := o.upper().Stat(name); err == nil {
+		return o.upper().RemoveAll(name)
+	}
+	if lower, _ := o.inLower(name); lower {
+		return &os.PathError{Op: "removeall", Path: name, Err: syscall.EPERM}
+	}
+	return nil
+}
+
+// overlayDir is a directory present in several layers of an OverlayFs. All
+// calls but Readdir go to the directory of the topmost layer.
+type overlayDir struct {
+	File
+	lowers []File
+	merge  DirsMerger
+	off    int
+	files  []os.FileInfo
+}
+
+func (d *overlayDir) Close() error {
+	for _, f := range d.lowers {
+		f.Close()
+	}
+	return d.File.Close()
+}
+
+// Readdir merges the directories of all layers, reading each of them once.
+// At the end of the directory view, the error is io.EOF if c > 0.
+func (d *overlayDir) Readdir(c int) ([]os.FileInfo, error) {
+	merge := d.merge
+	if merge == nil {
+		merge = defaultUnionMergeDirsFn
+	}
+
+	if d.files == nil {
+		merged, err := d.File.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range d.lowers {
+			fis, err := f.Readdir(-1)
+			if err != nil {
+				return nil, err
+			}
+			if merged, err = merge(merged, fis); err != nil {
+				return nil, err
+			}
+		}
+		d.files = append([]os.FileInfo{}, merged...)
+	}
+	files := d.files[d.off:]
+
+	if c <= 0 {
+		d.off += len(files)
+		return files, nil
+	}
+
+	if len(files) == 0 {
+		return nil, io.EOF
+	}
+
+	if c > len(files) {
+		c = len(files)
+	}
+
+	d.off += c
+	return files[:c], nil
+}
+
+func (d *overlayDir) Readdirnames(c int) ([]string, error) {
+	fis, err := d.Readdir(c)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, nil
+}
@@ -0,0 +1,135 @@
+package afero
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func newOverlayFixture(t *testing.T) (upper, site, defaults Fs, ofs Fs) {
+	defaults = NewMemMapFs()
+	site = NewMemMapFs()
+	upper = NewMemMapFs()
+	for _, layer := range []struct {
+		fs    Fs
+		name  string
+		files []string
+	}{
+		{defaults, "defaults", []string{"/etc/app.conf", "/etc/defaults.conf", "/etc/conf.d/a.conf", "/etc/shadowed/x"}},
+		{site, "site", []string{"/etc/app.conf", "/etc/site.conf", "/etc/conf.d/b.conf", "/etc/shadowed"}},
+		{upper, "upper", []string{"/etc/app.conf", "/etc/conf.d/c.conf"}},
+	} {
+		for _, name := range layer.files {
+			// the content tells the layers apart
+			if err := WriteFile(layer.fs, name, []byte(layer.name+":"+name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return upper, site, defaults, NewOverlayFs(upper, site, defaults)
+}
+
+func TestOverlayFsLookup(t *testing.T) {
+	_, _, _, ofs := newOverlayFixture(t)
+
+	for name, expected := range map[string]string{
+		"/etc/app.conf":      "upper:/etc/app.conf",
+		"/etc/site.conf":     "site:/etc/site.conf",
+		"/etc/defaults.conf": "defaults:/etc/defaults.conf",
+		"/etc/shadowed":      "site:/etc/shadowed",
+	} {
+		if b, err := ReadFile(ofs, name); err != nil || string(b) != expected {
+			t.Errorf("%s: expected %q, got %q, %v", name, expected, b, err)
+		}
+	}
+	if _, err := ofs.Stat("/etc/shadowed/x"); err == nil {
+		t.Error("a file must hide the directories below it")
+	}
+
+	readdirnames := func(name string) string {
+		f, err := ofs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	if names := readdirnames("/etc"); names != "app.conf,conf.d,defaults.conf,shadowed,site.conf" {
+		t.Errorf("/etc: got %s", names)
+	}
+	if names := readdirnames("/etc/conf.d"); names != "a.conf,b.conf,c.conf" {
+		t.Errorf("/etc/conf.d: got %s", names)
+	}
+
+	f, err := ofs.Open("/etc/conf.d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 3; i++ {
+		if fis, err := f.Readdir(1); err != nil || len(fis) != 1 {
+			t.Fatalf("Readdir(1) #%d: %v, %v", i, fis, err)
+		}
+	}
+	if _, err := f.Readdir(1); err == nil {
+		t.Error("expected io.EOF at the end of the directory")
+	}
+}
+
+func TestOverlayFsCopyUp(t *testing.T) {
+	upper, site, _, ofs := newOverlayFixture(t)
+
+	f, err := ofs.OpenFile("/etc/site.conf", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("+user")
+	f.Close()
+
+	if b, _ := ReadFile(ofs, "/etc/site.conf"); string(b) != "site:/etc/site.conf+user" {
+		t.Errorf("overlay: got %q", b)
+	}
+	if b, _ := ReadFile(upper, "/etc/site.conf"); string(b) != "site:/etc/site.conf+user" {
+		t.Errorf("upper: got %q", b)
+	}
+	if b, _ := ReadFile(site, "/etc/site.conf"); string(b) != "site:/etc/site.conf" {
+		t.Errorf("lower layers must not change, got %q", b)
+	}
+
+	if err := ofs.Chmod("/etc/defaults.conf", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := upper.Stat("/etc/defaults.conf"); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("chmod should copy up: %v, %v", fi, err)
+	}
+
+	if err := WriteFile(ofs, "/etc/conf.d/new.conf", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upper.Stat("/etc/conf.d/new.conf"); err != nil {
+		t.Errorf("new files belong in the upper layer: %v", err)
+	}
+	if err := ofs.Mkdir("/etc/conf.d", 0755); !os.IsExist(err) && err.(*os.PathError).Err != ErrFileExists {
+		t.Errorf("mkdir of a lower directory: %v", err)
+	}
+
+	if err := ofs.Remove("/etc/conf.d/a.conf"); err == nil || err.(*os.PathError).Err != syscall.EPERM {
+		t.Errorf("removing a lower file: expected EPERM, got %v", err)
+	}
+	if err := ofs.Remove("/etc/conf.d/new.conf"); err != nil {
+		t.Error(err)
+	}
+	if err := ofs.Remove("/etc/app.conf"); err != nil {
+		t.Error(err)
+	}
+	if b, _ := ReadFile(ofs, "/etc/app.conf"); string(b) != "site:/etc/app.conf" {
+		t.Errorf("removing the upper file should uncover the next layer, got %q", b)
+	}
+}
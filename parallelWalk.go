@@ -0,0 +1,360 @@
+// +build go1.16
+
+package afero
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/spf13/afero/mem"
+)
+
+// ParallelWalkOptions tune ParallelWalk. The zero value calls the walk
+// function in the order of WalkDir and doesn't follow symbolic links.
+type ParallelWalkOptions struct {
+	// Workers bounds the directories read at once, GOMAXPROCS if not set.
+	Workers int
+	// Unordered calls the walk function from the workers, concurrently and
+	// in no particular order, except that a directory comes before its
+	// entries. The function must then be safe for concurrent use.
+	Unordered bool
+	// FollowSymlinks walks the directories symbolic links point to, which
+	// are reported as directories under the name of the link. A link to a
+	// directory it is in is reported as a link and not followed.
+	FollowSymlinks bool
+}
+
+// ParallelWalk walks the file tree rooted at root like WalkDir, but reads
+// several directories at the same time, which hides the latency of remote
+// filesystems. SkipDir and SkipAll work as they do for WalkDir, and nothing
+// is left reading when ParallelWalk returns. A nil opts stands for the zero
+// ParallelWalkOptions.
+//
+// In order, fn is called from the calling goroutine, and the directories
+// coming next below the one walked are read ahead, so a directory may have
+// been read by the time fn skips it. Unordered, a directory is only read
+// once fn has accepted it.
+func ParallelWalk(fsys Fs, root string, fn fs.WalkDirFunc, opts *ParallelWalkOptions) error {
+	if opts == nil {
+		opts = &ParallelWalkOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	w := &parallelWalker{fs: fsys, fn: fn, follow: opts.FollowSymlinks, workers: workers}
+	defer w.fetching.Wait()
+
+	var info os.FileInfo
+	var err error
+	if opts.FollowSymlinks {
+		info, err = fsys.Stat(root)
+	} else {
+		info, err = lstatIfPossible(fsys, root)
+	}
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		entry := walkEntry{DirEntry: dirEntry{info}}
+		if info.IsDir() {
+			entry.dir = &walkedDir{info: info, real: filepath.Clean(root)}
+		}
+		if opts.Unordered {
+			err = w.walkUnordered(root, entry)
+		} else {
+			w.reads = make(chan struct{}, workers)
+			err = w.walkOrdered(root, entry, nil)
+		}
+	}
+	if err == filepath.SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// parallelWalker holds the state of a single ParallelWalk.
+type parallelWalker struct {
+	fs      Fs
+	fn      fs.WalkDirFunc
+	follow  bool
+	workers int
+
+	// reads bounds the directories read ahead at once by an ordered walk,
+	// and fetching tracks the goroutines reading them.
+	reads    chan struct{}
+	fetching sync.WaitGroup
+
+	// mu guards the rest, used by the unordered walk: the directories
+	// accepted but not read yet, how many workers are busy with one, and
+	// the error the walk stopped at. ready is signalled when one of them
+	// changes.
+	mu    sync.Mutex
+	ready *sync.Cond
+	queue []walkedEntry
+	busy  int
+	err   error
+}
+
+// walkedEntry is a directory of an unordered walk waiting for a worker.
+type walkedEntry struct {
+	path  string
+	entry walkEntry
+}
+
+// walkedDir is a directory on the way from the root to an entry, against
+// which links are checked for loops. real is its path with the links
+// leading to it resolved, as far as the filesystem tells.
+type walkedDir struct {
+	parent *walkedDir
+	info   os.FileInfo
+	real   string
+}
+
+// walkEntry is an entry of a directory, with dir set if the walk descends
+// into it.
+type walkEntry struct {
+	fs.DirEntry
+	dir *walkedDir
+}
+
+// listing holds the entries of a directory, or why they couldn't be read.
+type listing struct {
+	entries []walkEntry
+	err     error
+}
+
+// namedInfo gives the directory a link points to the name of the link.
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string { return i.name }
+
+// list reads the entries of the directory path, and resolves the links
+// among them to the directories they point to if they are followed.
+func (w *parallelWalker) list(path string, dir *walkedDir) listing {
+	entries, err := readDirEntries(w.fs, path)
+	if err != nil {
+		return listing{err: err}
+	}
+	l := listing{entries: make([]walkEntry, len(entries))}
+	for i, entry := range entries {
+		real := filepath.Join(dir.real, entry.Name())
+		switch {
+		case entry.IsDir():
+			info, _ := entry.Info()
+			l.entries[i] = walkEntry{entry, &walkedDir{dir, info, real}}
+		case w.follow && entry.Type()&fs.ModeSymlink != 0:
+			l.entries[i] = w.resolve(filepath.Join(path, entry.Name()), real, entry, dir)
+		default:
+			l.entries[i] = walkEntry{DirEntry: entry}
+		}
+	}
+	return l
+}
+
+// resolve returns the entry for the link name in dir, as the directory it
+// points to unless it doesn't, or that directory is dir or above it.
+func (w *parallelWalker) resolve(name, real string, entry fs.DirEntry, dir *walkedDir) walkEntry {
+	info, err := w.fs.Stat(name)
+	if err != nil || !info.IsDir() {
+		return walkEntry{DirEntry: entry}
+	}
+	if target, err := readlinkIfPossible(w.fs, name); err == nil {
+		if filepath.IsAbs(target) {
+			real = filepath.Clean(target)
+		} else {
+			real = filepath.Join(dir.real, target)
+		}
+	}
+	for d := dir; d != nil; d = d.parent {
+		if d.real == real || sameFile(d.info, info) {
+			return walkEntry{DirEntry: entry}
+		}
+	}
+	return walkEntry{dirEntry{namedInfo{info, entry.Name()}}, &walkedDir{dir, info, real}}
+}
+
+// sameFile reports whether a and b describe the same file, where that can
+// be told.
+func sameFile(a, b os.FileInfo) bool {
+	if ma, ok := a.(*mem.FileInfo); ok {
+		mb, ok := b.(*mem.FileInfo)
+		return ok && ma.FileData == mb.FileData
+	}
+	return os.SameFile(a, b)
+}
+
+// fetched is the listing of a directory read ahead, ready once done is
+// closed.
+type fetched struct {
+	done chan struct{}
+	l    listing
+}
+
+// fetch starts reading the directory path ahead of an ordered walk.
+func (w *parallelWalker) fetch(path string, dir *walkedDir) *fetched {
+	f := &fetched{done: make(chan struct{})}
+	w.fetching.Add(1)
+	go func() {
+		defer w.fetching.Done()
+		w.reads <- struct{}{}
+		f.l = w.list(path, dir)
+		<-w.reads
+		close(f.done)
+	}()
+	return f
+}
+
+// walkOrdered calls fn for path, described by entry, and then for what is
+// below it in lexical order. The listing of path is taken from ahead if it
+// was read ahead. While the entries are walked, the listings of the next
+// directories among them are read ahead, as many as there are workers.
+func (w *parallelWalker) walkOrdered(path string, entry walkEntry, ahead *fetched) error {
+	if err := w.fn(path, entry.DirEntry, nil); err != nil || entry.dir == nil {
+		if err == filepath.SkipDir && entry.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	var l listing
+	if ahead != nil {
+		<-ahead.done
+		l = ahead.l
+	} else {
+		l = w.list(path, entry.dir)
+	}
+	if l.err != nil {
+		err := w.fn(path, entry.DirEntry, l.err)
+		if err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	aheads := make([]*fetched, len(l.entries))
+	next, pending := 0, 0
+	for i, e := range l.entries {
+		for ; next < len(l.entries) && pending < w.workers; next++ {
+			if d := l.entries[next]; d.dir != nil {
+				aheads[next] = w.fetch(filepath.Join(path, d.Name()), d.dir)
+				pending++
+			}
+		}
+		if aheads[i] != nil {
+			pending--
+		}
+		if err := w.walkOrdered(filepath.Join(path, e.Name()), e, aheads[i]); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// walkUnordered calls fn for root, described by entry, and then for what
+// is below it, from as many goroutines as there are workers, and waits
+// for all of them to be done.
+func (w *parallelWalker) walkUnordered(root string, entry walkEntry) error {
+	if err := w.fn(root, entry.DirEntry, nil); err != nil || entry.dir == nil {
+		return err
+	}
+	w.ready = sync.NewCond(&w.mu)
+	w.queue = []walkedEntry{{root, entry}}
+	var wg sync.WaitGroup
+	wg.Add(w.workers)
+	for i := 0; i < w.workers; i++ {
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+	return w.err
+}
+
+// work walks the queued directories until there are none left and no
+// other worker may queue more, or the walk stops.
+func (w *parallelWalker) work() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for len(w.queue) == 0 && w.busy > 0 && w.err == nil {
+			w.ready.Wait()
+		}
+		if len(w.queue) == 0 || w.err != nil {
+			w.ready.Broadcast()
+			return
+		}
+		d := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.busy++
+		w.mu.Unlock()
+		w.walkDirUnordered(d.path, d.entry)
+		w.mu.Lock()
+		w.busy--
+		if w.busy == 0 {
+			w.ready.Broadcast()
+		}
+	}
+}
+
+// walkDirUnordered calls fn for the entries of the directory path, and
+// queues those which are directories fn accepted.
+func (w *parallelWalker) walkDirUnordered(path string, entry walkEntry) {
+	l := w.list(path, entry.dir)
+	if l.err != nil {
+		if err := w.fn(path, entry.DirEntry, l.err); err != nil && err != filepath.SkipDir {
+			w.stop(err)
+		}
+		return
+	}
+	for _, e := range l.entries {
+		if w.stopped() {
+			return
+		}
+		name := filepath.Join(path, e.Name())
+		err := w.fn(name, e.DirEntry, nil)
+		if err == filepath.SkipDir {
+			if e.dir != nil {
+				continue
+			}
+			return
+		}
+		if err != nil {
+			w.stop(err)
+			return
+		}
+		if e.dir != nil {
+			w.mu.Lock()
+			w.queue = append(w.queue, walkedEntry{name, e})
+			w.ready.Signal()
+			w.mu.Unlock()
+		}
+	}
+}
+
+// stop ends the unordered walk with err, unless it has already ended.
+func (w *parallelWalker) stop(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+		w.ready.Broadcast()
+	}
+	w.mu.Unlock()
+}
+
+func (w *parallelWalker) stopped() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
+}
@@ -0,0 +1,221 @@
+// +build go1.16
+
+package afero
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelWalk(t *testing.T) {
+	mfs := walkFixture(t)
+	var expected []string
+	WalkDir(mfs, "/w", func(path string, d fs.DirEntry, err error) error {
+		expected = append(expected, path)
+		return err
+	})
+
+	var got []string
+	err := ParallelWalk(mfs, "/w", func(path string, d fs.DirEntry, err error) error {
+		got = append(got, path)
+		return err
+	}, &ParallelWalkOptions{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	var mu sync.Mutex
+	got = nil
+	err = ParallelWalk(mfs, "/w", func(path string, d fs.DirEntry, err error) error {
+		mu.Lock()
+		got = append(got, path)
+		mu.Unlock()
+		if d.Name() == "skip" {
+			return filepath.SkipDir
+		}
+		return err
+	}, &ParallelWalkOptions{Workers: 3, Unordered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	expected = expected[:len(expected)-1]
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unordered: got %v, expected %v", got, expected)
+	}
+}
+
+func TestParallelWalkStop(t *testing.T) {
+	mfs := walkFixture(t)
+	stop := errors.New("stop")
+	for _, unordered := range []bool{false, true} {
+		opts := &ParallelWalkOptions{Unordered: unordered}
+		err := ParallelWalk(mfs, "/w", func(path string, d fs.DirEntry, err error) error {
+			if d.Name() == "z" {
+				return stop
+			}
+			return nil
+		}, opts)
+		if err != stop {
+			t.Errorf("unordered %v: got %v, expected %v", unordered, err, stop)
+		}
+		err = ParallelWalk(mfs, "/w", func(path string, d fs.DirEntry, err error) error {
+			return SkipAll
+		}, opts)
+		if err != nil {
+			t.Errorf("unordered %v: got %v for SkipAll", unordered, err)
+		}
+	}
+}
+
+// openRecordFs records the names opened.
+type openRecordFs struct {
+	*MemMapFs
+	mu     sync.Mutex
+	opened []string
+}
+
+func (fs *openRecordFs) Open(name string) (File, error) {
+	fs.mu.Lock()
+	fs.opened = append(fs.opened, name)
+	fs.mu.Unlock()
+	return fs.MemMapFs.Open(name)
+}
+
+func TestParallelWalkSkipDirReads(t *testing.T) {
+	for _, unordered := range []bool{false, true} {
+		rfs := &openRecordFs{MemMapFs: walkFixture(t)}
+		err := ParallelWalk(rfs, "/w", func(path string, d fs.DirEntry, err error) error {
+			if d.Name() == "a" || d.Name() == "skip" {
+				return filepath.SkipDir
+			}
+			return err
+		}, &ParallelWalkOptions{Workers: 2, Unordered: unordered})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(rfs.opened)
+		expected := []string{"/w", "/w/b"}
+		if !unordered {
+			// the directories skipped are read ahead, but not what is in
+			// them
+			expected = []string{"/w", "/w/a", "/w/b", "/w/skip"}
+		}
+		if !reflect.DeepEqual(rfs.opened, expected) {
+			t.Errorf("unordered %v: read %v, expected %v", unordered, rfs.opened, expected)
+		}
+	}
+}
+
+// barrierFs holds the opens of the directories below /w until n of them
+// are waiting.
+type barrierFs struct {
+	*MemMapFs
+	n       int
+	mu      sync.Mutex
+	waiting int
+	all     chan struct{}
+}
+
+func (fs *barrierFs) Open(name string) (File, error) {
+	if filepath.Dir(name) == "/w" {
+		fs.mu.Lock()
+		if fs.waiting++; fs.waiting == fs.n {
+			close(fs.all)
+		}
+		fs.mu.Unlock()
+		select {
+		case <-fs.all:
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("the directories weren't read at the same time")
+		}
+	}
+	return fs.MemMapFs.Open(name)
+}
+
+func TestParallelWalkOrderedReadsAhead(t *testing.T) {
+	bfs := &barrierFs{MemMapFs: walkFixture(t), n: 3, all: make(chan struct{})}
+	var got []string
+	err := ParallelWalk(bfs, "/w", func(path string, d fs.DirEntry, err error) error {
+		got = append(got, path)
+		return err
+	}, &ParallelWalkOptions{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	WalkDir(walkFixture(t), "/w", func(path string, d fs.DirEntry, err error) error {
+		expected = append(expected, path)
+		return err
+	})
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestParallelWalkSymlinks(t *testing.T) {
+	mfs := walkFixture(t)
+	WriteFile(mfs, "/other/o", []byte("o"), 0644)
+	mfs.SymlinkIfPossible("/other", "/w/b/out")
+	mfs.SymlinkIfPossible("..", "/w/a/up")
+	mfs.SymlinkIfPossible("/w/a", "/w/a/z/self")
+	testParallelWalkSymlinks(t, mfs, "/w")
+
+	dir, err := TempDir(NewOsFs(), "", "afero-walk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	osfs := NewBasePathFs(NewOsFs(), dir)
+	for _, name := range []string{"/w/a/y", "/w/b/x", "/other/o"} {
+		WriteFile(osfs, name, []byte(name), 0644)
+	}
+	if err := os.Symlink(filepath.Join(dir, "other"), filepath.Join(dir, "w", "b", "out")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	os.Symlink("..", filepath.Join(dir, "w", "a", "up"))
+	testParallelWalkSymlinks(t, NewOsFs(), filepath.Join(dir, "w"))
+}
+
+func testParallelWalkSymlinks(t *testing.T, fsys Fs, root string) {
+	t.Helper()
+	walked := map[string]bool{}
+	err := ParallelWalk(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		walked[filepath.ToSlash(rel)] = d.IsDir()
+		return nil
+	}, &ParallelWalkOptions{FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isDir, ok := walked["b/out"]; !ok || !isDir {
+		t.Errorf("b/out: expected the link to be followed, got %v", walked)
+	}
+	if _, ok := walked["b/out/o"]; !ok {
+		t.Errorf("b/out/o: expected to be walked, got %v", walked)
+	}
+	if isDir, ok := walked["a/up"]; !ok || isDir {
+		t.Errorf("a/up: expected the loop not to be followed, got %v", walked)
+	}
+	for name := range walked {
+		if filepath.Base(name) == "up" && name != "a/up" {
+			t.Errorf("walked %s through a loop", name)
+		}
+	}
+	if isDir := walked["a/z/self"]; isDir {
+		t.Errorf("a/z/self: expected the loop not to be followed")
+	}
+}
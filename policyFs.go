@@ -0,0 +1,469 @@
+package afero
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	_ Lstater    = (*PolicyFs)(nil)
+	_ Symlinker  = (*PolicyFs)(nil)
+	_ LinkReader = (*PolicyFs)(nil)
+)
+
+// Access is a set of operations allowed by a PolicyRule.
+type Access uint
+
+const (
+	// AccessRead allows Open, Stat, Lstat, Readlink and reading files.
+	AccessRead Access = 1 << iota
+	// AccessWrite allows writing and truncating existing files.
+	AccessWrite
+	// AccessCreate allows creating files, directories and links.
+	AccessCreate
+	// AccessDelete allows Remove and RemoveAll.
+	AccessDelete
+	// AccessChmod allows Chmod, Chown and Chtimes.
+	AccessChmod
+	// AccessRename allows renaming from and to the paths.
+	AccessRename
+
+	// AccessNone allows nothing.
+	AccessNone Access = 0
+	// AccessAll allows every operation.
+	AccessAll = AccessRead | AccessWrite | AccessCreate | AccessDelete | AccessChmod | AccessRename
+)
+
+var accessNames = []string{"read", "write", "create", "delete", "chmod", "rename"}
+
+func (a Access) String() string {
+	if a == AccessNone {
+		return "none"
+	}
+	var names []string
+	for i, name := range accessNames {
+		if a&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// PolicyRule grants Access to the paths matching Pattern.
+type PolicyRule struct {
+	// Pattern is a path such as "/config", matching it and everything
+	// below it, or a pattern in the syntax of GlobWithOptions if it has
+	// wildcards, whose wildcards match hidden files as well.
+	Pattern string
+	Access  Access
+	// Hidden makes the matching paths not exist rather than denied.
+	Hidden bool
+}
+
+// PolicyDecision is a check made by a PolicyFs, passed to its audit
+// function.
+type PolicyDecision struct {
+	// Op is the operation, as named by os.PathError.
+	Op   string
+	Path string
+	// Access is what the operation needs.
+	Access  Access
+	Allowed bool
+}
+
+// The PolicyFs applies access rules to the paths of its source. The first
+// rule matching a path decides what may be done with it; paths matching no
+// rule are denied everything. Denied reads fail with EACCES and other
+// denied operations with EPERM, in an *os.PathError. Hidden paths don't
+// exist and are left out of listings.
+//
+// Removing or renaming a directory needs the access to everything below it,
+// and renaming onto an existing entry AccessDelete on it as well.
+// Symbolic links anywhere on a path are resolved, and the rules apply to
+// where they lead; following a link takes reading it. A link can only be
+// created to somewhere readable.
+type PolicyFs struct {
+	source Fs
+	rules  []policyRule
+	audit  func(PolicyDecision)
+}
+
+// policyRule is a PolicyRule ready to match paths: by prefix if patterns is
+// nil.
+type policyRule struct {
+	PolicyRule
+	prefix   string
+	patterns []globPattern
+}
+
+// NewPolicyFs returns a PolicyFs applying rules to source, and passing
+// every decision to audit if not nil. It fails with ErrBadPattern if a
+// pattern is malformed.
+func NewPolicyFs(source Fs, rules []PolicyRule, audit func(PolicyDecision)) (*PolicyFs, error) {
+	p := &PolicyFs{source: source, audit: audit}
+	for _, rule := range rules {
+		compiled := policyRule{PolicyRule: rule}
+		if !strings.ContainsAny(rule.Pattern, "*?[{") {
+			compiled.prefix = policyPath(rule.Pattern)
+		} else {
+			patterns, err := compilePathPattern(rule.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			compiled.patterns = patterns
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+// compilePathPattern compiles pattern, in the syntax of GlobWithOptions, to
+// match the names returned by policyNames, wildcards matching hidden files
+// as well.
+func compilePathPattern(pattern string) ([]globPattern, error) {
+	expanded, err := expandBraces(filepath.ToSlash(pattern))
+	if err != nil {
+		return nil, err
+	}
+	var patterns []globPattern
+	for _, pattern := range expanded {
+		segs, err := compileGlobSegments(policyNames(pattern), ignoreGlobOptions)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, segs)
+	}
+	return patterns, nil
+}
+
+// policyPath cleans name and makes it absolute, with slashes.
+func policyPath(name string) string {
+	return filepath.ToSlash(filepath.Join(FilePathSeparator, name))
+}
+
+// policyNames splits name into the names of its path from the root.
+func policyNames(name string) []string {
+	name = policyPath(name)
+	if name == "/" {
+		return nil
+	}
+	return strings.Split(name[1:], "/")
+}
+
+// rule returns the first rule matching name, or nil.
+func (p *PolicyFs) rule(name string) *policyRule {
+	path := policyPath(name)
+	var names []string
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if rule.patterns == nil {
+			if path == rule.prefix || rule.prefix == "/" || strings.HasPrefix(path, rule.prefix+"/") {
+				return rule
+			}
+			continue
+		}
+		if names == nil {
+			names = policyNames(name)
+		}
+		if globMatch(rule.patterns, names, ignoreGlobOptions) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// hidden reports whether name is hidden by its rule.
+func (p *PolicyFs) hidden(name string, _ os.FileInfo) bool {
+	rule := p.rule(name)
+	return rule != nil && rule.Hidden
+}
+
+// decide returns the error for op on name if the rules don't grant need,
+// and audits the decision.
+func (p *PolicyFs) decide(op, name string, need Access) error {
+	rule := p.rule(name)
+	var err error
+	switch {
+	case rule != nil && rule.Hidden:
+		err = syscall.ENOENT
+	case rule != nil && rule.Access&need == need:
+	case need == AccessRead:
+		err = syscall.EACCES
+	default:
+		err = syscall.EPERM
+	}
+	if p.audit != nil {
+		p.audit(PolicyDecision{Op: op, Path: name, Access: need, Allowed: err == nil})
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// resolve returns the path of name with every symbolic link on the way
+// replaced by its target, the last element only if followLast is set, so
+// the rules apply to what is actually reached. Reading every link followed
+// must be allowed.
+func (p *PolicyFs) resolve(op, name string, followLast bool) (string, error) {
+	reader, ok := p.source.(LinkReader)
+	if !ok {
+		return policyPath(name), nil
+	}
+	names := policyNames(name)
+	resolved := "/"
+	for hops := 0; len(names) > 0; {
+		next := path.Join(resolved, names[0])
+		names = names[1:]
+		if len(names) == 0 && !followLast {
+			return next, nil
+		}
+		target, err := reader.ReadlinkIfPossible(filepath.FromSlash(next))
+		if err != nil {
+			resolved = next
+			continue
+		}
+		if hops++; hops > 40 {
+			return "", &os.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+		if err := p.decide(op, next, AccessRead); err != nil {
+			err.(*os.PathError).Path = name
+			return "", err
+		}
+		target = filepath.ToSlash(target)
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+		names = append(policyNames(target), names...)
+		resolved = "/"
+	}
+	return resolved, nil
+}
+
+// check is decide for what name resolves to, following it if it is a
+// symbolic link. It returns the resolved path.
+func (p *PolicyFs) check(op, name string, need Access) (string, error) {
+	return p.checkPath(op, name, need, true)
+}
+
+// checkEntry is check for name itself, rather than what it points to.
+func (p *PolicyFs) checkEntry(op, name string, need Access) (string, error) {
+	return p.checkPath(op, name, need, false)
+}
+
+func (p *PolicyFs) checkPath(op, name string, need Access, followLast bool) (string, error) {
+	resolved, err := p.resolve(op, name, followLast)
+	if err != nil {
+		return "", err
+	}
+	if err := p.decide(op, resolved, need); err != nil {
+		err.(*os.PathError).Path = name
+		return "", err
+	}
+	return resolved, nil
+}
+
+// checkTree is checkEntry for name and everything below it.
+func (p *PolicyFs) checkTree(op, name string, need Access) error {
+	resolved, err := p.checkEntry(op, name, need)
+	if err != nil {
+		return err
+	}
+	err = Walk(p.source, name, func(walked string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(name, walked)
+		if err != nil || rel == "." {
+			return err
+		}
+		if err := p.decide(op, path.Join(resolved, filepath.ToSlash(rel)), need); err != nil {
+			return &os.PathError{Op: op, Path: name, Err: err.(*os.PathError).Err}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// exists reports whether name exists in the source.
+func (p *PolicyFs) exists(name string) bool {
+	_, err := lstatIfPossible(p.source, name)
+	return err == nil
+}
+
+func (p *PolicyFs) Name() string {
+	return "PolicyFs"
+}
+
+func (p *PolicyFs) Create(name string) (File, error) {
+	need := AccessCreate
+	if p.exists(name) {
+		need = AccessWrite
+	}
+	if _, err := p.check("create", name, need); err != nil {
+		return nil, err
+	}
+	return p.source.Create(name)
+}
+
+func (p *PolicyFs) Mkdir(name string, perm os.FileMode) error {
+	if _, err := p.checkEntry("mkdir", name, AccessCreate); err != nil {
+		return err
+	}
+	return p.source.Mkdir(name, perm)
+}
+
+// MkdirAll needs AccessCreate on path and on every parent it creates.
+func (p *PolicyFs) MkdirAll(name string, perm os.FileMode) error {
+	names := policyNames(name)
+	dir := "/"
+	for i, elem := range names {
+		dir = path.Join(dir, elem)
+		if i < len(names)-1 && p.exists(filepath.FromSlash(dir)) {
+			continue
+		}
+		if _, err := p.checkEntry("mkdir", filepath.FromSlash(dir), AccessCreate); err != nil {
+			return err
+		}
+	}
+	return p.source.MkdirAll(name, perm)
+}
+
+func (p *PolicyFs) Open(name string) (File, error) {
+	resolved, err := p.check("open", name, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &filterFile{File: f, name: resolved, hide: p.hidden}, nil
+}
+
+func (p *PolicyFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	var need Access
+	if flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY {
+		need |= AccessRead
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0 {
+		need |= AccessWrite
+	}
+	if flag&os.O_CREATE != 0 && !p.exists(name) {
+		need = need&^AccessWrite | AccessCreate
+	}
+	resolved, err := p.check("open", name, need)
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.source.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &filterFile{File: f, name: resolved, hide: p.hidden}, nil
+}
+
+func (p *PolicyFs) Remove(name string) error {
+	if _, err := p.checkEntry("remove", name, AccessDelete); err != nil {
+		return err
+	}
+	return p.source.Remove(name)
+}
+
+func (p *PolicyFs) RemoveAll(path string) error {
+	if err := p.checkTree("removeall", path, AccessDelete); err != nil {
+		return err
+	}
+	return p.source.RemoveAll(path)
+}
+
+func (p *PolicyFs) Rename(oldname, newname string) error {
+	if err := p.checkTree("rename", oldname, AccessRename); err != nil {
+		return err
+	}
+	need := AccessRename
+	if p.exists(newname) {
+		// the entry replaced is deleted
+		need |= AccessDelete
+	}
+	if _, err := p.checkEntry("rename", newname, need); err != nil {
+		return err
+	}
+	return p.source.Rename(oldname, newname)
+}
+
+func (p *PolicyFs) Stat(name string) (os.FileInfo, error) {
+	if _, err := p.check("stat", name, AccessRead); err != nil {
+		return nil, err
+	}
+	return p.source.Stat(name)
+}
+
+func (p *PolicyFs) Chmod(name string, mode os.FileMode) error {
+	if _, err := p.check("chmod", name, AccessChmod); err != nil {
+		return err
+	}
+	return p.source.Chmod(name, mode)
+}
+
+func (p *PolicyFs) Chown(name string, uid, gid int) error {
+	if _, err := p.check("chown", name, AccessChmod); err != nil {
+		return err
+	}
+	return p.source.Chown(name, uid, gid)
+}
+
+func (p *PolicyFs) Chtimes(name string, atime, mtime time.Time) error {
+	if _, err := p.check("chtimes", name, AccessChmod); err != nil {
+		return err
+	}
+	return p.source.Chtimes(name, atime, mtime)
+}
+
+func (p *PolicyFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if _, err := p.checkEntry("lstat", name, AccessRead); err != nil {
+		return nil, false, err
+	}
+	if lsf, ok := p.source.(Lstater); ok {
+		return lsf.LstatIfPossible(name)
+	}
+	fi, err := p.source.Stat(name)
+	return fi, false, err
+}
+
+func (p *PolicyFs) SymlinkIfPossible(oldname, newname string) error {
+	resolved, err := p.checkEntry("symlink", newname, AccessCreate)
+	if err == nil {
+		// The link mustn't lead anywhere which couldn't be read through
+		// its own path.
+		target := filepath.ToSlash(oldname)
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(resolved), target)
+		}
+		_, err = p.check("symlink", target, AccessRead)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err.(*os.PathError).Err}
+	}
+	if linker, ok := p.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (p *PolicyFs) ReadlinkIfPossible(name string) (string, error) {
+	if _, err := p.checkEntry("readlink", name, AccessRead); err != nil {
+		return "", err
+	}
+	if reader, ok := p.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
@@ -0,0 +1,212 @@
+package afero
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func policyFixture(t *testing.T) (*MemMapFs, *PolicyFs, *[]PolicyDecision) {
+	t.Helper()
+	source := &MemMapFs{}
+	for _, name := range []string{"/config/app.yml", "/cache/old", "/secrets/token", "/other/file", "/cache/keys/a.key"} {
+		if err := WriteFile(source, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var decisions []PolicyDecision
+	fs, err := NewPolicyFs(source, []PolicyRule{
+		{Pattern: "/config", Access: AccessRead},
+		{Pattern: "/cache/**/*.key", Access: AccessRead},
+		{Pattern: "/cache", Access: AccessAll},
+		{Pattern: "/secrets", Hidden: true},
+		{Pattern: "/", Access: AccessNone},
+	}, func(d PolicyDecision) {
+		decisions = append(decisions, d)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source, fs, &decisions
+}
+
+func errno(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	}
+	return err
+}
+
+func TestPolicyFs(t *testing.T) {
+	source, fs, decisions := policyFixture(t)
+
+	checkFile(t, fs, "/config/app.yml", "/config/app.yml")
+	if err := WriteFile(fs, "/config/app.yml", nil, 0644); errno(err) != syscall.EPERM {
+		t.Errorf("writing config: got %v, expected EPERM", err)
+	}
+	if err := fs.Remove("/config/app.yml"); errno(err) != syscall.EPERM {
+		t.Errorf("removing config: got %v, expected EPERM", err)
+	}
+
+	if err := WriteFile(fs, "/cache/new", []byte("new"), 0644); err != nil {
+		t.Errorf("writing to the cache: %v", err)
+	}
+	if err := fs.Rename("/cache/new", "/cache/renamed"); err != nil {
+		t.Errorf("renaming in the cache: %v", err)
+	}
+	if err := fs.Rename("/cache/renamed", "/config/renamed"); errno(err) != syscall.EPERM {
+		t.Errorf("renaming to config: got %v, expected EPERM", err)
+	}
+	if err := fs.RemoveAll("/cache"); errno(err) != syscall.EPERM {
+		t.Errorf("removing the cache with keys: got %v, expected EPERM", err)
+	}
+	checkFile(t, source, "/cache/keys/a.key", "/cache/keys/a.key")
+	if err := fs.Chmod("/cache/keys/a.key", 0600); errno(err) != syscall.EPERM {
+		t.Errorf("chmod of a key: got %v, expected EPERM", err)
+	}
+
+	if _, err := fs.Stat("/secrets/token"); errno(err) != syscall.ENOENT {
+		t.Errorf("secrets: got %v, expected ENOENT", err)
+	}
+	if _, err := fs.Create("/secrets/new"); errno(err) != syscall.ENOENT {
+		t.Errorf("creating a secret: got %v, expected ENOENT", err)
+	}
+	if _, err := fs.Open("/other/file"); errno(err) != syscall.EACCES {
+		t.Errorf("other: got %v, expected EACCES", err)
+	}
+	if err := fs.Mkdir("/other/dir", 0755); errno(err) != syscall.EPERM {
+		t.Errorf("mkdir: got %v, expected EPERM", err)
+	}
+
+	*decisions = nil
+	if _, err := fs.Stat("/other"); err == nil {
+		t.Error("expected stat to fail")
+	}
+	expected := []PolicyDecision{{Op: "stat", Path: "/other", Access: AccessRead, Allowed: false}}
+	if !reflect.DeepEqual(*decisions, expected) {
+		t.Errorf("audit: got %v, expected %v", *decisions, expected)
+	}
+}
+
+func TestPolicyFsParentsAndReplacing(t *testing.T) {
+	source, _, _ := policyFixture(t)
+	fs, err := NewPolicyFs(source, []PolicyRule{
+		{Pattern: "/**/cache", Access: AccessAll},
+		{Pattern: "/config/*", Access: AccessRead | AccessRename},
+		{Pattern: "/", Access: AccessNone},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.MkdirAll("/denied/x/cache", 0755); errno(err) != syscall.EPERM {
+		t.Errorf("MkdirAll below a denied parent: got %v, expected EPERM", err)
+	}
+	if _, err := source.Stat("/denied"); !os.IsNotExist(err) {
+		t.Errorf("the denied parent was created: %v", err)
+	}
+	if err := fs.MkdirAll("/cache/sub/cache", 0755); errno(err) != syscall.EPERM {
+		t.Errorf("MkdirAll of a denied parent below an allowed one: got %v, expected EPERM", err)
+	}
+	if err := fs.MkdirAll("/cache", 0755); err != nil {
+		t.Errorf("MkdirAll of an existing allowed directory: %v", err)
+	}
+
+	if err := WriteFile(source, "/config/b.yml", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/config/b.yml", "/config/app.yml"); errno(err) != syscall.EPERM {
+		t.Errorf("renaming onto an entry which can't be deleted: got %v, expected EPERM", err)
+	}
+	checkFile(t, source, "/config/app.yml", "/config/app.yml")
+	if err := fs.Rename("/config/b.yml", "/config/c.yml"); err != nil {
+		t.Errorf("renaming to a new name: %v", err)
+	}
+}
+
+func TestPolicyFsListing(t *testing.T) {
+	source, _, _ := policyFixture(t)
+	fs, err := NewPolicyFs(source, []PolicyRule{
+		{Pattern: "/secrets", Hidden: true},
+		{Pattern: "/{config,other}/*", Hidden: true},
+		{Pattern: "/", Access: AccessRead},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := readDirNames(fs, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"cache", "config", "other"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("got %v, expected %v", names, expected)
+	}
+	if names, _ := readDirNames(fs, "/config"); len(names) != 0 {
+		t.Errorf("got %v, expected nothing visible", names)
+	}
+}
+
+func TestPolicyFsSymlinks(t *testing.T) {
+	source, fs, _ := policyFixture(t)
+	source.SymlinkIfPossible("/secrets/token", "/cache/token")
+	source.SymlinkIfPossible("/config/app.yml", "/cache/app.yml")
+	if _, err := ReadFile(fs, "/cache/token"); errno(err) != syscall.ENOENT {
+		t.Errorf("link to a secret: got %v, expected ENOENT", err)
+	}
+	checkFile(t, fs, "/cache/app.yml", "/config/app.yml")
+	if err := WriteFile(fs, "/cache/app.yml", nil, 0644); errno(err) != syscall.EPERM {
+		t.Errorf("writing through a link: got %v, expected EPERM", err)
+	}
+	if err := fs.Remove("/cache/app.yml"); err != nil {
+		t.Errorf("removing the link: %v", err)
+	}
+	checkFile(t, source, "/config/app.yml", "/config/app.yml")
+}
+
+func TestPolicyFsDirectorySymlinks(t *testing.T) {
+	source, fs, _ := policyFixture(t)
+	if err := fs.SymlinkIfPossible("/secrets", "/cache/x"); errno(err) != syscall.ENOENT {
+		t.Errorf("linking to the secrets: got %v, expected ENOENT", err)
+	}
+	if err := fs.SymlinkIfPossible("../config", "/cache/conf"); err != nil {
+		t.Errorf("linking to the config: %v", err)
+	}
+
+	// Links made behind its back don't lead out of the rules either.
+	source.SymlinkIfPossible("/secrets", "/cache/x")
+	if _, err := ReadFile(fs, "/cache/x/token"); errno(err) != syscall.ENOENT {
+		t.Errorf("reading through a directory link: got %v, expected ENOENT", err)
+	}
+	if err := WriteFile(fs, "/cache/x/new", nil, 0644); errno(err) != syscall.ENOENT {
+		t.Errorf("writing through a directory link: got %v, expected ENOENT", err)
+	}
+	checkMissing(t, source, "/secrets/new")
+
+	checkFile(t, fs, "/cache/conf/app.yml", "/config/app.yml")
+	if err := WriteFile(fs, "/cache/conf/app.yml", nil, 0644); errno(err) != syscall.EPERM {
+		t.Errorf("writing the config through a directory link: got %v, expected EPERM", err)
+	}
+	if err := fs.Remove("/cache/x"); err != nil {
+		t.Errorf("removing the link: %v", err)
+	}
+	checkFile(t, source, "/secrets/token", "/secrets/token")
+}
+
+func TestNewPolicyFsBadPattern(t *testing.T) {
+	if _, err := NewPolicyFs(&MemMapFs{}, []PolicyRule{{Pattern: "/a/[", Access: AccessAll}}, nil); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestAccessString(t *testing.T) {
+	if s := (AccessRead | AccessRename).String(); s != "read|rename" {
+		t.Errorf("got %s", s)
+	}
+	if s := AccessNone.String(); s != "none" {
+		t.Errorf("got %s", s)
+	}
+}
@@ -0,0 +1,260 @@
+package afero
+
+import (
+	"hash/fnv"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ Watcher = (*PollingWatcher)(nil)
+
+// PollingWatcher watches any Fs by walking the tree below root periodically
+// and comparing the size, modification time and mode of every entry with the
+// previous walk. It is meant for filesystems which can't report changes
+// themselves, such as remote ones.
+//
+// A file which disappears while another one with the same size, modification
+// time and mode appears is taken as renamed. Only a small fixed record is
+// kept per entry, so the memory needed grows with the number of entries but
+// not with their sizes. The events not read yet are bounded as for every
+// Watcher: a watch falling behind gets Overflow.
+type PollingWatcher struct {
+	// HashContents makes the watcher compare a hash of the contents of
+	// files as well, which catches writes that keep the size and the
+	// modification time. The contents are streamed, but every file is read
+	// on every poll. It must be set before the first call to Watch.
+	HashContents bool
+
+	fs       Fs
+	root     string
+	interval time.Duration
+	watches  watches
+
+	startMu sync.Mutex
+	started bool
+	stop    chan struct{}
+
+	mu    sync.Mutex // serializes polls
+	state map[string]pollEntry
+}
+
+// pollEntry is what a PollingWatcher remembers about an entry.
+type pollEntry struct {
+	size  int64
+	mtime int64
+	mode  os.FileMode
+	sum   uint64
+}
+
+func newPollEntry(info os.FileInfo) pollEntry {
+	return pollEntry{size: info.Size(), mtime: info.ModTime().UnixNano(), mode: info.Mode()}
+}
+
+// NewPollingWatcher returns a watcher for the tree below root in fs, which
+// is walked every interval. The interval is varied by up to a tenth at
+// random, so that many watchers don't poll a remote service in lockstep.
+// Polling starts with the first call to Watch and ends with Close.
+func NewPollingWatcher(fs Fs, root string, interval time.Duration) *PollingWatcher {
+	return &PollingWatcher{
+		fs:       fs,
+		root:     filepath.Clean(root),
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Watch reports the changes to path, which must exist below the root of the
+// watcher. The first call walks the tree once before it returns, to know
+// what changes later.
+func (p *PollingWatcher) Watch(path string, recursive bool) (<-chan Event, error) {
+	path = filepath.Clean(path)
+	if !isWithin(p.root, path) {
+		return nil, &os.PathError{Op: "watch", Path: path, Err: ErrNoWatch}
+	}
+	if _, err := lstatIfPossible(p.fs, path); err != nil {
+		return nil, err
+	}
+
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+	select {
+	case <-p.stop:
+		return nil, &os.PathError{Op: "watch", Path: path, Err: ErrFileClosed}
+	default:
+	}
+	if !p.started {
+		if err := p.Poll(); err != nil {
+			return nil, err
+		}
+		p.started = true
+		go p.run()
+	}
+	return p.watches.add(path, recursive), nil
+}
+
+func (p *PollingWatcher) Unwatch(events <-chan Event) error {
+	return p.watches.remove(events)
+}
+
+// Close stops polling and closes the channels of all watches.
+func (p *PollingWatcher) Close() error {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	p.watches.removeAll()
+	return nil
+}
+
+func (p *PollingWatcher) run() {
+	for {
+		wait := p.interval
+		if jitter := int64(wait / 10); jitter > 0 {
+			wait += time.Duration(mathrand.Int63n(2*jitter+1) - jitter)
+		}
+		select {
+		case <-time.After(wait):
+		case <-p.stop:
+			return
+		}
+		// a failed walk is retried with the next poll
+		_ = p.Poll()
+	}
+}
+
+// Poll walks the tree right away and reports the changes since the previous
+// poll. Entries which disappear while they are read are taken as removed. If
+// the walk fails otherwise, nothing is reported and the changes are picked
+// up by the next successful poll.
+func (p *PollingWatcher) Poll() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := make(map[string]pollEntry, len(p.state))
+	err := Walk(p.fs, p.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// an entry removed while the tree is walked is taken as removed
+			if missing(err) {
+				delete(state, path)
+				return nil
+			}
+			return err
+		}
+		entry := newPollEntry(info)
+		if p.HashContents && info.Mode().IsRegular() {
+			if entry.sum, err = hashFile(p.fs, path); err != nil {
+				if missing(err) {
+					return nil
+				}
+				return err
+			}
+		}
+		state[path] = entry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if p.state != nil {
+		p.watches.report(p.state, state)
+	}
+	p.state = state
+	return nil
+}
+
+// hashFile returns a hash of the contents of the file name.
+func hashFile(fs Fs, name string) (uint64, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := fnv.New64a()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+// report sends the events turning the entries in prev into those in cur.
+func (ws *watches) report(prev, cur map[string]pollEntry) {
+	var created, removed, changed []string
+	for name, entry := range cur {
+		old, ok := prev[name]
+		switch {
+		case !ok:
+			created = append(created, name)
+		case old.mode.IsDir() != entry.mode.IsDir():
+			removed = append(removed, name)
+			created = append(created, name)
+		case old != entry:
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := cur[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	// pair up the files which are the only ones removed and created with
+	// their attributes
+	candidates := make(map[pollEntry][]string)
+	for _, name := range removed {
+		if entry := prev[name]; !entry.mode.IsDir() {
+			candidates[entry] = append(candidates[entry], name)
+		}
+	}
+	renamed := make(map[string]string)
+	byEntry := make(map[pollEntry][]string)
+	for _, name := range created {
+		if entry := cur[name]; !entry.mode.IsDir() {
+			byEntry[entry] = append(byEntry[entry], name)
+		}
+	}
+	for entry, names := range byEntry {
+		if old := candidates[entry]; len(old) == 1 && len(names) == 1 {
+			renamed[old[0]] = names[0]
+		}
+	}
+
+	// removals deepest first, creations parents first
+	sort.Sort(sort.Reverse(sort.StringSlice(removed)))
+	sort.Strings(created)
+	for _, name := range removed {
+		if newname, ok := renamed[name]; ok {
+			ws.notify(name, Rename)
+			ws.notify(newname, Create)
+			continue
+		}
+		ws.notify(name, Remove)
+	}
+	isRenamed := make(map[string]bool, len(renamed))
+	for _, newname := range renamed {
+		isRenamed[newname] = true
+	}
+	for _, name := range created {
+		if !isRenamed[name] {
+			ws.notify(name, Create)
+		}
+	}
+
+	sort.Strings(changed)
+	for _, name := range changed {
+		old, entry := prev[name], cur[name]
+		if !entry.mode.IsDir() && (old.size != entry.size || old.mtime != entry.mtime || old.sum != entry.sum) {
+			ws.notify(name, Write)
+		}
+		if old.mode != entry.mode {
+			ws.notify(name, Chmod)
+		}
+	}
+}
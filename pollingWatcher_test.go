@@ -0,0 +1,134 @@
+package afero
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPollingWatcher(t *testing.T) {
+	fs := NewMemMapFs()
+	if err := fs.MkdirAll("/root/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(fs, "/root/dir/a", []byte("a"), 0644)
+	WriteFile(fs, "/outside", []byte("o"), 0644)
+
+	w := NewPollingWatcher(fs, "/root", time.Hour)
+	defer w.Close()
+	if _, err := w.Watch("/outside", false); err == nil {
+		t.Error("expected an error watching outside of the root")
+	}
+	events, err := w.Watch("/root", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	WriteFile(fs, "/root/dir/b", []byte("b"), 0644)
+	WriteFile(fs, "/root/dir/a", []byte("longer"), 0644)
+	fs.Chmod("/root/dir", 0700)
+	WriteFile(fs, "/outside", []byte("changed"), 0644)
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events,
+		Event{"/root/dir/b", Create},
+		Event{"/root/dir", Chmod},
+		Event{"/root/dir/a", Write},
+	)
+	expectNoEvents(t, events)
+
+	if err := fs.Rename("/root/dir/b", "/root/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/root/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events,
+		Event{"/root/dir/b", Rename},
+		Event{"/root/b", Create},
+		Event{"/root/dir/a", Remove},
+		Event{"/root/dir", Remove},
+	)
+	expectNoEvents(t, events)
+
+	w.Close()
+	if _, ok := <-events; ok {
+		t.Error("channel not closed by Close")
+	}
+	if _, err := w.Watch("/root", true); err == nil {
+		t.Error("expected an error watching with a closed watcher")
+	}
+}
+
+func TestPollingWatcherVanishing(t *testing.T) {
+	source := NewMemMapFs()
+	WriteFile(source, "/root/a", []byte("a"), 0644)
+	fs, err := NewFaultFs(source, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewPollingWatcher(fs, "/root", time.Hour)
+	w.HashContents = true
+	defer w.Close()
+	events, err := w.Watch("/root", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gone between the listing of the directory and reading them
+	WriteFile(source, "/root/b", []byte("b"), 0644)
+	WriteFile(source, "/root/c", []byte("c"), 0644)
+	fs.Add(FaultRule{Op: "lstat", Pattern: "/root/a", Err: os.ErrNotExist})
+	fs.Add(FaultRule{Op: "open", Pattern: "/root/b", Err: os.ErrNotExist})
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events,
+		Event{"/root/a", Remove},
+		Event{"/root/c", Create},
+	)
+	expectNoEvents(t, events)
+}
+
+func TestPollingWatcherHashContents(t *testing.T) {
+	fs := NewMemMapFs()
+	WriteFile(fs, "/file", []byte("aaaa"), 0644)
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs.Chtimes("/file", mtime, mtime)
+
+	w := NewPollingWatcher(fs, "/", time.Hour)
+	w.HashContents = true
+	defer w.Close()
+	events, err := w.Watch("/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.OpenFile("/file", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("bbbb")
+	f.Close()
+	fs.Chtimes("/file", mtime, mtime)
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/file", Write})
+}
+
+func TestPollingWatcherInterval(t *testing.T) {
+	fs := NewMemMapFs()
+	w := NewPollingWatcher(fs, "/", time.Millisecond)
+	defer w.Close()
+	events, err := w.Watch("/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(fs, "/file", nil, 0644)
+	expectEvents(t, events, Event{"/file", Create})
+}
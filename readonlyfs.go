@@ -6,7 +6,10 @@ import (
 	"time"
 )
 
-var _ Lstater = (*ReadOnlyFs)(nil)
+var (
+	_ Lstater = (*ReadOnlyFs)(nil)
+	_ Watcher = (*ReadOnlyFs)(nil)
+)
 
 type ReadOnlyFs struct {
 	source Fs
@@ -60,6 +63,20 @@ func (r *ReadOnlyFs) ReadlinkIfPossible(name string) (string, error) {
 	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
 }
 
+func (r *ReadOnlyFs) Watch(name string, recursive bool) (<-chan Event, error) {
+	if watcher, ok := r.source.(Watcher); ok {
+		return watcher.Watch(name, recursive)
+	}
+	return nil, &os.PathError{Op: "watch", Path: name, Err: ErrNoWatch}
+}
+
+func (r *ReadOnlyFs) Unwatch(events <-chan Event) error {
+	if watcher, ok := r.source.(Watcher); ok {
+		return watcher.Unwatch(events)
+	}
+	return ErrNotWatching
+}
+
 func (r *ReadOnlyFs) Rename(o, n string) error {
 	return syscall.EPERM
 }
@@ -0,0 +1,657 @@
+package afero
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ Lstater   = (*RecordingFs)(nil)
+	_ Symlinker = (*RecordingFs)(nil)
+)
+
+// ErrDiverged is returned by a strict Replay when the results differ from
+// the recording.
+var ErrDiverged = errors.New("replay diverged from the recording")
+
+// JournalEntry is an operation recorded by a RecordingFs, with its
+// arguments and results. The journal holds one entry per line, in JSON.
+// Paths use slashes and flags are named, so a journal can be replayed on
+// any system.
+type JournalEntry struct {
+	Seq  int64  `json:"seq"`
+	Op   string `json:"op"`
+	Path string `json:"path,omitempty"`
+	// NewPath is the new path of a rename, and Target the target of a
+	// symbolic link, created or read.
+	NewPath string `json:"new_path,omitempty"`
+	Target  string `json:"target,omitempty"`
+	// File identifies the file of a file operation, and the file returned
+	// by an open.
+	File   int64       `json:"file,omitempty"`
+	Flag   []string    `json:"flag,omitempty"`
+	Perm   os.FileMode `json:"perm,omitempty"`
+	UID    int         `json:"uid,omitempty"`
+	GID    int         `json:"gid,omitempty"`
+	Atime  *time.Time  `json:"atime,omitempty"`
+	Mtime  *time.Time  `json:"mtime,omitempty"`
+	Offset int64       `json:"offset,omitempty"`
+	Whence int         `json:"whence,omitempty"`
+	// Size is the size of a truncate, the length of the buffer of a read,
+	// or the count of a readdir.
+	Size int64 `json:"size,omitempty"`
+	// Data is the payload of a write.
+	Data []byte `json:"data,omitempty"`
+
+	// N is the number of bytes read or written, or the offset returned by
+	// a seek.
+	N int64 `json:"n,omitempty"`
+	// Info describes the file returned by a stat.
+	Info *JournalInfo `json:"info,omitempty"`
+	// Names are the sorted names returned by a readdir.
+	Names []string `json:"names,omitempty"`
+	// Err is the ErrnoName of the error returned, or "EOF", and Message
+	// its text.
+	Err     string `json:"err,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// JournalInfo is the part of an os.FileInfo kept in a JournalEntry.
+type JournalInfo struct {
+	Name string      `json:"name"`
+	Mode os.FileMode `json:"mode"`
+	Size int64       `json:"size"`
+}
+
+// Divergence is a result of Replay differing from the recording.
+type Divergence struct {
+	Seq  int64
+	Op   string
+	Path string
+	// Want is the recorded result, and Got the one of the replay.
+	Want string
+	Got  string
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("#%d %s %s: want %s, got %s", d.Seq, d.Op, d.Path, d.Want, d.Got)
+}
+
+// ReplayOptions tune Replay.
+type ReplayOptions struct {
+	// Strict stops the replay at the first divergence, with ErrDiverged.
+	Strict bool
+}
+
+// The RecordingFs writes every operation made on its source and on the
+// files it opens to a journal, including the data written, so that Replay
+// can make them again on another Fs. Operations are journaled once they
+// return, in that order.
+type RecordingFs struct {
+	source Fs
+	files  int64
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	seq int64
+	err error
+}
+
+// NewRecordingFs returns a RecordingFs journaling the operations on source
+// to journal.
+func NewRecordingFs(source Fs, journal io.Writer) *RecordingFs {
+	return &RecordingFs{source: source, enc: json.NewEncoder(journal)}
+}
+
+// Err returns the first error met writing the journal. Operations after it
+// aren't journaled.
+func (r *RecordingFs) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// record journals e, which returned err.
+func (r *RecordingFs) record(e *JournalEntry, err error) {
+	setJournalErr(e, err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.seq++
+	e.Seq = r.seq
+	r.err = r.enc.Encode(e)
+}
+
+// open journals e, an open returning f and err, and returns f as a
+// recording file.
+func (r *RecordingFs) open(e *JournalEntry, f File, err error) (File, error) {
+	if err != nil {
+		r.record(e, err)
+		return nil, err
+	}
+	e.File = atomic.AddInt64(&r.files, 1)
+	r.record(e, nil)
+	return &recordingFile{File: f, fs: r, id: e.File}, nil
+}
+
+func (r *RecordingFs) Name() string {
+	return "RecordingFs"
+}
+
+func (r *RecordingFs) Create(name string) (File, error) {
+	f, err := r.source.Create(name)
+	return r.open(&JournalEntry{Op: "create", Path: filepath.ToSlash(name)}, f, err)
+}
+
+func (r *RecordingFs) Mkdir(name string, perm os.FileMode) error {
+	err := r.source.Mkdir(name, perm)
+	r.record(&JournalEntry{Op: "mkdir", Path: filepath.ToSlash(name), Perm: perm}, err)
+	return err
+}
+
+func (r *RecordingFs) MkdirAll(path string, perm os.FileMode) error {
+	err := r.source.MkdirAll(path, perm)
+	r.record(&JournalEntry{Op: "mkdirall", Path: filepath.ToSlash(path), Perm: perm}, err)
+	return err
+}
+
+func (r *RecordingFs) Open(name string) (File, error) {
+	f, err := r.source.Open(name)
+	return r.open(&JournalEntry{Op: "open", Path: filepath.ToSlash(name)}, f, err)
+}
+
+func (r *RecordingFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := r.source.OpenFile(name, flag, perm)
+	return r.open(&JournalEntry{Op: "openfile", Path: filepath.ToSlash(name), Flag: journalFlag(flag), Perm: perm}, f, err)
+}
+
+func (r *RecordingFs) Remove(name string) error {
+	err := r.source.Remove(name)
+	r.record(&JournalEntry{Op: "remove", Path: filepath.ToSlash(name)}, err)
+	return err
+}
+
+func (r *RecordingFs) RemoveAll(path string) error {
+	err := r.source.RemoveAll(path)
+	r.record(&JournalEntry{Op: "removeall", Path: filepath.ToSlash(path)}, err)
+	return err
+}
+
+func (r *RecordingFs) Rename(oldname, newname string) error {
+	err := r.source.Rename(oldname, newname)
+	r.record(&JournalEntry{Op: "rename", Path: filepath.ToSlash(oldname), NewPath: filepath.ToSlash(newname)}, err)
+	return err
+}
+
+func (r *RecordingFs) Stat(name string) (os.FileInfo, error) {
+	fi, err := r.source.Stat(name)
+	r.record(&JournalEntry{Op: "stat", Path: filepath.ToSlash(name), Info: journalInfo(fi, err)}, err)
+	return fi, err
+}
+
+func (r *RecordingFs) Chmod(name string, mode os.FileMode) error {
+	err := r.source.Chmod(name, mode)
+	r.record(&JournalEntry{Op: "chmod", Path: filepath.ToSlash(name), Perm: mode}, err)
+	return err
+}
+
+func (r *RecordingFs) Chown(name string, uid, gid int) error {
+	err := r.source.Chown(name, uid, gid)
+	r.record(&JournalEntry{Op: "chown", Path: filepath.ToSlash(name), UID: uid, GID: gid}, err)
+	return err
+}
+
+func (r *RecordingFs) Chtimes(name string, atime, mtime time.Time) error {
+	err := r.source.Chtimes(name, atime, mtime)
+	r.record(&JournalEntry{Op: "chtimes", Path: filepath.ToSlash(name), Atime: &atime, Mtime: &mtime}, err)
+	return err
+}
+
+func (r *RecordingFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	var fi os.FileInfo
+	var ok bool
+	var err error
+	if lsf, isLstater := r.source.(Lstater); isLstater {
+		fi, ok, err = lsf.LstatIfPossible(name)
+	} else {
+		fi, err = r.source.Stat(name)
+	}
+	r.record(&JournalEntry{Op: "lstat", Path: filepath.ToSlash(name), Info: journalInfo(fi, err)}, err)
+	return fi, ok, err
+}
+
+func (r *RecordingFs) SymlinkIfPossible(oldname, newname string) error {
+	err := symlinkIfPossible(r.source, oldname, newname)
+	r.record(&JournalEntry{Op: "symlink", Path: filepath.ToSlash(newname), Target: filepath.ToSlash(oldname)}, err)
+	return err
+}
+
+func (r *RecordingFs) ReadlinkIfPossible(name string) (string, error) {
+	target, err := readlinkIfPossible(r.source, name)
+	r.record(&JournalEntry{Op: "readlink", Path: filepath.ToSlash(name), Target: filepath.ToSlash(target)}, err)
+	return target, err
+}
+
+// symlinkIfPossible creates the symbolic link newname to oldname in fs, if
+// it supports them.
+func symlinkIfPossible(fs Fs, oldname, newname string) error {
+	if linker, ok := fs.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+// recordingFile journals the operations on a file opened through a
+// RecordingFs, identified by id.
+type recordingFile struct {
+	File
+	fs *RecordingFs
+	id int64
+}
+
+func (f *recordingFile) record(e *JournalEntry, err error) {
+	e.Path = filepath.ToSlash(f.Name())
+	e.File = f.id
+	f.fs.record(e, err)
+}
+
+func (f *recordingFile) Close() error {
+	err := f.File.Close()
+	f.record(&JournalEntry{Op: "close"}, err)
+	return err
+}
+
+func (f *recordingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.record(&JournalEntry{Op: "read", Size: int64(len(p)), N: int64(n)}, err)
+	return n, err
+}
+
+func (f *recordingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.record(&JournalEntry{Op: "readat", Size: int64(len(p)), Offset: off, N: int64(n)}, err)
+	return n, err
+}
+
+func (f *recordingFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	f.record(&JournalEntry{Op: "seek", Offset: offset, Whence: whence, N: pos}, err)
+	return pos, err
+}
+
+func (f *recordingFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.record(&JournalEntry{Op: "write", Data: p, N: int64(n)}, err)
+	return n, err
+}
+
+func (f *recordingFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	f.record(&JournalEntry{Op: "writeat", Data: p, Offset: off, N: int64(n)}, err)
+	return n, err
+}
+
+func (f *recordingFile) WriteString(s string) (int, error) {
+	n, err := f.File.WriteString(s)
+	f.record(&JournalEntry{Op: "writestring", Data: []byte(s), N: int64(n)}, err)
+	return n, err
+}
+
+func (f *recordingFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	f.record(&JournalEntry{Op: "readdir", Size: int64(count), Names: journalNames(names)}, err)
+	return fis, err
+}
+
+func (f *recordingFile) Readdirnames(n int) ([]string, error) {
+	names, err := f.File.Readdirnames(n)
+	f.record(&JournalEntry{Op: "readdirnames", Size: int64(n), Names: journalNames(names)}, err)
+	return names, err
+}
+
+func (f *recordingFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	f.record(&JournalEntry{Op: "fstat", Info: journalInfo(fi, err)}, err)
+	return fi, err
+}
+
+func (f *recordingFile) Sync() error {
+	err := f.File.Sync()
+	f.record(&JournalEntry{Op: "sync"}, err)
+	return err
+}
+
+func (f *recordingFile) Truncate(size int64) error {
+	err := f.File.Truncate(size)
+	f.record(&JournalEntry{Op: "truncate", Size: size}, err)
+	return err
+}
+
+// setJournalErr sets the error of e to err.
+func setJournalErr(e *JournalEntry, err error) {
+	e.Err, e.Message = "", ""
+	switch {
+	case err == nil:
+	case err == io.EOF:
+		e.Err = "EOF"
+	default:
+		e.Err = ErrnoName(err)
+		e.Message = err.Error()
+	}
+}
+
+// journalInfo returns fi as kept in a JournalEntry, or nil if the stat
+// failed.
+func journalInfo(fi os.FileInfo, err error) *JournalInfo {
+	if err != nil || fi == nil {
+		return nil
+	}
+	return &JournalInfo{Name: fi.Name(), Mode: fi.Mode(), Size: fi.Size()}
+}
+
+// journalNames returns names sorted, as backends list directories in
+// different orders.
+func journalNames(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// journalFlags name the flags of OpenFile besides the access mode.
+var journalFlags = []struct {
+	flag int
+	name string
+}{
+	{os.O_APPEND, "append"},
+	{os.O_CREATE, "create"},
+	{os.O_EXCL, "excl"},
+	{os.O_SYNC, "sync"},
+	{os.O_TRUNC, "trunc"},
+}
+
+// journalFlag returns the names of the flags of OpenFile in flag.
+func journalFlag(flag int) []string {
+	var names []string
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		names = append(names, "wronly")
+	case os.O_RDWR:
+		names = append(names, "rdwr")
+	default:
+		names = append(names, "rdonly")
+	}
+	for _, f := range journalFlags {
+		if flag&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// replayFlag returns the flags of OpenFile named by names.
+func replayFlag(names []string) (int, error) {
+	var flag int
+next:
+	for _, name := range names {
+		switch name {
+		case "rdonly":
+			flag |= os.O_RDONLY
+			continue
+		case "wronly":
+			flag |= os.O_WRONLY
+			continue
+		case "rdwr":
+			flag |= os.O_RDWR
+			continue
+		}
+		for _, f := range journalFlags {
+			if f.name == name {
+				flag |= f.flag
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("unknown open flag %q", name)
+	}
+	return flag, nil
+}
+
+// Replay makes the operations of journal, written by a RecordingFs, on
+// target, and returns where the results differ from the recording: errors,
+// by ErrnoName, bytes moved, offsets, listings, link targets, and the type
+// and size of the files stated. Operations on files which the replay
+// couldn't open are skipped. A nil opts stands for the zero ReplayOptions.
+//
+// A strict replay stops at the first divergence, with ErrDiverged. Replay
+// fails as well if the journal is malformed.
+func Replay(journal io.Reader, target Fs, opts *ReplayOptions) ([]Divergence, error) {
+	if opts == nil {
+		opts = &ReplayOptions{}
+	}
+	r := &replayer{target: target, files: make(map[int64]File)}
+	defer r.close()
+
+	var divergences []Divergence
+	dec := json.NewDecoder(journal)
+	for {
+		var want JournalEntry
+		if err := dec.Decode(&want); err == io.EOF {
+			return divergences, nil
+		} else if err != nil {
+			return divergences, err
+		}
+		got, err := r.apply(&want)
+		if err != nil {
+			return divergences, fmt.Errorf("entry %d: %v", want.Seq, err)
+		}
+		if got == nil {
+			continue
+		}
+		if w, g, diverged := journalDiff(&want, got); diverged {
+			divergences = append(divergences, Divergence{Seq: want.Seq, Op: want.Op, Path: want.Path, Want: w, Got: g})
+			if opts.Strict {
+				return divergences, ErrDiverged
+			}
+		}
+	}
+}
+
+// journalFileOps are the operations on files.
+var journalFileOps = map[string]bool{
+	"close": true, "read": true, "readat": true, "seek": true,
+	"write": true, "writeat": true, "writestring": true,
+	"readdir": true, "readdirnames": true, "fstat": true, "sync": true, "truncate": true,
+}
+
+// replayer makes the operations of a journal on target, keeping the files
+// it opened by their identifier in the journal.
+type replayer struct {
+	target Fs
+	files  map[int64]File
+}
+
+func (r *replayer) close() {
+	for _, f := range r.files {
+		f.Close()
+	}
+}
+
+// apply makes the operation of e on the target, and returns its results in
+// an entry, or nil if it is on a file which the replay couldn't open.
+func (r *replayer) apply(e *JournalEntry) (*JournalEntry, error) {
+	got := &JournalEntry{}
+	path := filepath.FromSlash(e.Path)
+	var err error
+	switch e.Op {
+	case "create", "open", "openfile":
+		var f File
+		switch e.Op {
+		case "create":
+			f, err = r.target.Create(path)
+		case "open":
+			f, err = r.target.Open(path)
+		default:
+			flag, ferr := replayFlag(e.Flag)
+			if ferr != nil {
+				return nil, ferr
+			}
+			f, err = r.target.OpenFile(path, flag, e.Perm)
+		}
+		if err == nil {
+			if e.File != 0 {
+				r.files[e.File] = f
+			} else {
+				f.Close()
+			}
+		}
+	case "mkdir":
+		err = r.target.Mkdir(path, e.Perm)
+	case "mkdirall":
+		err = r.target.MkdirAll(path, e.Perm)
+	case "remove":
+		err = r.target.Remove(path)
+	case "removeall":
+		err = r.target.RemoveAll(path)
+	case "rename":
+		err = r.target.Rename(path, filepath.FromSlash(e.NewPath))
+	case "stat", "lstat":
+		var fi os.FileInfo
+		if e.Op == "stat" {
+			fi, err = r.target.Stat(path)
+		} else {
+			fi, err = lstatIfPossible(r.target, path)
+		}
+		got.Info = journalInfo(fi, err)
+	case "chmod":
+		err = r.target.Chmod(path, e.Perm)
+	case "chown":
+		err = r.target.Chown(path, e.UID, e.GID)
+	case "chtimes":
+		var atime, mtime time.Time
+		if e.Atime != nil {
+			atime = *e.Atime
+		}
+		if e.Mtime != nil {
+			mtime = *e.Mtime
+		}
+		err = r.target.Chtimes(path, atime, mtime)
+	case "symlink":
+		err = symlinkIfPossible(r.target, filepath.FromSlash(e.Target), path)
+	case "readlink":
+		var target string
+		target, err = readlinkIfPossible(r.target, path)
+		got.Target = filepath.ToSlash(target)
+	default:
+		if !journalFileOps[e.Op] {
+			return nil, fmt.Errorf("unknown operation %q", e.Op)
+		}
+		f, ok := r.files[e.File]
+		if !ok {
+			return nil, nil
+		}
+		err = r.applyFile(f, e, got)
+	}
+	setJournalErr(got, err)
+	return got, nil
+}
+
+// applyFile makes the operation of e on f, setting its results in got.
+func (r *replayer) applyFile(f File, e *JournalEntry, got *JournalEntry) error {
+	var n int
+	var err error
+	switch e.Op {
+	case "close":
+		delete(r.files, e.File)
+		return f.Close()
+	case "read":
+		n, err = f.Read(make([]byte, e.Size))
+	case "readat":
+		n, err = f.ReadAt(make([]byte, e.Size), e.Offset)
+	case "seek":
+		got.N, err = f.Seek(e.Offset, e.Whence)
+		return err
+	case "write":
+		n, err = f.Write(e.Data)
+	case "writeat":
+		n, err = f.WriteAt(e.Data, e.Offset)
+	case "writestring":
+		n, err = f.WriteString(string(e.Data))
+	case "readdir":
+		var fis []os.FileInfo
+		fis, err = f.Readdir(int(e.Size))
+		names := make([]string, len(fis))
+		for i, fi := range fis {
+			names[i] = fi.Name()
+		}
+		got.Names = journalNames(names)
+		return err
+	case "readdirnames":
+		var names []string
+		names, err = f.Readdirnames(int(e.Size))
+		got.Names = journalNames(names)
+		return err
+	case "fstat":
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		got.Info = journalInfo(fi, err)
+		return err
+	case "sync":
+		return f.Sync()
+	case "truncate":
+		return f.Truncate(e.Size)
+	}
+	got.N = int64(n)
+	return err
+}
+
+// journalDiff compares the results of want and got, and describes them if
+// they differ. Permissions and times aren't compared, as they depend on
+// the umask and the clock.
+func journalDiff(want, got *JournalEntry) (string, string, bool) {
+	if want.Err != got.Err {
+		return journalErrString(want.Err), journalErrString(got.Err), true
+	}
+	if want.N != got.N {
+		return fmt.Sprintf("n %d", want.N), fmt.Sprintf("n %d", got.N), true
+	}
+	if want.Op == "readlink" && want.Target != got.Target {
+		return "target " + want.Target, "target " + got.Target, true
+	}
+	if w, g := strings.Join(want.Names, ","), strings.Join(got.Names, ","); w != g {
+		return "names [" + w + "]", "names [" + g + "]", true
+	}
+	if want.Info != nil && got.Info != nil {
+		wi, gi := want.Info, got.Info
+		if wi.Mode.IsDir() != gi.Mode.IsDir() || wi.Mode&os.ModeSymlink != gi.Mode&os.ModeSymlink {
+			return "mode " + wi.Mode.String(), "mode " + gi.Mode.String(), true
+		}
+		if wi.Mode.IsRegular() && wi.Size != gi.Size {
+			return fmt.Sprintf("size %d", wi.Size), fmt.Sprintf("size %d", gi.Size), true
+		}
+	}
+	return "", "", false
+}
+
+func journalErrString(name string) string {
+	if name == "" {
+		return "success"
+	}
+	return "error " + name
+}
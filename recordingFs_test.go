@@ -0,0 +1,184 @@
+package afero
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// recordJob makes a few operations on fs, as a job would.
+func recordJob(t *testing.T, fs Fs) {
+	t.Helper()
+	if err := fs.MkdirAll("/out/logs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("/out/result", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("hello "); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 1, 2, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/out/result", "/out/final"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/out/result"); !os.IsNotExist(err) {
+		t.Fatalf("Stat: %v", err)
+	}
+	if _, err := ReadDir(fs, "/out"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordingFsJournal(t *testing.T) {
+	var journal bytes.Buffer
+	fs := NewRecordingFs(NewMemMapFs(), &journal)
+	recordJob(t, fs)
+	if err := fs.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ops []string
+	var entries []JournalEntry
+	dec := json.NewDecoder(&journal)
+	for dec.More() {
+		var e JournalEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Seq != int64(len(entries)+1) {
+			t.Errorf("entry %d has seq %d", len(entries)+1, e.Seq)
+		}
+		ops = append(ops, e.Op)
+		entries = append(entries, e)
+	}
+	want := "mkdirall openfile writestring write seek read close rename stat open readdir close"
+	if got := strings.Join(ops, " "); got != want {
+		t.Fatalf("ops = %s, want %s", got, want)
+	}
+	if flag := strings.Join(entries[1].Flag, "|"); flag != "rdwr|create|trunc" {
+		t.Errorf("flag = %s", flag)
+	}
+	if entries[1].File == 0 || entries[2].File != entries[1].File {
+		t.Errorf("file ids = %d, %d", entries[1].File, entries[2].File)
+	}
+	if !bytes.Equal(entries[3].Data, []byte{0, 1, 2, 0xff}) {
+		t.Errorf("write data = %v", entries[3].Data)
+	}
+	if e := entries[8]; e.Err != "ENOENT" || e.Message == "" {
+		t.Errorf("stat error = %q, %q", e.Err, e.Message)
+	}
+	if names := strings.Join(entries[10].Names, ","); names != "final,logs" {
+		t.Errorf("readdir names = %s", names)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var journal bytes.Buffer
+	recordJob(t, NewRecordingFs(NewMemMapFs(), &journal))
+
+	target := NewMemMapFs()
+	divergences, err := Replay(bytes.NewReader(journal.Bytes()), target, &ReplayOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("Replay: %v, %v", err, divergences)
+	}
+	checkFile(t, target, "/out/final", "hello \x00\x01\x02\xff")
+	checkMissing(t, target, "/out/result")
+	if fi, err := target.Stat("/out/logs"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat: %v, %v", fi, err)
+	}
+
+	dir, err := TempDir(NewOsFs(), "", "afero-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	osFs := NewBasePathFs(NewOsFs(), dir)
+	if divergences, err := Replay(bytes.NewReader(journal.Bytes()), osFs, &ReplayOptions{Strict: true}); err != nil {
+		t.Fatalf("Replay on OsFs: %v, %v", err, divergences)
+	}
+	checkFile(t, osFs, "/out/final", "hello \x00\x01\x02\xff")
+}
+
+func TestReplayDivergence(t *testing.T) {
+	var journal bytes.Buffer
+	recordJob(t, NewRecordingFs(NewMemMapFs(), &journal))
+
+	// An extra file in the target only shows in the listing.
+	diverging := func() Fs {
+		fs := NewMemMapFs()
+		if err := fs.MkdirAll("/out", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(fs, "/out/extra", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+
+	divergences, err := Replay(bytes.NewReader(journal.Bytes()), diverging(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divergences) != 1 {
+		t.Fatalf("divergences = %v, want one", divergences)
+	}
+	d := divergences[0]
+	if d.Op != "readdir" || d.Want != "names [final,logs]" || d.Got != "names [extra,final,logs]" {
+		t.Errorf("divergence = %s", d)
+	}
+
+	// A strict replay stops at it.
+	divergences, err = Replay(bytes.NewReader(journal.Bytes()), diverging(), &ReplayOptions{Strict: true})
+	if err != ErrDiverged || len(divergences) != 1 {
+		t.Errorf("strict Replay: %v, %v", divergences, err)
+	}
+}
+
+func TestReplaySkipsUnopenedFiles(t *testing.T) {
+	var journal bytes.Buffer
+	recordJob(t, NewRecordingFs(NewMemMapFs(), &journal))
+
+	target := NewMemMapFs()
+	if err := WriteFile(target, "/out", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	divergences, err := Replay(bytes.NewReader(journal.Bytes()), target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, d := range divergences {
+		ops = append(ops, d.Op)
+	}
+	// The operations on the file which couldn't be opened are skipped.
+	if got, want := strings.Join(ops, " "), "mkdirall openfile rename readdir"; got != want {
+		t.Errorf("diverging ops = %s, want %s: %v", got, want, divergences)
+	}
+}
+
+func TestReplayMalformed(t *testing.T) {
+	for _, journal := range []string{
+		`{"seq":1,"op":"format"}`,
+		`{"seq":1,"op":"openfile","path":"/a","flag":["rdonly","cloexec"]}`,
+		`{"seq":1,"op":`,
+	} {
+		if _, err := Replay(strings.NewReader(journal), NewMemMapFs(), nil); err == nil {
+			t.Errorf("Replay of %s succeeded", journal)
+		}
+	}
+}
@@ -37,7 +37,7 @@ func (r *RegexpFs) matchesName(name string) error {
 
 func (r *RegexpFs) dirOrMatches(name string) error {
 	dir, err := IsDir(r.source, name)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	if dir {
@@ -97,6 +97,9 @@ func (r *RegexpFs) Rename(oldname, newname string) error {
 
 func (r *RegexpFs) RemoveAll(p string) error {
 	dir, err := IsDir(r.source, p)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
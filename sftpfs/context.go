@@ -0,0 +1,173 @@
+// Copyright © 2015 Jerry Jacobs <jerry.jacobs@xor-gate.org>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sftpfs
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.FsContext = Fs{}
+
+// The sftp client has no notion of cancellation, and a single SFTP request
+// can't be interrupted once it is sent. ctx is checked before every request
+// instead. A request changing the remote is waited for even if ctx is done
+// meanwhile, so that an error never hides a change which was made, and the
+// operations made of several requests, such as RemoveAllContext, stop
+// between two of them. A request which only reads is abandoned and left to
+// finish in the background, while the caller gets ctx's error right away.
+
+// check returns ctx's error for op on name, if ctx is done.
+func check(ctx context.Context, op, name string) error {
+	if err := ctx.Err(); err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// wait runs fn, which only reads, and returns early if ctx is done.
+func wait(ctx context.Context, op, name string, fn func() error) error {
+	if err := check(ctx, op, name); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return &os.PathError{Op: op, Path: name, Err: ctx.Err()}
+	}
+}
+
+// waitFile is wait for opening a file to read it.
+func waitFile(ctx context.Context, op, name string, fn func() (afero.File, error)) (afero.File, error) {
+	if err := check(ctx, op, name); err != nil {
+		return nil, err
+	}
+	type result struct {
+		f   afero.File
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		f, err := fn()
+		done <- result{f, err}
+	}()
+	select {
+	case r := <-done:
+		return r.f, r.err
+	case <-ctx.Done():
+		go func() {
+			// nobody is going to use the file, don't leak the handle
+			if r := <-done; r.err == nil {
+				r.f.Close()
+			}
+		}()
+		return nil, &os.PathError{Op: op, Path: name, Err: ctx.Err()}
+	}
+}
+
+func (s Fs) CreateContext(ctx context.Context, name string) (afero.File, error) {
+	if err := check(ctx, "create", name); err != nil {
+		return nil, err
+	}
+	return s.Create(name)
+}
+
+func (s Fs) MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	if err := check(ctx, "mkdir", name); err != nil {
+		return err
+	}
+	return s.Mkdir(name, perm)
+}
+
+func (s Fs) MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	return s.mkdirAll(ctx, path, perm)
+}
+
+func (s Fs) OpenContext(ctx context.Context, name string) (afero.File, error) {
+	return waitFile(ctx, "open", name, func() (afero.File, error) {
+		return s.Open(name)
+	})
+}
+
+func (s Fs) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		if err := check(ctx, "open", name); err != nil {
+			return nil, err
+		}
+		return s.OpenFile(name, flag, perm)
+	}
+	return waitFile(ctx, "open", name, func() (afero.File, error) {
+		return s.OpenFile(name, flag, perm)
+	})
+}
+
+func (s Fs) RemoveContext(ctx context.Context, name string) error {
+	if err := check(ctx, "remove", name); err != nil {
+		return err
+	}
+	return s.Remove(name)
+}
+
+func (s Fs) RemoveAllContext(ctx context.Context, path string) error {
+	return s.removeAll(ctx, path)
+}
+
+func (s Fs) RenameContext(ctx context.Context, oldname, newname string) error {
+	if err := ctx.Err(); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return s.Rename(oldname, newname)
+}
+
+func (s Fs) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := wait(ctx, "stat", name, func() (err error) {
+		info, err := s.Stat(name)
+		fi = info
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+func (s Fs) ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	if err := check(ctx, "chmod", name); err != nil {
+		return err
+	}
+	return s.Chmod(name, mode)
+}
+
+func (s Fs) ChownContext(ctx context.Context, name string, uid, gid int) error {
+	if err := check(ctx, "chown", name); err != nil {
+		return err
+	}
+	return s.Chown(name, uid, gid)
+}
+
+func (s Fs) ChtimesContext(ctx context.Context, name string, atime time.Time, mtime time.Time) error {
+	if err := check(ctx, "chtimes", name); err != nil {
+		return err
+	}
+	return s.Chtimes(name, atime, mtime)
+}
@@ -0,0 +1,123 @@
+package sftpfs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestWaitCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	err := wait(ctx, "stat", "slow", func() error {
+		<-release
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if _, ok := err.(*os.PathError); !ok {
+		t.Errorf("expected an *os.PathError, got %T", err)
+	}
+}
+
+func TestWaitFileClosesAbandoned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	fs := afero.NewMemMapFs()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	go func() {
+		_, err := waitFile(ctx, "open", "slow", func() (afero.File, error) {
+			close(started)
+			<-release
+			f, err := fs.Create("slow")
+			return &closeNotifier{File: f, closed: closed}, err
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+		close(release)
+	}()
+	<-started
+	cancel()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the abandoned file was not closed")
+	}
+}
+
+type closeNotifier struct {
+	afero.File
+	closed chan struct{}
+}
+
+func (c *closeNotifier) Close() error {
+	close(c.closed)
+	return c.File.Close()
+}
+
+// countdownContext is done once its Err has been asked for n times, which
+// cancels an operation between two of its requests.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestRemoveAllContextStops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a/1", "a/2", "b/3"} {
+		path := filepath.Join(dir, "tree", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client, closeClient := pipeClient(t)
+	defer closeClient()
+	fs := New(client).(*Fs)
+
+	// canceled once the first file is removed, whichever the server lists
+	// first
+	ctx := &countdownContext{Context: context.Background(), n: 6}
+	err = fs.RemoveAllContext(ctx, filepath.Join(dir, "tree"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	left := 0
+	for _, name := range []string{"a/1", "a/2", "b/3"} {
+		if _, err := os.Stat(filepath.Join(dir, "tree", name)); err == nil {
+			left++
+		}
+	}
+	if left != 2 {
+		t.Errorf("%d files left after the cancellation, expected 2", left)
+	}
+}
@@ -14,6 +14,7 @@
 package sftpfs
 
 import (
+	"io"
 	"os"
 
 	"github.com/pkg/sftp"
@@ -22,6 +23,11 @@ import (
 type File struct {
 	client *sftp.Client
 	fd     *sftp.File
+
+	// entries holds the directory listing from the first Readdir call on,
+	// dirOffset the number of entries already returned
+	entries   []os.FileInfo
+	dirOffset int
 }
 
 func FileOpen(s *sftp.Client, name string) (*File, error) {
@@ -64,22 +70,32 @@ func (f *File) Read(b []byte) (n int, err error) {
 	return f.fd.Read(b)
 }
 
-// TODO
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	return 0, nil
+	return f.fd.ReadAt(b, off)
 }
 
+// Readdir lists the directory on the first call, and returns the entries
+// from where the previous call stopped. At the end of the directory, the
+// error is io.EOF if count > 0.
 func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
-	res, err = f.client.ReadDir(f.Name())
-	if err != nil {
-		return
+	if f.entries == nil {
+		entries, err := f.client.ReadDir(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		f.entries = append([]os.FileInfo{}, entries...)
 	}
+	res = f.entries[f.dirOffset:]
 	if count > 0 {
+		if len(res) == 0 {
+			return nil, io.EOF
+		}
 		if len(res) > count {
 			res = res[:count]
 		}
 	}
-	return
+	f.dirOffset += len(res)
+	return res, nil
 }
 
 func (f *File) Readdirnames(n int) (names []string, err error) {
@@ -101,9 +117,8 @@ func (f *File) Write(b []byte) (n int, err error) {
 	return f.fd.Write(b)
 }
 
-// TODO
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	return 0, nil
+	return f.fd.WriteAt(b, off)
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
@@ -14,6 +14,8 @@
 package sftpfs
 
 import (
+	"context"
+	"io"
 	"os"
 	"time"
 
@@ -48,7 +50,15 @@ func (s Fs) Mkdir(name string, perm os.FileMode) error {
 }
 
 func (s Fs) MkdirAll(path string, perm os.FileMode) error {
+	return s.mkdirAll(context.Background(), path, perm)
+}
+
+// mkdirAll is MkdirAll, checking ctx before every request.
+func (s Fs) mkdirAll(ctx context.Context, path string, perm os.FileMode) error {
 	// Fast path: if we can tell whether path is a directory or file, stop with success or error.
+	if err := check(ctx, "mkdir", path); err != nil {
+		return err
+	}
 	dir, err := s.Stat(path)
 	if err == nil {
 		if dir.IsDir() {
@@ -70,13 +80,16 @@ func (s Fs) MkdirAll(path string, perm os.FileMode) error {
 
 	if j > 1 {
 		// Create parent
-		err = s.MkdirAll(path[0:j-1], perm)
+		err = s.mkdirAll(ctx, path[0:j-1], perm)
 		if err != nil {
 			return err
 		}
 	}
 
 	// Parent now exists; invoke Mkdir and use its result.
+	if err := check(ctx, "mkdir", path); err != nil {
+		return err
+	}
 	err = s.Mkdir(path, perm)
 	if err != nil {
 		// Handle arguments like "foo/." by
@@ -99,10 +112,23 @@ func (s Fs) Open(name string) (afero.File, error) {
 func (s Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
 	sshfsFile, err := s.client.OpenFile(name, flag)
 	if err != nil {
+		// servers report an existing file as a generic failure
+		if flag&os.O_EXCL != 0 {
+			if _, serr := s.client.Lstat(name); serr == nil {
+				return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+			}
+		}
 		return nil, err
 	}
+	if flag&os.O_APPEND != 0 {
+		// the offset of the file is kept by the client
+		if _, err := sshfsFile.Seek(0, io.SeekEnd); err != nil {
+			sshfsFile.Close()
+			return nil, err
+		}
+	}
 	err = sshfsFile.Chmod(perm)
-	return &File{fd: sshfsFile}, err
+	return &File{fd: sshfsFile, client: s.client}, err
 }
 
 func (s Fs) Remove(name string) error {
@@ -110,9 +136,41 @@ func (s Fs) Remove(name string) error {
 }
 
 func (s Fs) RemoveAll(path string) error {
-	// TODO have a look at os.RemoveAll
-	// https://github.com/golang/go/blob/master/src/os/path.go#L66
-	return nil
+	return s.removeAll(context.Background(), path)
+}
+
+// removeAll is RemoveAll, checking ctx before every request.
+func (s Fs) removeAll(ctx context.Context, path string) error {
+	if err := check(ctx, "removeall", path); err != nil {
+		return err
+	}
+	fi, err := s.client.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := check(ctx, "removeall", path); err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return s.client.Remove(path)
+	}
+
+	entries, err := s.client.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := s.removeAll(ctx, s.client.Join(path, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := check(ctx, "removeall", path); err != nil {
+		return err
+	}
+	return s.client.RemoveDirectory(path)
 }
 
 func (s Fs) Rename(oldname, newname string) error {
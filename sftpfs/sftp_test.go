@@ -24,10 +24,14 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -124,7 +128,7 @@ func RunSftpServer(rootpath string) {
 		},
 	}
 
-	privateBytes, err := ioutil.ReadFile("./test/id_rsa")
+	privateBytes, err := ioutil.ReadFile(filepath.Join(rootpath, "id_rsa"))
 	if err != nil {
 		log.Fatal("Failed to load private key", err)
 	}
@@ -235,10 +239,16 @@ func MakeSSHKeyPair(bits int, pubKeyPath, privateKeyPath string) error {
 }
 
 func TestSftpCreate(t *testing.T) {
-	os.Mkdir("./test", 0777)
-	MakeSSHKeyPair(1024, "./test/id_rsa.pub", "./test/id_rsa")
+	dir, err := ioutil.TempDir("", "sftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := MakeSSHKeyPair(1024, filepath.Join(dir, "id_rsa.pub"), filepath.Join(dir, "id_rsa")); err != nil {
+		t.Fatal(err)
+	}
 
-	go RunSftpServer("./test/")
+	go RunSftpServer(dir)
 	time.Sleep(5 * time.Second)
 
 	ctx, err := SftpConnect("test", "test", "localhost:2022")
@@ -249,12 +259,12 @@ func TestSftpCreate(t *testing.T) {
 
 	var fs = New(ctx.sftpc)
 
-	fs.MkdirAll("test/dir1/dir2/dir3", os.FileMode(0777))
-	fs.Mkdir("test/foo", os.FileMode(0000))
-	fs.Chmod("test/foo", os.FileMode(0700))
-	fs.Mkdir("test/bar", os.FileMode(0777))
+	fs.MkdirAll(filepath.Join(dir, "dir1/dir2/dir3"), os.FileMode(0777))
+	fs.Mkdir(filepath.Join(dir, "foo"), os.FileMode(0000))
+	fs.Chmod(filepath.Join(dir, "foo"), os.FileMode(0700))
+	fs.Mkdir(filepath.Join(dir, "bar"), os.FileMode(0777))
 
-	file, err := fs.Create("file1")
+	file, err := fs.Create(filepath.Join(dir, "file1"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -263,7 +273,7 @@ func TestSftpCreate(t *testing.T) {
 	file.Write([]byte("hello "))
 	file.WriteString("world!\n")
 
-	f1, err := fs.Open("file1")
+	f1, err := fs.Open(filepath.Join(dir, "file1"))
 	if err != nil {
 		log.Fatalf("open: %v", err)
 	}
@@ -277,3 +287,46 @@ func TestSftpCreate(t *testing.T) {
 	fmt.Println("done")
 	// TODO check here if "hello\tworld\n" is in buffer b
 }
+
+func TestSftpConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client, closeClient := pipeClient(t)
+	defer closeClient()
+
+	n := 0
+	aferotest.Run(t, func() afero.Fs {
+		n++
+		base := filepath.Join(dir, strconv.Itoa(n))
+		if err := os.Mkdir(base, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return afero.NewBasePathFs(New(client), base)
+	}, aferotest.Symlinks)
+}
+
+// pipeClient returns a client of a server for the local filesystem, and a
+// function closing both. It serves sftp over a pipe, there is no need for ssh
+// here.
+func pipeClient(t *testing.T) (*sftp.Client, func()) {
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		server.Close()
+	}
+}
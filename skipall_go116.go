@@ -0,0 +1,7 @@
+// +build go1.16,!go1.20
+
+package afero
+
+import "errors"
+
+var skipAll = errors.New("skip everything and stop the walk")
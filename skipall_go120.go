@@ -0,0 +1,7 @@
+// +build go1.20
+
+package afero
+
+import "io/fs"
+
+var skipAll = fs.SkipAll
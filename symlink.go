@@ -15,6 +15,9 @@ package afero
 
 import (
 	"errors"
+	"path/filepath"
+	"strings"
+	"syscall"
 )
 
 // Symlinker is an optional interface in Afero. It is only implemented by the
@@ -53,3 +56,55 @@ type LinkReader interface {
 // does not support the readlink operation either directly or through its delegated filesystem.
 // As expressed by support for the LinkReader interface.
 var ErrNoReadlink = errors.New("readlink not supported")
+
+// maxSymlinks is the number of symbolic links followed while resolving a path
+// before giving up with ELOOP, the same limit as on Linux.
+const maxSymlinks = 40
+
+// evalSymlinks returns name with every symbolic link in it replaced by its
+// target, both relative and absolute targets are supported. The last element
+// of name is only followed if followLast is set.
+// readlink reports whether the given path exists and, if it is a symbolic
+// link, its target.
+func evalSymlinks(name string, followLast bool, readlink func(name string) (target string, isLink, exists bool, err error)) (string, error) {
+	name = filepath.Clean(name)
+	links := 0
+resolve:
+	for {
+		parts := strings.Split(name, FilePathSeparator)
+		cur := ""
+		for i, part := range parts {
+			if part == "" {
+				if i == 0 {
+					cur = FilePathSeparator
+				}
+				continue
+			}
+			cur = filepath.Join(cur, part)
+			if i == len(parts)-1 && !followLast {
+				break
+			}
+			target, isLink, exists, err := readlink(cur)
+			if err != nil {
+				return name, err
+			}
+			if !exists {
+				// nothing below a missing element can be a link
+				return name, nil
+			}
+			if !isLink {
+				continue
+			}
+			links++
+			if links > maxSymlinks {
+				return name, syscall.ELOOP
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(cur), target)
+			}
+			name = filepath.Join(append([]string{target}, parts[i+1:]...)...)
+			continue resolve
+		}
+		return name, nil
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -77,11 +78,12 @@ func TestSymlinkIfPossible(t *testing.T) {
 	notSupported := ErrNoSymlink.Error()
 
 	testLink(osFs, osPath, filepath.Join(workDir, "os/link.txt"), nil)
-	testLink(overlayFs1, osPath, filepath.Join(workDir, "overlay/link1.txt"), &notSupported)
+	testLink(memFs.(Linker), pathFileMem, filepath.Join(memWorkDir, "mem/link.txt"), nil)
+	testLink(overlayFs1, osPath, filepath.Join(workDir, "overlay/link1.txt"), nil)
 	testLink(overlayFs2, pathFileMem, filepath.Join(workDir, "overlay2/link2.txt"), nil)
-	testLink(overlayFsMemOnly, pathFileMem, filepath.Join(memWorkDir, "overlay3/link.txt"), &notSupported)
+	testLink(overlayFsMemOnly, pathFileMem, filepath.Join(memWorkDir, "overlay3/link.txt"), nil)
 	testLink(basePathFs, "afero.txt", "basepath/link.txt", nil)
-	testLink(basePathFsMem, pathFileMem, "link/file.txt", &notSupported)
+	testLink(basePathFsMem, pathFileMem, "link/file.txt", nil)
 	testLink(roFs, osPath, filepath.Join(workDir, "ro/link.txt"), &notSupported)
 	testLink(roFsMem, pathFileMem, filepath.Join(memWorkDir, "ro/link.txt"), &notSupported)
 }
@@ -142,19 +144,27 @@ func TestReadlinkIfPossible(t *testing.T) {
 		}
 	}
 
-	notSupported := ErrNoReadlink.Error()
+	notLink := syscall.EINVAL.Error()
 
 	err = createLink(osFs, osPath, filepath.Join(workDir, "os/link.txt"))
 	if err != nil {
 		t.Fatal("Error creating test link: ", err)
 	}
+	err = createLink(memFs.(Linker), pathFileMem, filepath.Join(memWorkDir, "mem/link.txt"))
+	if err != nil {
+		t.Fatal("Error creating test link: ", err)
+	}
 
 	testRead(osFs, filepath.Join(workDir, "os/link.txt"), nil)
 	testRead(overlayFs1, filepath.Join(workDir, "os/link.txt"), nil)
 	testRead(overlayFs2, filepath.Join(workDir, "os/link.txt"), nil)
-	testRead(overlayFsMemOnly, pathFileMem, &notSupported)
+	testRead(memFs.(LinkReader), filepath.Join(memWorkDir, "mem/link.txt"), nil)
+	testRead(memFs.(LinkReader), pathFileMem, &notLink)
+	testRead(overlayFsMemOnly, filepath.Join(memWorkDir, "mem/link.txt"), nil)
+	testRead(overlayFsMemOnly, pathFileMem, &notLink)
 	testRead(basePathFs, "os/link.txt", nil)
-	testRead(basePathFsMem, pathFileMem, &notSupported)
+	testRead(basePathFsMem, "mem/link.txt", nil)
+	testRead(basePathFsMem, "aferom.txt", &notLink)
 	testRead(roFs, filepath.Join(workDir, "os/link.txt"), nil)
-	testRead(roFsMem, pathFileMem, &notSupported)
+	testRead(roFsMem, filepath.Join(memWorkDir, "mem/link.txt"), nil)
 }
@@ -0,0 +1,276 @@
+package afero
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SyncOp is a change Sync makes to the destination.
+type SyncOp int
+
+const (
+	// SyncCreate adds an entry missing from the destination.
+	SyncCreate SyncOp = iota
+	// SyncUpdate replaces an entry which differs from the source.
+	SyncUpdate
+	// SyncDelete removes an entry missing from the source.
+	SyncDelete
+)
+
+func (op SyncOp) String() string {
+	switch op {
+	case SyncCreate:
+		return "create"
+	case SyncUpdate:
+		return "update"
+	case SyncDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("SyncOp(%d)", int(op))
+}
+
+// SyncAction is a change Sync made, or would make in a dry run, to Path in
+// the destination. Err is set if the change failed.
+type SyncAction struct {
+	Op   SyncOp
+	Path string
+	Err  error
+}
+
+// SyncOptions tune Sync. The zero value transfers the files whose size
+// differs or which are newer in the source, and keeps everything else.
+type SyncOptions struct {
+	// Checksum compares the contents of files of the same size instead of
+	// their modification times.
+	Checksum bool
+	// Delete removes the entries of the destination which aren't in the
+	// source.
+	Delete bool
+	// DryRun makes Sync only report what it would do.
+	DryRun bool
+}
+
+// SyncError is returned by Sync if some of the changes failed. The other
+// changes have been made.
+type SyncError struct {
+	Failed []SyncAction
+}
+
+func (e *SyncError) Error() string {
+	first := e.Failed[0]
+	return fmt.Sprintf("sync: %d changes failed, first to %s %s: %v", len(e.Failed), first.Op, first.Path, first.Err)
+}
+
+// Sync makes the tree below root in dst look like the one in src, and
+// returns what it changed. A file is transferred if its size differs, or if
+// it is newer in the source, which also suits destinations that can't set
+// modification times. Files are written to a temporary file next to their
+// destination first, and renamed into place once complete, so a failed
+// transfer leaves the previous version intact. A failing change doesn't stop
+// the others, and neither does an entry which can't be read, which is kept
+// as it is in the destination; they are all returned, with a *SyncError
+// listing the failed ones. A nil opts stands for the zero SyncOptions.
+func Sync(src, dst Fs, root string, opts *SyncOptions) ([]SyncAction, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	s := &syncer{src: src, dst: dst, opts: opts, seen: make(map[string]bool), kept: make(map[string]bool)}
+	err := Walk(src, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root && info == nil {
+				return err
+			}
+			// what can't be read is reported and kept as it is
+			s.actions = append(s.actions, SyncAction{Op: SyncUpdate, Path: path, Err: err})
+			s.seen[path] = true
+			s.kept[path] = true
+			return skipDir(info)
+		}
+		s.seen[path] = true
+		return s.visit(path, info)
+	})
+	if err != nil {
+		return s.actions, err
+	}
+	if opts.Delete {
+		err := Walk(dst, root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if path == root && os.IsNotExist(err) {
+					// nothing to delete, as in a dry run creating root
+					return nil
+				}
+				s.actions = append(s.actions, SyncAction{Op: SyncDelete, Path: path, Err: err})
+				return skipDir(info)
+			}
+			if s.kept[path] {
+				return skipDir(info)
+			}
+			if s.seen[path] {
+				return nil
+			}
+			s.do(SyncDelete, path, func() error {
+				return dst.RemoveAll(path)
+			})
+			return skipDir(info)
+		})
+		if err != nil {
+			return s.actions, err
+		}
+	}
+
+	var failed []SyncAction
+	for _, action := range s.actions {
+		if action.Err != nil {
+			failed = append(failed, action)
+		}
+	}
+	if len(failed) > 0 {
+		return s.actions, &SyncError{Failed: failed}
+	}
+	return s.actions, nil
+}
+
+// syncer holds the state of a single Sync.
+type syncer struct {
+	src, dst Fs
+	opts     *SyncOptions
+	actions  []SyncAction
+	// seen has the paths of the source, which are kept by Delete, and
+	// kept the directories which failed, whose entries are kept as well.
+	seen map[string]bool
+	kept map[string]bool
+}
+
+// do records the action op on path, and carries it out with change unless
+// in a dry run. It reports whether the action succeeded.
+func (s *syncer) do(op SyncOp, path string, change func() error) bool {
+	action := SyncAction{Op: op, Path: path}
+	if !s.opts.DryRun {
+		action.Err = change()
+	}
+	s.actions = append(s.actions, action)
+	return action.Err == nil
+}
+
+// visit brings the entry path of the destination in line with the source,
+// described by info.
+func (s *syncer) visit(path string, info os.FileInfo) error {
+	existing, err := lstatIfPossible(s.dst, path)
+	op := SyncUpdate
+	switch {
+	case os.IsNotExist(err):
+		op = SyncCreate
+	case err != nil:
+		s.actions = append(s.actions, SyncAction{Op: SyncUpdate, Path: path, Err: err})
+		return s.skip(path, info)
+	case existing.Mode()&os.ModeType != info.Mode()&os.ModeType:
+		// replaced by something else altogether
+	default:
+		changed, err := s.changed(path, info, existing)
+		if err != nil {
+			s.actions = append(s.actions, SyncAction{Op: SyncUpdate, Path: path, Err: err})
+			return s.skip(path, info)
+		}
+		if !changed {
+			return nil
+		}
+	}
+
+	ok := s.do(op, path, func() error {
+		if op == SyncUpdate && existing.Mode()&os.ModeType != info.Mode()&os.ModeType {
+			if err := s.dst.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+		switch {
+		case info.IsDir():
+			return s.dst.Mkdir(path, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			return Copy(s.src, path, s.dst, path, nil)
+		default:
+			return s.transfer(path, info)
+		}
+	})
+	if !ok {
+		return s.skip(path, info)
+	}
+	return nil
+}
+
+// skip leaves the entries below path alone if it is a directory.
+func (s *syncer) skip(path string, info os.FileInfo) error {
+	if info.IsDir() {
+		s.kept[path] = true
+	}
+	return skipDir(info)
+}
+
+// skipDir makes Walk skip the entry described by info if it is a directory.
+func skipDir(info os.FileInfo) error {
+	if info != nil && info.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// changed reports whether the entry path, which is of the same type in both
+// trees, differs.
+func (s *syncer) changed(path string, info, existing os.FileInfo) (bool, error) {
+	switch {
+	case info.IsDir():
+		return false, nil
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := readlinkIfPossible(s.src, path)
+		if err != nil {
+			return false, err
+		}
+		existingTarget, err := readlinkIfPossible(s.dst, path)
+		return target != existingTarget, err
+	case info.Size() != existing.Size():
+		return true, nil
+	case !s.opts.Checksum:
+		return info.ModTime().After(existing.ModTime()), nil
+	}
+	same, err := sameContents(s.src, s.dst, path)
+	return !same, err
+}
+
+func readlinkIfPossible(fs Fs, name string) (string, error) {
+	if reader, ok := fs.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+// transfer copies the file path to a temporary file of the destination,
+// which then replaces path.
+func (s *syncer) transfer(path string, info os.FileInfo) error {
+	in, err := s.src.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := TempFile(s.dst, filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	_, err = io.Copy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		// not every filesystem can set these, which the comparison allows
+		// for
+		s.dst.Chmod(tmp, info.Mode().Perm())
+		s.dst.Chtimes(tmp, info.ModTime(), info.ModTime())
+		err = s.dst.Rename(tmp, path)
+	}
+	if err != nil {
+		s.dst.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package afero
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func syncFixture(t *testing.T) (src, dst *MemMapFs) {
+	t.Helper()
+	src, dst = &MemMapFs{}, &MemMapFs{}
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, content := range map[string]string{
+		"/site/index.html":     "index",
+		"/site/css/style.css":  "style",
+		"/site/img/logo.png":   "logo",
+		"/site/same/file.txt":  "same",
+		"/site/grown/file.txt": "grown",
+	} {
+		WriteFile(src, name, []byte(content), 0644)
+		src.Chtimes(name, old, old)
+	}
+	for name, content := range map[string]string{
+		"/site/index.html":     "stale",
+		"/site/same/file.txt":  "same",
+		"/site/grown/file.txt": "grow",
+		"/site/extra/old.txt":  "extra",
+		"/site/old.txt":        "extra",
+	} {
+		WriteFile(dst, name, []byte(content), 0644)
+		dst.Chtimes(name, old, old)
+	}
+	return src, dst
+}
+
+func TestSync(t *testing.T) {
+	src, dst := syncFixture(t)
+	want := []SyncAction{
+		{Op: SyncCreate, Path: "/site/css"},
+		{Op: SyncCreate, Path: "/site/css/style.css"},
+		{Op: SyncUpdate, Path: "/site/grown/file.txt"},
+		{Op: SyncCreate, Path: "/site/img"},
+		{Op: SyncCreate, Path: "/site/img/logo.png"},
+		{Op: SyncDelete, Path: "/site/extra"},
+		{Op: SyncDelete, Path: "/site/old.txt"},
+	}
+
+	actions, err := Sync(src, dst, "/site", &SyncOptions{Delete: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("dry run: got %v, expected %v", actions, want)
+	}
+	checkFile(t, dst, "/site/old.txt", "extra")
+	checkMissing(t, dst, "/site/css")
+
+	actions, err = Sync(src, dst, "/site", &SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v, expected %v", actions, want)
+	}
+	checkFile(t, dst, "/site/css/style.css", "style")
+	checkFile(t, dst, "/site/grown/file.txt", "grown")
+	// same size and not newer
+	checkFile(t, dst, "/site/index.html", "stale")
+	checkMissing(t, dst, "/site/extra")
+	checkMissing(t, dst, "/site/old.txt")
+
+	if actions, err := Sync(src, dst, "/site", &SyncOptions{Delete: true}); err != nil || len(actions) != 0 {
+		t.Errorf("second sync: got %v, %v", actions, err)
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	src, dst := syncFixture(t)
+	actions, err := Sync(src, dst, "/site/index.html", &SyncOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncAction{{Op: SyncUpdate, Path: "/site/index.html"}}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %v, expected %v", actions, want)
+	}
+	checkFile(t, dst, "/site/index.html", "index")
+}
+
+// renameFailFs fails renaming anything to the name fail.
+type renameFailFs struct {
+	*MemMapFs
+	fail string
+}
+
+func (fs renameFailFs) Rename(oldname, newname string) error {
+	if newname == fs.fail {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EIO}
+	}
+	return fs.MemMapFs.Rename(oldname, newname)
+}
+
+func TestSyncPartialFailure(t *testing.T) {
+	src, dst := syncFixture(t)
+	WriteFile(src, "/site/grown/file.txt", []byte("grown again"), 0644)
+	actions, err := Sync(src, renameFailFs{dst, "/site/grown/file.txt"}, "/site", nil)
+	serr, ok := err.(*SyncError)
+	if !ok || len(serr.Failed) != 1 || serr.Failed[0].Path != "/site/grown/file.txt" {
+		t.Fatalf("got %v, expected a SyncError for /site/grown/file.txt", err)
+	}
+	if len(actions) != 5 {
+		t.Errorf("got %d actions, expected 5: %v", len(actions), actions)
+	}
+	checkFile(t, dst, "/site/grown/file.txt", "grow")
+	checkFile(t, dst, "/site/img/logo.png", "logo")
+
+	names, _ := readDirNames(dst, "/site/grown")
+	for _, name := range names {
+		if strings.HasSuffix(name, ".tmp") {
+			t.Errorf("temporary file %s left behind", name)
+		}
+	}
+}
+
+func TestSyncUnreadableSource(t *testing.T) {
+	mfs, dst := syncFixture(t)
+	WriteFile(dst, "/site/img/kept.png", []byte("kept"), 0644)
+	src, err := NewFaultFs(mfs, []FaultRule{
+		{Op: "readdirnames", Pattern: "/site/img", Err: syscall.EACCES},
+		{Op: "lstat", Pattern: "/site/css/style.css", Err: syscall.EIO},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions, err := Sync(src, dst, "/site", &SyncOptions{Delete: true})
+	serr, ok := err.(*SyncError)
+	if !ok || len(serr.Failed) != 2 {
+		t.Fatalf("got %v, expected a SyncError for the two unreadable entries", err)
+	}
+	for _, action := range serr.Failed {
+		if action.Path != "/site/img" && action.Path != "/site/css/style.css" {
+			t.Errorf("unexpected failure %v", action)
+		}
+	}
+	if len(actions) != 6 {
+		t.Errorf("got %d actions, expected 6: %v", len(actions), actions)
+	}
+	// the rest is synced, and what couldn't be read is left alone
+	checkFile(t, dst, "/site/grown/file.txt", "grown")
+	checkFile(t, dst, "/site/img/kept.png", "kept")
+	for _, name := range []string{"/site/old.txt", "/site/extra"} {
+		if _, err := dst.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s: got %v, expected it to be deleted", name, err)
+		}
+	}
+}
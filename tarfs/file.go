@@ -3,6 +3,7 @@ package tarfs
 import (
 	"archive/tar"
 	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -16,6 +17,7 @@ type File struct {
 	data   *bytes.Reader
 	closed bool
 	fs     *Fs
+	dirOff int
 }
 
 func (f *File) Close() error {
@@ -113,10 +115,23 @@ func (f *File) Readdir(count int) ([]os.FileInfo, error) {
 
 		f := d[n]
 		fi = append(fi, f.h.FileInfo())
-		if count > 0 && len(fi) >= count {
-			break
+	}
+
+	// continue where the previous call stopped, like os.File does
+	if f.dirOff < len(fi) {
+		fi = fi[f.dirOff:]
+	} else {
+		fi = nil
+	}
+	if count > 0 {
+		if len(fi) == 0 {
+			return nil, io.EOF
+		}
+		if len(fi) > count {
+			fi = fi[:count]
 		}
 	}
+	f.dirOff += len(fi)
 
 	return fi, nil
 }
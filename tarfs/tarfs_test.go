@@ -14,6 +14,7 @@ import (
 	"testing"
 
 	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
 )
 
 var files = []struct {
@@ -319,6 +320,15 @@ func TestReaddir(t *testing.T) {
 			t.Errorf("%v: children, got '%v', expected '%v'", d.name, names, d.children)
 		}
 
+		// the directory has been read to the end
+		if _, err := dir.Readdir(1); err != io.EOF {
+			t.Errorf("%v: expected io.EOF, got %v", d.name, err)
+		}
+
+		dir, err = afs.Open(d.name)
+		if err != nil {
+			t.Fatal(err)
+		}
 		fi, err = dir.Readdir(1)
 		if err != nil {
 			t.Fatal(err)
@@ -361,6 +371,15 @@ func TestReaddirnames(t *testing.T) {
 			t.Errorf("%v: children, got '%v', expected '%v'", d.name, names, d.children)
 		}
 
+		// the directory has been read to the end
+		if _, err := dir.Readdirnames(1); err != io.EOF {
+			t.Errorf("%v: expected io.EOF, got %v", d.name, err)
+		}
+
+		dir, err = afs.Open(d.name)
+		if err != nil {
+			t.Fatal(err)
+		}
 		names, err = dir.Readdirnames(1)
 		if err != nil {
 			t.Fatal(err)
@@ -404,3 +423,14 @@ func TestGlob(t *testing.T) {
 		}
 	}
 }
+
+func TestTarFSConformance(t *testing.T) {
+	aferotest.Run(t, func() afero.Fs {
+		tf, err := os.Open("testdata/t.tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tf.Close()
+		return New(tar.NewReader(tf))
+	}, aferotest.Write)
+}
@@ -270,16 +270,9 @@ func (f *UnionFile) WriteString(s string) (n int, err error) {
 
 func copyFile(base Fs, layer Fs, name string, bfh File) error {
 	// First make sure the directory exists
-	exists, err := Exists(layer, filepath.Dir(name))
-	if err != nil {
+	if err := copyDirsToLayer(base, layer, filepath.Dir(name)); err != nil {
 		return err
 	}
-	if !exists {
-		err = layer.MkdirAll(filepath.Dir(name), 0777) // FIXME?
-		if err != nil {
-			return err
-		}
-	}
 
 	// Create the file on the overlay
 	lfh, err := layer.Create(name)
@@ -307,9 +300,38 @@ func copyFile(base Fs, layer Fs, name string, bfh File) error {
 		lfh.Close()
 		return err
 	}
+	if err := layer.Chmod(name, bfi.Mode()); err != nil {
+		return err
+	}
 	return layer.Chtimes(name, bfi.ModTime(), bfi.ModTime())
 }
 
+// copyDirsToLayer creates dir and its missing parents in the layer, with the
+// permissions and modification times they have in the base, so that they
+// don't show as changed.
+func copyDirsToLayer(base Fs, layer Fs, dir string) error {
+	exists, err := Exists(layer, dir)
+	if err != nil || exists {
+		return err
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := copyDirsToLayer(base, layer, parent); err != nil {
+			return err
+		}
+	}
+	bfi, err := base.Stat(dir)
+	if err != nil || !bfi.IsDir() {
+		return layer.MkdirAll(dir, 0777)
+	}
+	if err := layer.Mkdir(dir, bfi.Mode().Perm()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := layer.Chmod(dir, bfi.Mode()); err != nil {
+		return err
+	}
+	return layer.Chtimes(dir, bfi.ModTime(), bfi.ModTime())
+}
+
 func copyToLayer(base Fs, layer Fs, name string) error {
 	bfh, err := base.Open(name)
 	if err != nil {
@@ -0,0 +1,88 @@
+// +build go1.16
+
+package afero
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+// SkipAll can be returned by the function passed to WalkDir or ParallelWalk
+// to stop the walk without an error. It is fs.SkipAll where that exists.
+var SkipAll = skipAll
+
+// WalkDir walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, including root, like fs.WalkDir. Unlike Walk, it
+// describes the entries of directories with what Readdir returns instead of
+// an Lstat each, which saves a round trip per entry on remote filesystems.
+// The files are walked in lexical order.
+// WalkDir does not follow symbolic links.
+func (a Afero) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return WalkDir(a.Fs, root, fn)
+}
+
+func WalkDir(fsys Fs, root string, fn fs.WalkDirFunc) error {
+	info, err := lstatIfPossible(fsys, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fsys, root, dirEntry{info}, fn)
+	}
+	if err == filepath.SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkDir recursively descends path, calling fn
+// adapted from https://golang.org/src/io/fs/walk.go
+func walkDir(fsys Fs, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := readDirEntries(fsys, path)
+	if err != nil {
+		err = fn(path, d, err)
+		if err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if err := walkDir(fsys, filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readDirEntries reads the directory named by dirname and returns its
+// entries sorted by name.
+func readDirEntries(fsys Fs, dirname string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = dirEntry{info}
+	}
+	return entries, nil
+}
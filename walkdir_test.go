@@ -0,0 +1,113 @@
+// +build go1.16
+
+package afero
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func walkFixture(t *testing.T) *MemMapFs {
+	t.Helper()
+	fs := &MemMapFs{}
+	for _, name := range []string{"/w/b/x", "/w/a/y", "/w/a/z/deep", "/w/c", "/w/skip/hidden"} {
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+// statCountFs counts the calls to Stat and Lstat.
+type statCountFs struct {
+	*MemMapFs
+	stats int32
+}
+
+func (fs *statCountFs) Stat(name string) (os.FileInfo, error) {
+	atomic.AddInt32(&fs.stats, 1)
+	return fs.MemMapFs.Stat(name)
+}
+
+func (fs *statCountFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	atomic.AddInt32(&fs.stats, 1)
+	return fs.MemMapFs.LstatIfPossible(name)
+}
+
+func TestWalkDir(t *testing.T) {
+	mfs := walkFixture(t)
+	var walked []string
+	err := Walk(mfs, "/w", func(path string, _ os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &statCountFs{MemMapFs: mfs}
+	var got []string
+	err = WalkDir(counting, "/w", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() != filepath.Base(path) {
+			t.Errorf("%s: got name %s", path, d.Name())
+		}
+		if info, _ := d.Info(); info.IsDir() != d.IsDir() {
+			t.Errorf("%s: inconsistent entry", path)
+		}
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, walked) {
+		t.Errorf("got %v, expected %v as Walk", got, walked)
+	}
+	if counting.stats != 1 {
+		t.Errorf("got %d stats, expected only the root's", counting.stats)
+	}
+}
+
+func TestWalkDirSkip(t *testing.T) {
+	mfs := walkFixture(t)
+	var got []string
+	err := WalkDir(mfs, "/w", func(path string, d fs.DirEntry, err error) error {
+		got = append(got, path)
+		switch path {
+		case filepath.FromSlash("/w/a/y"):
+			// skips the rest of /w/a
+			return filepath.SkipDir
+		case filepath.FromSlash("/w/c"):
+			return SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the root is passed on as given
+	expected := []string{"/w"}
+	for _, name := range []string{"/w/a", "/w/a/y", "/w/b", "/w/b/x", "/w/c"} {
+		expected = append(expected, filepath.FromSlash(name))
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	err = WalkDir(mfs, "/missing", func(path string, d fs.DirEntry, err error) error {
+		if d != nil {
+			t.Errorf("got entry %v for a missing root", d)
+		}
+		return err
+	})
+	if !os.IsNotExist(err) {
+		t.Errorf("got %v, expected a missing root", err)
+	}
+}
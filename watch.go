@@ -0,0 +1,257 @@
+package afero
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Watcher is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// Watch returns a channel receiving an Event for every change to path, or
+// to the entries of path if it is a directory. With recursive set, changes
+// anywhere below path are reported as well. Events are queued, so a slow
+// reader never blocks changes to the filesystem, but only up to a limit:
+// past it the events are dropped and replaced by one with Overflow. Unwatch
+// stops a watch and closes its channel.
+type Watcher interface {
+	Watch(path string, recursive bool) (<-chan Event, error)
+	Unwatch(events <-chan Event) error
+}
+
+// ErrNoWatch is the error that will be wrapped in an os.PathError if a file
+// system does not support watching either directly or through its delegated
+// filesystem.
+var ErrNoWatch = errors.New("watch not supported")
+
+// ErrNotWatching is returned by Unwatch for channels it does not know,
+// including those which have been unwatched already.
+var ErrNotWatching = errors.New("not watching")
+
+// Op describes a set of changes.
+type Op uint32
+
+// The changes reported by watchers. Renames are reported as Rename for the
+// old name followed by Create for the new one, attribute changes as Chmod.
+// Overflow, for the path watched, tells that events were dropped because
+// the channel wasn't read: what is watched has to be looked at again.
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+	Chmod
+	Overflow
+)
+
+var opNames = []string{"CREATE", "WRITE", "REMOVE", "RENAME", "CHMOD", "OVERFLOW"}
+
+// maxQueuedEvents is how many events a watch keeps for its reader.
+const maxQueuedEvents = 4096
+
+func (op Op) String() string {
+	var names []string
+	for i, name := range opNames {
+		if op&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// Event is a change to the file Name.
+type Event struct {
+	Name string
+	Op   Op
+}
+
+func (e Event) String() string {
+	return e.Name + ": " + e.Op.String()
+}
+
+// watchPath makes name absolute the way the in-memory filesystems do, so
+// that relative and absolute names of the same file match.
+func watchPath(name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+	return filepath.Join(FilePathSeparator, name)
+}
+
+// watch is a single watch registered with watches. Events are appended to
+// queue by notify and handed to ch by a goroutine of its own.
+type watch struct {
+	path      string
+	recursive bool
+	ch        chan Event
+
+	mu    sync.Mutex
+	queue []Event
+	wake  chan struct{}
+	done  chan struct{}
+}
+
+func (w *watch) matches(name string) bool {
+	return name == w.path || filepath.Dir(name) == w.path || w.recursive && isWithin(w.path, name)
+}
+
+func (w *watch) push(e Event) {
+	w.mu.Lock()
+	switch n := len(w.queue); {
+	case n < maxQueuedEvents:
+		w.queue = append(w.queue, e)
+	case w.queue[n-1].Op != Overflow:
+		w.queue = append(w.queue, Event{Name: w.path, Op: Overflow})
+	}
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watch) run() {
+	defer close(w.ch)
+	for {
+		w.mu.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+		for _, e := range queue {
+			select {
+			case w.ch <- e:
+			case <-w.done:
+				return
+			}
+		}
+		select {
+		case <-w.wake:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// watches keeps track of the watches on a filesystem. The zero value is ready
+// to use.
+type watches struct {
+	mu   sync.Mutex
+	list []*watch
+}
+
+func (ws *watches) add(path string, recursive bool) <-chan Event {
+	w := &watch{
+		path:      watchPath(path),
+		recursive: recursive,
+		ch:        make(chan Event),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	ws.mu.Lock()
+	ws.list = append(ws.list, w)
+	ws.mu.Unlock()
+	go w.run()
+	return w.ch
+}
+
+func (ws *watches) remove(events <-chan Event) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for i, w := range ws.list {
+		if w.ch == events {
+			ws.list = append(ws.list[:i], ws.list[i+1:]...)
+			close(w.done)
+			return nil
+		}
+	}
+	return ErrNotWatching
+}
+
+func (ws *watches) removeAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, w := range ws.list {
+		close(w.done)
+	}
+	ws.list = nil
+}
+
+// active reports whether there are any watches, so that callers can avoid
+// collecting events nobody receives.
+func (ws *watches) active() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.list) > 0
+}
+
+func (ws *watches) notify(name string, op Op) {
+	name = watchPath(name)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, w := range ws.list {
+		if w.matches(name) {
+			w.push(Event{Name: name, Op: op})
+		}
+	}
+}
+
+// watchForwarder lets wrapping filesystems forward the events of their
+// source, with names translated by the wrapper. The zero value is ready to
+// use.
+type watchForwarder struct {
+	mu      sync.Mutex
+	sources map[<-chan Event]forwarded
+}
+
+type forwarded struct {
+	source <-chan Event
+	done   chan struct{}
+}
+
+// forward returns a channel receiving the events of source with their names
+// passed through translate. Events for which translate returns false are
+// dropped.
+func (wf *watchForwarder) forward(source <-chan Event, translate func(name string) (string, bool)) <-chan Event {
+	ch := make(chan Event)
+	done := make(chan struct{})
+	wf.mu.Lock()
+	if wf.sources == nil {
+		wf.sources = make(map[<-chan Event]forwarded)
+	}
+	wf.sources[ch] = forwarded{source: source, done: done}
+	wf.mu.Unlock()
+
+	go func() {
+		defer close(ch)
+		for e := range source {
+			name, ok := translate(e.Name)
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- Event{Name: name, Op: e.Op}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// remove stops forwarding events to the channel and returns the channel of
+// the source, which the caller must unwatch.
+func (wf *watchForwarder) remove(events <-chan Event) (<-chan Event, error) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	f, ok := wf.sources[events]
+	if !ok {
+		return nil, ErrNotWatching
+	}
+	delete(wf.sources, events)
+	close(f.done)
+	return f.source, nil
+}
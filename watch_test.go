@@ -0,0 +1,191 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpString(t *testing.T) {
+	for op, want := range map[Op]string{
+		0:             "0",
+		Create:        "CREATE",
+		Write | Chmod: "WRITE|CHMOD",
+		Overflow:      "OVERFLOW",
+	} {
+		if got := op.String(); got != want {
+			t.Errorf("%d: got %q, expected %q", uint32(op), got, want)
+		}
+	}
+}
+
+// expectEvents reads len(want) events from events and fails on any
+// difference, or if they do not arrive in time.
+func expectEvents(t *testing.T, events <-chan Event, want ...Event) {
+	t.Helper()
+	for _, w := range want {
+		w.Name = filepath.FromSlash(w.Name)
+		select {
+		case e := <-events:
+			if e != w {
+				t.Fatalf("got event %v, expected %v", e, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %v", w)
+		}
+	}
+}
+
+func expectNoEvents(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Fatalf("got unexpected event %v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestMemMapFsWatch(t *testing.T) {
+	fs := &MemMapFs{}
+	if err := fs.MkdirAll("/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	events, err := fs.Watch("/dir", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Create("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/dir/file", Create})
+	f.Write([]byte("a"))
+	f.WriteString("b")
+	expectNoEvents(t, events)
+	f.Close()
+	expectEvents(t, events, Event{"/dir/file", Write})
+
+	f, err = fs.OpenFile("/dir/file", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Sync()
+	expectEvents(t, events, Event{"/dir/file", Write})
+	f.Close()
+
+	if err := fs.Chmod("/dir/file", 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/dir/file", Chmod})
+	if err := fs.Rename("/dir/file", "/dir/moved"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/dir/file", Rename}, Event{"/dir/moved", Create})
+	if err := fs.Remove("/dir/moved"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/dir/moved", Remove})
+
+	// not recursive
+	if _, err := fs.Create("/dir/sub/file"); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEvents(t, events)
+
+	if err := fs.Unwatch(events); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("channel not closed by Unwatch")
+	}
+	if err := fs.Unwatch(events); err != ErrNotWatching {
+		t.Errorf("got %v, expected %v", err, ErrNotWatching)
+	}
+	if _, err := fs.Watch("/missing", false); !os.IsNotExist(err) {
+		t.Errorf("watching a missing path: got %v", err)
+	}
+}
+
+func TestMemMapFsWatchRecursive(t *testing.T) {
+	fs := &MemMapFs{}
+	events, err := fs.Watch("/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Unwatch(events)
+
+	if _, err := fs.Create("/a/b/file"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/a", Create}, Event{"/a/b", Create}, Event{"/a/b/file", Create})
+	if err := fs.RemoveAll("/a"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/a/b/file", Remove}, Event{"/a/b", Remove}, Event{"/a", Remove})
+}
+
+func TestWatchOverflow(t *testing.T) {
+	var ws watches
+	events := ws.add("/dir", false)
+	defer ws.removeAll()
+	for i := 0; i < maxQueuedEvents+10; i++ {
+		ws.notify("/dir/file", Write)
+	}
+
+	n := 0
+	for e := range events {
+		if e.Op == Overflow {
+			if e.Name != filepath.FromSlash("/dir") {
+				t.Errorf("overflow reported for %s", e.Name)
+			}
+			break
+		}
+		if n++; n > maxQueuedEvents+1 {
+			t.Fatalf("got %d events without an overflow", n)
+		}
+	}
+	expectNoEvents(t, events)
+
+	// events are queued again once read
+	ws.notify("/dir/file", Chmod)
+	expectEvents(t, events, Event{"/dir/file", Chmod})
+}
+
+func TestBasePathFsWatch(t *testing.T) {
+	mfs := &MemMapFs{}
+	if err := mfs.MkdirAll("/base/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewBasePathFs(mfs, "/base").(*BasePathFs)
+	events, err := fs.Watch("/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mfs.Create("/outside"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Create("/dir/file"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/dir/file", Create})
+	if err := mfs.Chmod("/base", 0700); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{"/", Chmod})
+
+	if err := fs.Unwatch(events); err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+	if mfs.watches.active() {
+		t.Error("source watch not removed")
+	}
+
+	if _, err := NewBasePathFs(NewCopyOnWriteFs(NewMemMapFs(), NewMemMapFs()), "/").(*BasePathFs).Watch("/", false); err == nil {
+		t.Error("expected an error from a source which can't watch")
+	}
+}
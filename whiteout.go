@@ -0,0 +1,106 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Whiteouts records in the layer of a CopyOnWriteFs which files of the base
+// have been deleted from the union, so that a layer can hide files it does
+// not contain itself. The records are stored in the layer, the way they are
+// stored is up to the implementation.
+type Whiteouts interface {
+	// Whiteout hides the file or directory name of the base.
+	Whiteout(layer Fs, name string) error
+
+	// Opaque hides everything of the base below the directory name of the
+	// layer.
+	Opaque(layer Fs, name string) error
+
+	// IsHidden reports whether name of the base is hidden, either by a
+	// whiteout of itself or of one of its parents, or by an opaque parent.
+	IsHidden(layer Fs, name string) (bool, error)
+
+	// Filter takes the entries of the same directory in the layer and in the
+	// base. It returns the layer entries without those recording whiteouts,
+	// and the base entries which are not hidden.
+	Filter(lofi, bofi []os.FileInfo) (layer, base []os.FileInfo)
+}
+
+const (
+	// WhiteoutPrefix starts the name of the file recording a whiteout in
+	// PrefixWhiteouts.
+	WhiteoutPrefix = ".wh."
+
+	// WhiteoutOpaque is the name of the file marking an opaque directory in
+	// PrefixWhiteouts.
+	WhiteoutOpaque = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// PrefixWhiteouts stores whiteouts as empty files in the layer, like OCI
+// image layers do: removing "dir/file" creates "dir/.wh.file", and an opaque
+// directory contains a ".wh..wh..opq" file. As these are plain files, any Fs
+// can be used as the layer.
+type PrefixWhiteouts struct{}
+
+var _ Whiteouts = PrefixWhiteouts{}
+
+func (PrefixWhiteouts) Whiteout(layer Fs, name string) error {
+	return createMarker(layer, filepath.Dir(name), WhiteoutPrefix+filepath.Base(name))
+}
+
+func (PrefixWhiteouts) Opaque(layer Fs, name string) error {
+	return createMarker(layer, name, WhiteoutOpaque)
+}
+
+func createMarker(layer Fs, dir, name string) error {
+	if err := layer.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	f, err := layer.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (PrefixWhiteouts) IsHidden(layer Fs, name string) (bool, error) {
+	name = filepath.Clean(name)
+	for p := name; ; p = filepath.Dir(p) {
+		dir := filepath.Dir(p)
+		if dir == p {
+			return false, nil
+		}
+		if exists, err := Exists(layer, filepath.Join(dir, WhiteoutPrefix+filepath.Base(p))); err != nil || exists {
+			return exists, err
+		}
+		if exists, err := Exists(layer, filepath.Join(dir, WhiteoutOpaque)); err != nil || exists {
+			return exists, err
+		}
+	}
+}
+
+func (PrefixWhiteouts) Filter(lofi, bofi []os.FileInfo) (layer, base []os.FileInfo) {
+	hidden := make(map[string]bool)
+	opaque := false
+	for _, fi := range lofi {
+		switch name := fi.Name(); {
+		case name == WhiteoutOpaque:
+			opaque = true
+		case strings.HasPrefix(name, WhiteoutPrefix):
+			hidden[strings.TrimPrefix(name, WhiteoutPrefix)] = true
+		default:
+			layer = append(layer, fi)
+		}
+	}
+	if opaque {
+		return layer, nil
+	}
+	for _, fi := range bofi {
+		if !hidden[fi.Name()] {
+			base = append(base, fi)
+		}
+	}
+	return layer, base
+}
@@ -0,0 +1,62 @@
+package afero
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPrefixWhiteouts(t *testing.T) {
+	layer := NewMemMapFs()
+	w := PrefixWhiteouts{}
+
+	if err := w.Whiteout(layer, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Opaque(layer, "/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		hidden bool
+	}{
+		{"/a", false},
+		{"/a/b", true},
+		{"/a/b/deeper", true},
+		{"/a/bb", false},
+		{"/c", false},
+		{"/c/d", true},
+		{"/c/d/e", true},
+	} {
+		hidden, err := w.IsHidden(layer, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hidden != test.hidden {
+			t.Errorf("%s: expected hidden %t, got %t", test.name, test.hidden, hidden)
+		}
+	}
+
+	entries := func(fs Fs, name string) []os.FileInfo {
+		fis, err := ReadDir(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fis
+	}
+	base := NewMemMapFs()
+	for _, name := range []string{"/a/b", "/a/bb", "/c/d"} {
+		if err := WriteFile(base, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lofi, bofi := w.Filter(entries(layer, "/a"), entries(base, "/a"))
+	if len(lofi) != 0 || len(bofi) != 1 || bofi[0].Name() != "bb" {
+		t.Errorf("/a: got layer %v, base %v", lofi, bofi)
+	}
+	lofi, bofi = w.Filter(entries(layer, "/c"), entries(base, "/c"))
+	if len(lofi) != 0 || len(bofi) != 0 {
+		t.Errorf("opaque /c: got layer %v, base %v", lofi, bofi)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"syscall"
 
 	"github.com/spf13/afero"
@@ -17,6 +18,7 @@ type File struct {
 	offset        int64
 	isdir, closed bool
 	buf           []byte
+	dirOffset     int
 }
 
 func (f *File) fillBuffer(offset int64) (err error) {
@@ -59,6 +61,9 @@ func (f *File) Read(p []byte) (n int, err error) {
 	if f.closed {
 		return 0, afero.ErrFileClosed
 	}
+	if f.offset >= int64(f.zipfile.UncompressedSize64) {
+		return 0, io.EOF
+	}
 	err = f.fillBuffer(f.offset + int64(len(p)))
 	n = copy(p, f.buf[f.offset:])
 	f.offset += int64(n)
@@ -72,6 +77,9 @@ func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
 	if f.closed {
 		return 0, afero.ErrFileClosed
 	}
+	if off >= int64(f.zipfile.UncompressedSize64) {
+		return 0, io.EOF
+	}
 	err = f.fillBuffer(off + int64(len(p)))
 	n = copy(p, f.buf[int(off):])
 	return
@@ -93,7 +101,7 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	default:
 		return 0, syscall.EINVAL
 	}
-	if offset < 0 || offset > int64(f.zipfile.UncompressedSize64) {
+	if offset < 0 {
 		return 0, afero.ErrOutOfRange
 	}
 	f.offset = offset
@@ -123,32 +131,48 @@ func (f *File) getDirEntries() (map[string]*zip.File, error) {
 	return entries, nil
 }
 
+// Readdir returns the entries of the directory sorted by name, continuing
+// where the previous call stopped. If count > 0, the error at the end of
+// the directory is io.EOF.
 func (f *File) Readdir(count int) (fi []os.FileInfo, err error) {
 	zipfiles, err := f.getDirEntries()
 	if err != nil {
 		return nil, err
 	}
-	for _, zipfile := range zipfiles {
-		fi = append(fi, zipfile.FileInfo())
-		if count > 0 && len(fi) >= count {
-			break
+	names := make([]string, 0, len(zipfiles))
+	for name := range zipfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if f.dirOffset < len(names) {
+		names = names[f.dirOffset:]
+	} else {
+		names = nil
+	}
+	if count > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		if len(names) > count {
+			names = names[:count]
 		}
 	}
-	return
+	for _, name := range names {
+		fi = append(fi, zipfiles[name].FileInfo())
+	}
+	f.dirOffset += len(names)
+	return fi, nil
 }
 
 func (f *File) Readdirnames(count int) (names []string, err error) {
-	zipfiles, err := f.getDirEntries()
+	fi, err := f.Readdir(count)
 	if err != nil {
 		return nil, err
 	}
-	for filename := range zipfiles {
-		names = append(names, filename)
-		if count > 0 && len(names) >= count {
-			break
-		}
+	for _, f := range fi {
+		names = append(names, f.Name())
 	}
-	return
+	return names, nil
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
@@ -2,6 +2,7 @@ package zipfs
 
 import (
 	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
 
 	"archive/zip"
 	"path/filepath"
@@ -101,3 +102,12 @@ func TestZipFS(t *testing.T) {
 		}
 	}
 }
+
+func TestZipFSConformance(t *testing.T) {
+	zrc, err := zip.OpenReader("testdata/t.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zrc.Close()
+	aferotest.Run(t, func() afero.Fs { return New(&zrc.Reader) }, aferotest.Write)
+}